@@ -0,0 +1,447 @@
+// brokerctl administers the instances of the broker through its store and clusters, it takes
+// the mysql and cluster flags of the broker.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/broker"
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+var options struct {
+	broker.Options
+
+	Output string
+	File   string
+	Apply  bool
+	Yes    bool
+
+	ServiceID  string
+	Parameters string
+	Context    string
+	Server     bool
+	Backup     string
+	Binding    string
+	Grace      string
+
+	Filter dao.InstanceFilter
+}
+
+func init() {
+	flag.StringVar(&options.Output, "o", "table", "output format of 'list' and 'backups', 'table' or 'json'")
+	flag.StringVar(&options.File, "file", "", "file of 'export' and 'import', stdout and stdin when empty")
+	flag.BoolVar(&options.Apply, "apply", false, "use with 'rerender' to update the instance to the rendered template")
+	flag.BoolVar(&options.Yes, "yes", false, "use with 'delete' and 'restore' to confirm them")
+	flag.StringVar(&options.ServiceID, "service-id", "", "service id of 'dry-run'")
+	flag.StringVar(&options.Parameters, "params", "", "json parameters of 'dry-run'")
+	flag.StringVar(&options.Context, "context", "", "json platform context of 'dry-run'")
+	flag.BoolVar(&options.Server, "server", false, "use with 'dry-run' to also submit the manifests to the api server with dryRun=All")
+	flag.StringVar(&options.Backup, "backup", "", "backup id of 'restore'")
+	flag.StringVar(&options.Binding, "binding", "", "binding id of 'rotate'")
+	flag.StringVar(&options.Grace, "grace-period", "", "how long the previous credentials stay valid after 'rotate', the --rotation-grace-period when empty")
+	flag.StringVar(&options.Filter.ServiceName, "service", "", "only the instances of the service name")
+	flag.StringVar(&options.Filter.PlanID, "plan", "", "only the instances of the plan id, the plan id of 'dry-run'")
+	flag.StringVar(&options.Filter.Namespace, "namespace", "", "only the instances in the namespace")
+	flag.StringVar(&options.Filter.Cluster, "cluster", "", "only the instances in the cluster")
+	flag.StringVar(&options.Filter.State, "state", "", "only the instances in the state of their last operation")
+	flag.StringVar(&options.Filter.OrganizationGUID, "org", "", "only the instances of the organization guid")
+	flag.StringVar(&options.Filter.SpaceGUID, "space", "", "only the instances of the space guid")
+	flag.StringVar(&options.Filter.Search, "search", "", "only the instances whose id or name contains the text")
+	broker.AddFlags(&options.Options)
+	flag.Usage = usage
+	flag.Parse()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: brokerctl [flags] <command> [instance id]
+
+Commands:
+  list              list the instances matching the filter flags
+  show <id>         show the record, the parameters and the applied yaml of an instance
+  status <id>       show the stored and the live state of an instance
+  delete <id>       delete the bindings, the kubernetes objects and the record of a stuck
+                    instance, with --yes
+  rerender <id>     render the current template for an instance, --apply updates the instance
+  dry-run [id]      render a provision with --service-id, --plan, --params and --context, or an
+                    update of the instance id, --server also submits it with dryRun=All
+  backup <id>       start a backup of an instance to the backup target
+  backups <id>      list the backups of an instance
+  restore <id>      replace the data of an instance by the backup of --backup, with --yes
+  rotate <id>       issue new credentials for the binding of --binding, the previous ones are
+                    revoked after --grace-period
+  export            write the instances matching the filter flags as json lines
+  import            insert the exported instances which do not exist
+  reencrypt         encrypt the stored parameters, yaml and binding credentials with the latest
+                    key of --encryption-key-file or --encryption-key-secret
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	command := flag.Arg(0)
+	instanceId := flag.Arg(1)
+
+	switch command {
+	case "list", "export", "import", "dry-run", "reencrypt":
+	case "show", "status", "delete", "rerender", "backup", "backups", "restore", "rotate":
+		if instanceId == "" {
+			return fmt.Errorf("%s requires an instance id", command)
+		}
+	default:
+		usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	d, err := broker.NewStore(options.Options)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	switch command {
+	case "list":
+		return list(d)
+	case "export":
+		return export(d)
+	case "import":
+		return importInstances(d)
+	case "reencrypt":
+		count, err := d.Reencrypt()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d rows re-encrypted with the latest key\n", count)
+		return nil
+	case "show":
+		instance, err := selectInstance(d, instanceId)
+		if err != nil {
+			return err
+		}
+		return show(instance)
+	}
+
+	// the other commands need the catalog and the clusters of the broker
+	b, err := broker.NewBusinessLogic(options.Options, nil)
+	if err != nil {
+		return err
+	}
+	if command == "dry-run" {
+		return dryRun(b, instanceId)
+	}
+
+	instance, err := selectInstance(b.DB(), instanceId)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "status":
+		state, err := b.InstanceStatus(ctx, instance)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("stored state: %s\nlive state:   %s\n", instance.State, state)
+		return nil
+	case "delete":
+		if !options.Yes {
+			return fmt.Errorf("instance %s in %s/%s would be deleted, confirm with --yes", instance.InstanceID, instance.Cluster, instance.Namespace)
+		}
+		err = b.ForceDeleteInstance(ctx, instance, identity())
+		if err != nil {
+			return describe(err)
+		}
+		fmt.Printf("instance %s deleted\n", instance.InstanceID)
+		return nil
+	case "backup":
+		backup, err := b.BackupInstance(ctx, instance, identity())
+		if err != nil {
+			return describe(err)
+		}
+		fmt.Printf("backup %s of instance %s started, it is processing until its jobs are done\n", backup.BackupID, instance.InstanceID)
+		return nil
+	case "backups":
+		backups, err := b.ListBackups(ctx, instance)
+		if err != nil {
+			return describe(err)
+		}
+		return listBackups(backups)
+	case "restore":
+		if options.Backup == "" {
+			return fmt.Errorf("restore requires --backup")
+		}
+		if !options.Yes {
+			return fmt.Errorf("the data of instance %s would be replaced by backup %s, confirm with --yes", instance.InstanceID, options.Backup)
+		}
+		err = b.RestoreInstance(ctx, instance, options.Backup, identity())
+		if err != nil {
+			return describe(err)
+		}
+		fmt.Printf("restore of backup %s started, instance %s is processing until its pods are ready\n", options.Backup, instance.InstanceID)
+		return nil
+	case "rotate":
+		if options.Binding == "" {
+			return fmt.Errorf("rotate requires --binding")
+		}
+		grace := time.Duration(-1)
+		if options.Grace != "" {
+			grace, err = time.ParseDuration(options.Grace)
+			if err != nil {
+				return fmt.Errorf("--grace-period: %v", err)
+			}
+		}
+		binding, err := b.RotateBinding(ctx, instance, options.Binding, grace, identity())
+		if err != nil {
+			return describe(err)
+		}
+		if binding.RevokeAt == "" {
+			fmt.Printf("credentials of binding %s rotated, the previous ones are revoked\n", binding.BindingID)
+			return nil
+		}
+		fmt.Printf("credentials of binding %s rotated, the previous ones are revoked at %s\n", binding.BindingID, binding.RevokeAt)
+		return nil
+	default:
+		rendered, err := b.RenderInstance(ctx, instance)
+		if err != nil {
+			return err
+		}
+		fmt.Println(rendered)
+		if !options.Apply {
+			return nil
+		}
+		err = b.ReapplyInstance(instance, identity())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "instance %s updated, it is processing until its pods are ready\n", instance.InstanceID)
+		return nil
+	}
+}
+
+func dryRun(b *broker.BusinessLogic, instanceId string) error {
+	request := &broker.DryRunRequest{
+		ServiceID:  options.ServiceID,
+		PlanID:     options.Filter.PlanID,
+		InstanceID: instanceId,
+		Server:     options.Server,
+	}
+	if options.Parameters != "" {
+		err := json.Unmarshal([]byte(options.Parameters), &request.Parameters)
+		if err != nil {
+			return fmt.Errorf("--params is not a json object: %v", err)
+		}
+	}
+	if options.Context != "" {
+		err := json.Unmarshal([]byte(options.Context), &request.Context)
+		if err != nil {
+			return fmt.Errorf("--context is not a json object: %v", err)
+		}
+	}
+
+	response, err := b.DryRun(context.Background(), request)
+	if err != nil {
+		return describe(err)
+	}
+
+	fmt.Println(response.Manifests)
+	fmt.Fprintf(os.Stderr, "rendered the %s in %s/%s\n", response.Operation, response.Cluster, response.Namespace)
+	failed := len(response.Unresolved) != 0
+	if failed {
+		fmt.Fprintf(os.Stderr, "unresolved variables: %v\n", response.Unresolved)
+	}
+	for _, object := range response.Objects {
+		if object.Error != "" {
+			failed = true
+			fmt.Fprintf(os.Stderr, "%s %s %s/%s: %s\n", object.Operation, object.Kind, object.Namespace, object.Name, object.Error)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s %s %s/%s: ok\n", object.Operation, object.Kind, object.Namespace, object.Name)
+		}
+	}
+	if failed {
+		return fmt.Errorf("dry run failed")
+	}
+	return nil
+}
+
+// describe returns the description the broker gives the platform for err.
+func describe(err error) error {
+	if statusErr, ok := osb.IsHTTPError(err); ok && statusErr.Description != nil {
+		return fmt.Errorf("%s", *statusErr.Description)
+	}
+	return err
+}
+
+// identity names the administrator in the audit trail.
+func identity() *osb.OriginatingIdentity {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return &osb.OriginatingIdentity{Platform: "brokerctl", Value: name}
+}
+
+func selectInstance(d dao.Store, instanceId string) (*dao.Instance, error) {
+	instance, err := d.SelectInstance(instanceId)
+	if err != nil {
+		return nil, err
+	}
+	if instance.InstanceID == "" {
+		return nil, fmt.Errorf("instance %s is not found", instanceId)
+	}
+	return instance, nil
+}
+
+func list(d dao.Store) error {
+	instances, err := d.ListInstances(&options.Filter)
+	if err != nil {
+		return err
+	}
+
+	if options.Output == "json" {
+		return dao.WriteInstances(os.Stdout, instances)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE ID\tNAME\tSERVICE\tCLUSTER\tNAMESPACE\tSTATE\tUPDATED AT")
+	for _, i := range instances {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", i.InstanceID, i.InstanceName, i.ServiceName, i.Cluster, i.Namespace, i.State, i.UpdatedAt)
+	}
+	return w.Flush()
+}
+
+func listBackups(backups []*dao.Backup) error {
+	if options.Output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, backup := range backups {
+			err := encoder.Encode(backup)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BACKUP ID\tSTATE\tRESTORE STATE\tLOCATION\tCREATED AT\tDESCRIPTION")
+	for _, backup := range backups {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", backup.BackupID, backup.State, backup.RestoreState, backup.Location, backup.CreatedAt, backup.Description)
+	}
+	return w.Flush()
+}
+
+func show(instance *dao.Instance) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, field := range [][2]string{
+		{"Instance ID", instance.InstanceID},
+		{"Name", instance.InstanceName},
+		{"Service", instance.ServiceName + " (" + instance.ServiceID + ")"},
+		{"Plan", instance.PlanID},
+		{"Cluster", instance.Cluster},
+		{"Namespace", instance.Namespace},
+		{"Organization", instance.OrganizationGUID},
+		{"Space", instance.SpaceGUID},
+		{"State", instance.State},
+		{"Created At", instance.CreatedAt},
+		{"Updated At", instance.UpdatedAt},
+		{"Context", instance.Context},
+	} {
+		fmt.Fprintf(w, "%s:\t%s\n", field[0], field[1])
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Parameters:")
+	var params interface{}
+	if instance.Parameters != "" {
+		err = json.Unmarshal([]byte(instance.Parameters), &params)
+		if err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+
+	fmt.Println("Yaml:")
+	fmt.Println(instance.Yaml)
+	return nil
+}
+
+func export(d dao.Store) error {
+	instances, err := d.ListInstances(&options.Filter)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if options.File != "" {
+		file, err := os.Create(options.File)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return dao.WriteInstances(w, instances)
+}
+
+// importInstances inserts the exported instances, the instances which exist are kept as they are.
+func importInstances(d dao.Store) error {
+	var r io.Reader = os.Stdin
+	if options.File != "" {
+		file, err := os.Open(options.File)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	instances, err := dao.ReadInstances(r)
+	if err != nil {
+		return err
+	}
+
+	imported := 0
+	for _, instance := range instances {
+		existing, err := d.SelectInstance(instance.InstanceID)
+		if err != nil {
+			return err
+		}
+		if existing.InstanceID != "" {
+			fmt.Fprintf(os.Stderr, "instance %s exists, skipped\n", instance.InstanceID)
+			continue
+		}
+
+		_, err = d.ImportInstance(instance)
+		if err != nil {
+			return fmt.Errorf("import instance %s: %v", instance.InstanceID, err)
+		}
+		imported++
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d instances imported\n", imported, len(instances))
+	return nil
+}
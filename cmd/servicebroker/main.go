@@ -4,14 +4,23 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/arugaki/osb-starter-pack/pkg/admin"
 	"github.com/arugaki/osb-starter-pack/pkg/broker"
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	brokermetrics "github.com/arugaki/osb-starter-pack/pkg/metrics"
+	"github.com/arugaki/osb-starter-pack/pkg/trace"
 	"github.com/golang/glog"
 	"github.com/pmorie/osb-broker-lib/pkg/metrics"
 	"github.com/pmorie/osb-broker-lib/pkg/rest"
@@ -23,14 +32,41 @@ import (
 var options struct {
 	broker.Options
 
-	Port                 int
-	Insecure             bool
-	TLSCert              string
-	TLSKey               string
-	TLSCertFile          string
-	TLSKeyFile           string
+	Port        int
+	Insecure    bool
+	TLSCert     string
+	TLSKey      string
+	TLSCertFile string
+	TLSKeyFile  string
+
+	AuditInstance string
+	AuditSince    string
+
+	TraceExporter string
+	TraceEndpoint string
+	TraceFile     string
+
+	LeaderElect          bool
+	LeaderElectNamespace string
+	LeaderElectName      string
+	LeaseDuration        time.Duration
+	RenewDeadline        time.Duration
+	RetryPeriod          time.Duration
+
+	AdminPort        int
+	AdminTokenFile   string
+	AdminTLSCertFile string
+	AdminTLSKeyFile  string
+
+	ShutdownTimeout time.Duration
 }
 
+// background is done once the background work stopped and the lease is released.
+var background sync.WaitGroup
+
+// brokerLogic holds the broker once it runs, its binding operations are finished before exiting.
+var brokerLogic atomic.Value
+
 func init() {
 	flag.IntVar(&options.Port, "port", 8443, "use '--port' option to specify the port for broker to listen on")
 	flag.BoolVar(&options.Insecure, "insecure", true, "use --insecure to use HTTP vs HTTPS.")
@@ -40,6 +76,22 @@ func init() {
 	flag.StringVar(&options.TLSKey, "tlsKey", "", "base-64 encoded PEM block to use as the private key matching the TLS certificate.")
 	flag.BoolVar(&options.AuthenticateK8SToken, "authenticate-k8s-token", true, "option to specify if the broker should validate the bearer auth token with kubernetes")
 	flag.StringVar(&options.KubeConfig, "kube-config", "", "specify the kube config path to be used")
+	flag.StringVar(&options.AuditInstance, "audit-instance", "", "use with 'export-audit' to only export the audit logs of an instance id")
+	flag.StringVar(&options.AuditSince, "audit-since", "", "use with 'export-audit' to only export the audit logs since a RFC3339 time")
+	flag.StringVar(&options.TraceExporter, "trace-exporter", "", "exporter of the tracing spans, 'otlp' or 'file', tracing is disabled when empty")
+	flag.StringVar(&options.TraceEndpoint, "trace-endpoint", "http://localhost:4318/v1/traces", "OTLP/HTTP endpoint the spans are sent to with '--trace-exporter=otlp'")
+	flag.StringVar(&options.TraceFile, "trace-file", "traces.json", "file the spans are appended to with '--trace-exporter=file'")
+	flag.BoolVar(&options.LeaderElect, "leader-elect", false, "use --leader-elect to run several replicas, the background work only runs on the replica elected through a Lease")
+	flag.StringVar(&options.LeaderElectNamespace, "leader-elect-namespace", os.Getenv("POD_NAMESPACE"), "namespace of the leader election Lease, defaults to the POD_NAMESPACE environment variable")
+	flag.StringVar(&options.LeaderElectName, "leader-elect-name", "osb-starter-pack", "name of the leader election Lease")
+	flag.DurationVar(&options.LeaseDuration, "leader-elect-lease-duration", 15*time.Second, "how long the other replicas wait before taking over a Lease not renewed")
+	flag.DurationVar(&options.RenewDeadline, "leader-elect-renew-deadline", 10*time.Second, "how long the leader retries to renew the Lease before it stops the background work")
+	flag.DurationVar(&options.RetryPeriod, "leader-elect-retry-period", 2*time.Second, "interval of the attempts to acquire or renew the Lease")
+	flag.IntVar(&options.AdminPort, "admin-port", 0, "use '--admin-port' option to serve the admin api on its own port, disabled when 0")
+	flag.StringVar(&options.AdminTokenFile, "admin-token-file", "", "file containing the bearer token of the admin api")
+	flag.StringVar(&options.AdminTLSCertFile, "admin-tls-cert-file", "", "File containing the x509 Certificate of the admin api, HTTP is used without it")
+	flag.StringVar(&options.AdminTLSKeyFile, "admin-tls-private-key-file", "", "File containing the x509 private key matching --admin-tls-cert-file.")
+	flag.DurationVar(&options.ShutdownTimeout, "shutdown-timeout", 15*time.Second, "how long the binding operations running in the background may take to complete on SIGTERM, those still running are then failed")
 	broker.AddFlags(&options.Options)
 	flag.Parse()
 }
@@ -63,6 +115,9 @@ func runWithContext(ctx context.Context) error {
 		fmt.Printf("%s/%s\n", path.Base(os.Args[0]), "1.0.0")
 		return nil
 	}
+	if flag.Arg(0) == "export-audit" {
+		return exportAudit()
+	}
 	if (options.TLSCert != "" || options.TLSKey != "") &&
 		(options.TLSCert == "" || options.TLSKey == "") {
 		fmt.Println("To use TLS with specified cert or key data, both --tlsCert and --tlsKey must be used")
@@ -71,15 +126,33 @@ func runWithContext(ctx context.Context) error {
 
 	addr := ":" + strconv.Itoa(options.Port)
 
-	businessLogic, err := broker.NewBusinessLogic(options.Options)
+	exporter, err := trace.NewExporter(options.TraceExporter, options.TraceEndpoint, options.TraceFile, path.Base(os.Args[0]))
 	if err != nil {
 		return err
 	}
+	trace.SetExporter(exporter)
 
 	// Prom. metrics
 	reg := prom.NewRegistry()
 	osbMetrics := metrics.New()
-	reg.MustRegister(osbMetrics)
+	brokerMetrics := brokermetrics.New()
+	reg.MustRegister(osbMetrics, brokerMetrics)
+
+	businessLogic, err := broker.NewBusinessLogic(options.Options, brokerMetrics)
+	if err != nil {
+		return err
+	}
+	brokerLogic.Store(businessLogic)
+
+	err = runBackground(ctx, businessLogic)
+	if err != nil {
+		return err
+	}
+
+	err = runAdmin(ctx, businessLogic)
+	if err != nil {
+		return err
+	}
 
 	api, err := rest.NewAPISurface(businessLogic, osbMetrics)
 	if err != nil {
@@ -87,6 +160,7 @@ func runWithContext(ctx context.Context) error {
 	}
 
 	s := server.New(api, reg)
+	broker.RegisterBindingAPI(s.Router, api, businessLogic)
 	if options.AuthenticateK8SToken {
 		// get k8s client
 		k8sClient, err := kubernetes.GetKubernetesClient(options.KubeConfig)
@@ -126,6 +200,123 @@ func runWithContext(ctx context.Context) error {
 	return err
 }
 
+// exportAudit writes the audit logs to stdout as json lines.
+func exportAudit() error {
+	var since time.Time
+	if options.AuditSince != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, options.AuditSince)
+		if err != nil {
+			return err
+		}
+	}
+
+	d, err := dao.New(options.MysqlConfig())
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	logs, err := d.SelectAuditLogs(options.AuditInstance, since.Local())
+	if err != nil {
+		return err
+	}
+	return dao.WriteAuditLogs(os.Stdout, logs)
+}
+
+// runAdmin starts the admin api on its own listener when --admin-port is set.
+func runAdmin(ctx context.Context, businessLogic *broker.BusinessLogic) error {
+	if options.AdminPort == 0 {
+		return nil
+	}
+	if options.AdminPort == options.Port {
+		return fmt.Errorf("the admin api requires a port other than the OSB api port %d", options.Port)
+	}
+
+	token, err := ioutil.ReadFile(options.AdminTokenFile)
+	if err != nil {
+		return fmt.Errorf("read the admin token: %v", err)
+	}
+
+	adminServer, err := admin.NewServer(businessLogic, strings.TrimSpace(string(token)))
+	if err != nil {
+		return err
+	}
+
+	addr := ":" + strconv.Itoa(options.AdminPort)
+	go func() {
+		err := adminServer.Run(ctx, addr, options.AdminTLSCertFile, options.AdminTLSKeyFile)
+		if err != nil {
+			glog.Errorf("admin server stopped, err is %+v", err)
+		}
+	}()
+	return nil
+}
+
+// runBackground starts the background work of the broker, on the elected leader only with --leader-elect.
+func runBackground(ctx context.Context, businessLogic *broker.BusinessLogic) error {
+	if !options.LeaderElect {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			businessLogic.RunBackground(ctx)
+		}()
+		return nil
+	}
+
+	client, err := kubernetes.GetKubernetesClient(options.KubeConfig)
+	if err != nil {
+		return err
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	elector, err := kubernetes.NewLeaderElector(client, kubernetes.LeaderElectionConfig{
+		Namespace:     options.LeaderElectNamespace,
+		Name:          options.LeaderElectName,
+		Identity:      identity,
+		LeaseDuration: options.LeaseDuration,
+		RenewDeadline: options.RenewDeadline,
+		RetryPeriod:   options.RetryPeriod,
+	})
+	if err != nil {
+		return err
+	}
+
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		elector.Run(ctx, businessLogic.RunBackground)
+	}()
+	return nil
+}
+
+// waitBackground waits for the background work to stop and hand the Lease over.
+func waitBackground(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		glog.Warningf("background work did not stop in %v", timeout)
+	}
+}
+
+// shutdownBusinessLogic waits for the binding operations running in the background, the requests
+// are no longer served.
+func shutdownBusinessLogic() {
+	if b, ok := brokerLogic.Load().(*broker.BusinessLogic); ok {
+		b.Shutdown(options.ShutdownTimeout)
+	}
+}
+
 func cancelOnInterrupt(ctx context.Context, f context.CancelFunc) {
 	term := make(chan os.Signal)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
@@ -135,8 +326,14 @@ func cancelOnInterrupt(ctx context.Context, f context.CancelFunc) {
 		case <-term:
 			glog.Infof("Received SIGTERM, exiting gracefully...")
 			f()
+			shutdownBusinessLogic()
+			waitBackground(10 * time.Second)
+			trace.Shutdown()
 			os.Exit(0)
 		case <-ctx.Done():
+			shutdownBusinessLogic()
+			waitBackground(10 * time.Second)
+			trace.Shutdown()
 			os.Exit(0)
 		}
 	}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/arugaki/osb-starter-pack/pkg/lint"
 	"github.com/pmorie/go-open-service-broker-client/v2"
 	"io/ioutil"
 	"os"
@@ -16,6 +17,7 @@ type TemplateConfig struct {
 	PlanUpdateable      *bool                  `json:"plan_updateable"`
 	Bindable            bool                   `json:"bindable"`
 	BindingsRetrievable bool                   `json:"bindings_retrievable"`
+	AllowContextUpdates bool                   `json:"allow_context_updates"`
 	Metadata            map[string]interface{} `json:"metadata"`
 	CpuQuota            []string               `json:"cpu_quota"`
 	MemoryQuota         []string               `json:"memory_quota"`
@@ -35,11 +37,13 @@ type Property struct {
 var options struct {
 	InputPath  string
 	OutputPath string
+	LintPath   string
 }
 
 func init() {
 	flag.StringVar(&options.InputPath, "in", "", "use '--in' option to specify the path where the service template config in")
 	flag.StringVar(&options.OutputPath, "out", "", "use '--out' option to specify the path where the service info out")
+	flag.StringVar(&options.LintPath, "dir", "pkg/asset/template", "use 'lint --dir' to specify the path of the catalog and apply templates to check")
 	flag.Parse()
 }
 
@@ -49,6 +53,9 @@ func truePtr() *bool {
 }
 
 func main() {
+	if flag.Arg(0) == "lint" {
+		os.Exit(runLint(options.LintPath))
+	}
 
 	if options.InputPath == "" || options.OutputPath == "" {
 		flag.Usage()
@@ -69,6 +76,23 @@ func main() {
 	}
 }
 
+// runLint prints the violations of the templates as file:line diagnostics, the exit code is 1 when there is any.
+func runLint(dir string) int {
+	diagnostics, err := lint.Lint(dir)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	for _, d := range diagnostics {
+		fmt.Println(d)
+	}
+	if len(diagnostics) != 0 {
+		return 1
+	}
+	return 0
+}
+
 func loadTemplate(path string) (map[string]TemplateConfig, error) {
 	files, err := ioutil.ReadDir(path)
 	if err != nil {
@@ -106,13 +130,21 @@ func generateTemplate(templateConfigs map[string]TemplateConfig) map[string]v2.S
 		service.Metadata = templateConfig.Metadata
 		service.ID = uuid()
 
+		// the osb client has no allow_context_updates field yet, so it is published in the metadata
+		if templateConfig.AllowContextUpdates {
+			if service.Metadata == nil {
+				service.Metadata = make(map[string]interface{})
+			}
+			service.Metadata["allow_context_updates"] = true
+		}
+
 		plans := func(templateConfig TemplateConfig) []v2.Plan {
 			plans := make([]v2.Plan, 0, 512)
 			for _, cpu := range templateConfig.CpuQuota {
 				for _, memory := range templateConfig.MemoryQuota {
 					for _, disk := range templateConfig.DiskQuota {
 						plan := v2.Plan{}
-						plan.Description = ""
+						plan.Description = fmt.Sprintf("%s CPU, %sMi memory and %sGi disk per node", cpu, memory, disk)
 						plan.Name = fmt.Sprintf("p-%s-%s-%s", cpu, memory, disk)
 						plan.Bindable = truePtr()
 						plan.Free = truePtr()
@@ -8,12 +8,77 @@ CREATE TABLE IF NOT EXISTS `instances`(
    `service_id` VARCHAR(100) NOT NULL COMMENT '服务ID',
    `service_name` VARCHAR(100) NOT NULL COMMENT '服务名',
    `plan_id` VARCHAR(100) NOT NULL COMMENT '服务规格ID',
-   `namesapce` VARCHAR(100) NOT NULL COMMENT 'Namspace名',
+   `namespace` VARCHAR(100) NOT NULL COMMENT 'Namspace名',
+   `cluster` VARCHAR(100) NOT NULL DEFAULT '' COMMENT '服务实例所在的集群名',
+   `context` TEXT NOT NULL COMMENT '平台上下文, 无上下文时为空串',
+   `state` VARCHAR(50) NOT NULL DEFAULT '' COMMENT '最近一次操作的状态',
    `organization_guid` VARCHAR(100) NOT NULL COMMENT '组织ID',
    `space_guid` VARCHAR(100) NOT NULL COMMENT '空间ID',
-   `parameters` TEXT NOT NULL COMMENT '服务创建等操作所需填写的参数',
-   `yaml` TEXT NOT NULL COMMENT '部署服务的kubernetes编排文件',
+   `parameters` MEDIUMTEXT NOT NULL COMMENT '服务创建等操作所需填写的参数, 配置密钥后加密存储',
+   `yaml` MEDIUMTEXT NOT NULL COMMENT '部署服务的kubernetes编排文件, 配置密钥后加密存储',
    `created_at` VARCHAR(50) COMMENT '创建时间',
    `updated_at` VARCHAR(50) COMMENT '更新时间',
    PRIMARY KEY ( `instance_id` )
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;
\ No newline at end of file
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE IF NOT EXISTS `audit_logs`(
+   `id` BIGINT NOT NULL AUTO_INCREMENT COMMENT '审计日志ID',
+   `timestamp` VARCHAR(50) NOT NULL COMMENT '操作时间',
+   `identity` TEXT NOT NULL COMMENT '操作者身份',
+   `platform` VARCHAR(100) NOT NULL COMMENT '操作者所在平台',
+   `operation` VARCHAR(50) NOT NULL COMMENT '操作类型',
+   `instance_id` VARCHAR(100) NOT NULL COMMENT '服务实例ID',
+   `binding_id` VARCHAR(100) NOT NULL COMMENT '服务绑定ID',
+   `service_id` VARCHAR(100) NOT NULL COMMENT '服务ID',
+   `plan_id` VARCHAR(100) NOT NULL COMMENT '服务规格ID',
+   `parameters` TEXT NOT NULL COMMENT '操作参数, 敏感信息已脱敏',
+   `result` VARCHAR(50) NOT NULL COMMENT '操作结果',
+   `description` TEXT NOT NULL COMMENT '操作结果描述',
+   PRIMARY KEY ( `id` ),
+   KEY `idx_audit_logs_instance_id` ( `instance_id` )
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE IF NOT EXISTS `instance_locks`(
+   `instance_id` VARCHAR(100) NOT NULL COMMENT '服务实例ID',
+   `operation` VARCHAR(50) NOT NULL COMMENT '持有锁的操作',
+   `holder` VARCHAR(200) NOT NULL COMMENT '持有锁的broker副本',
+   `expires_at` DATETIME NOT NULL COMMENT '锁的过期时间',
+   PRIMARY KEY ( `instance_id` )
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE IF NOT EXISTS `backups`(
+   `backup_id` VARCHAR(100) NOT NULL COMMENT '备份ID',
+   `instance_id` VARCHAR(100) NOT NULL COMMENT '服务实例ID',
+   `service_name` VARCHAR(100) NOT NULL COMMENT '服务名',
+   `location` VARCHAR(500) NOT NULL COMMENT '备份在存储桶中的对象名',
+   `state` VARCHAR(50) NOT NULL DEFAULT '' COMMENT '备份的状态',
+   `restore_state` VARCHAR(50) NOT NULL DEFAULT '' COMMENT '最近一次恢复的状态',
+   `description` TEXT NOT NULL COMMENT '失败原因',
+   `started_at` VARCHAR(50) NOT NULL DEFAULT '' COMMENT '备份或最近一次恢复的开始时间, 超时后失败',
+   `created_at` VARCHAR(50) COMMENT '创建时间',
+   `updated_at` VARCHAR(50) COMMENT '更新时间',
+   PRIMARY KEY ( `backup_id` ),
+   KEY `idx_backups_instance_id` ( `instance_id` )
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE IF NOT EXISTS `bindings`(
+   `binding_id` VARCHAR(100) NOT NULL COMMENT '服务绑定ID',
+   `instance_id` VARCHAR(100) NOT NULL COMMENT '服务实例ID',
+   `service_id` VARCHAR(100) NOT NULL COMMENT '服务ID',
+   `plan_id` VARCHAR(100) NOT NULL COMMENT '服务规格ID',
+   `parameters` TEXT NOT NULL COMMENT '绑定所需填写的参数',
+   `secret_namespace` VARCHAR(100) NOT NULL DEFAULT '' COMMENT '保存凭据的Secret所在的Namespace',
+   `secret_name` VARCHAR(253) NOT NULL DEFAULT '' COMMENT '保存凭据的Secret名',
+   `credentials` TEXT NOT NULL COMMENT '服务签发的凭据, 配置密钥后加密存储',
+   `previous_credentials` TEXT NOT NULL COMMENT '轮换前的凭据, 撤销后为空, 配置密钥后加密存储',
+   `revoke_at` VARCHAR(50) NOT NULL DEFAULT '' COMMENT '轮换前的凭据的撤销时间',
+   `state` VARCHAR(50) NOT NULL DEFAULT 'succeed' COMMENT '最近一次操作的状态, 默认值即 LastStateSuccess, 异步绑定之前的绑定都已同步完成',
+   `operation` VARCHAR(50) NOT NULL DEFAULT '' COMMENT '最近一次操作, bind 或 unbind',
+   `description` TEXT NOT NULL COMMENT '失败原因',
+   `created_at` VARCHAR(50) COMMENT '创建时间',
+   `updated_at` VARCHAR(50) COMMENT '更新时间',
+   PRIMARY KEY ( `binding_id` ),
+   KEY `idx_bindings_instance_id` ( `instance_id` ),
+   KEY `idx_bindings_revoke_at` ( `revoke_at` ),
+   KEY `idx_bindings_state` ( `state` )
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,50 @@
+-- Upgrades a database created by an older db.sql, run the steps after the version of the
+-- schema in order. Every step is applied once, MySQL has no ADD COLUMN IF NOT EXISTS.
+
+use servicebroker;
+
+-- multi-cluster provisioning: the misspelt namespace column and the cluster of the instance
+ALTER TABLE `instances`
+   CHANGE `namesapce` `namespace` VARCHAR(100) NOT NULL COMMENT 'Namspace名',
+   ADD COLUMN `cluster` VARCHAR(100) NOT NULL DEFAULT '' COMMENT '服务实例所在的集群名' AFTER `namespace`;
+
+-- platform context
+ALTER TABLE `instances`
+   ADD COLUMN `context` TEXT COMMENT '平台上下文' AFTER `cluster`;
+
+-- state of the last operation, the existing instances have an empty state until their next
+-- operation or last operation poll
+ALTER TABLE `instances`
+   ADD COLUMN `state` VARCHAR(50) NOT NULL DEFAULT '' COMMENT '最近一次操作的状态' AFTER `context`;
+
+-- the context is read into a string, the instances without one get the empty string
+UPDATE `instances` SET `context` = '' WHERE `context` IS NULL;
+ALTER TABLE `instances`
+   MODIFY `context` TEXT NOT NULL COMMENT '平台上下文, 无上下文时为空串';
+
+-- encryption at rest: the encrypted values are larger than the plaintext ones
+ALTER TABLE `instances`
+   MODIFY `parameters` MEDIUMTEXT NOT NULL COMMENT '服务创建等操作所需填写的参数, 配置密钥后加密存储',
+   MODIFY `yaml` MEDIUMTEXT NOT NULL COMMENT '部署服务的kubernetes编排文件, 配置密钥后加密存储';
+
+-- the audit_logs, instance_locks, backups and bindings tables are created by db.sql, which only
+-- creates the missing tables. The backups and bindings tables of the first versions lack the columns below.
+
+-- timeout of the backups and restores, the ones processing meanwhile are timed from their last update
+ALTER TABLE `backups`
+   ADD COLUMN `started_at` VARCHAR(50) NOT NULL DEFAULT '' COMMENT '备份或最近一次恢复的开始时间, 超时后失败' AFTER `description`;
+UPDATE `backups` SET `started_at` = `updated_at` WHERE `started_at` = '';
+
+-- credential rotation
+ALTER TABLE `bindings`
+   ADD COLUMN `credentials` TEXT NOT NULL COMMENT '服务签发的凭据, 配置密钥后加密存储' AFTER `secret_name`,
+   ADD COLUMN `previous_credentials` TEXT NOT NULL COMMENT '轮换前的凭据, 撤销后为空, 配置密钥后加密存储' AFTER `credentials`,
+   ADD COLUMN `revoke_at` VARCHAR(50) NOT NULL DEFAULT '' COMMENT '轮换前的凭据的撤销时间' AFTER `previous_credentials`,
+   ADD KEY `idx_bindings_revoke_at` ( `revoke_at` );
+
+-- asynchronous bindings: the existing bindings were bound synchronously, so they succeeded
+ALTER TABLE `bindings`
+   ADD COLUMN `state` VARCHAR(50) NOT NULL DEFAULT 'succeed' COMMENT '最近一次操作的状态, 默认值即 LastStateSuccess, 异步绑定之前的绑定都已同步完成' AFTER `revoke_at`,
+   ADD COLUMN `operation` VARCHAR(50) NOT NULL DEFAULT '' COMMENT '最近一次操作, bind 或 unbind' AFTER `state`,
+   ADD COLUMN `description` TEXT NOT NULL COMMENT '失败原因' AFTER `operation`,
+   ADD KEY `idx_bindings_state` ( `state` );
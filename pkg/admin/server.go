@@ -0,0 +1,435 @@
+// Package admin serves the administration api of the broker on its own listener, for the portal
+// searching the instances and acting on them outside of the OSB api.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/broker"
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Server is the admin api, every request must carry the admin token as a bearer token.
+type Server struct {
+	Router *mux.Router
+
+	logic *broker.BusinessLogic
+	token string
+}
+
+func NewServer(logic *broker.BusinessLogic, token string) (*Server, error) {
+	if token == "" {
+		return nil, fmt.Errorf("the admin api requires a token")
+	}
+
+	s := &Server{
+		Router: mux.NewRouter(),
+		logic:  logic,
+		token:  token,
+	}
+
+	api := s.Router.PathPrefix("/admin/v1").Subrouter()
+	api.HandleFunc("/instances", s.listInstances).Methods("GET")
+	api.HandleFunc("/instances/{instance_id}", s.getInstance).Methods("GET")
+	api.HandleFunc("/instances/{instance_id}", s.deleteInstance).Methods("DELETE")
+	api.HandleFunc("/instances/{instance_id}/status", s.getStatus).Methods("GET")
+	api.HandleFunc("/instances/{instance_id}/reapply", s.reapplyInstance).Methods("POST")
+	api.HandleFunc("/instances/{instance_id}/backups", s.listBackups).Methods("GET")
+	api.HandleFunc("/instances/{instance_id}/backups", s.backupInstance).Methods("POST")
+	api.HandleFunc("/instances/{instance_id}/backups/{backup_id}/restore", s.restoreInstance).Methods("POST")
+	api.HandleFunc("/instances/{instance_id}/bindings/{binding_id}/rotate", s.rotateBinding).Methods("POST")
+	api.HandleFunc("/dry-run", s.dryRun).Methods("POST")
+	s.Router.Use(s.authenticate)
+	return s, nil
+}
+
+// Run listens on addr until ctx is done, with TLS when the cert and key files are given.
+func (s *Server) Run(ctx context.Context, addr, certFile, keyFile string) error {
+	glog.Infof("Starting admin server on %s", addr)
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s.Router,
+	}
+	go func() {
+		<-ctx.Done()
+		c, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if srv.Shutdown(c) != nil {
+			srv.Close()
+		}
+	}()
+
+	var err error
+	if certFile != "" && keyFile != "" {
+		err = srv.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "a valid admin token is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// identity names the caller of an action in the audit trail.
+func identity(r *http.Request) *osb.OriginatingIdentity {
+	return &osb.OriginatingIdentity{Platform: "admin-api", Value: r.RemoteAddr}
+}
+
+// instanceSummary is an instance as listed, its parameters and yaml may hold secrets and are only
+// returned by GET /admin/v1/instances/<id>.
+type instanceSummary struct {
+	InstanceID   string `json:"instance_id"`
+	InstanceName string `json:"instance_name"`
+	ServiceID    string `json:"service_id"`
+	ServiceName  string `json:"service_name"`
+	PlanID       string `json:"plan_id"`
+	Namespace    string `json:"namespace"`
+	Cluster      string `json:"cluster"`
+	State        string `json:"state"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+func summarize(i *dao.Instance) *instanceSummary {
+	return &instanceSummary{
+		InstanceID:   i.InstanceID,
+		InstanceName: i.InstanceName,
+		ServiceID:    i.ServiceID,
+		ServiceName:  i.ServiceName,
+		PlanID:       i.PlanID,
+		Namespace:    i.Namespace,
+		Cluster:      i.Cluster,
+		State:        i.State,
+		CreatedAt:    i.CreatedAt,
+		UpdatedAt:    i.UpdatedAt,
+	}
+}
+
+type listResponse struct {
+	Instances []*instanceSummary `json:"instances"`
+	Total     int64              `json:"total"`
+	Page      int                `json:"page"`
+	PageSize  int                `json:"page_size"`
+}
+
+// listInstances searches the instances, e.g. GET /admin/v1/instances?namespace=a&sort=updated_at&order=desc&page=2
+func (s *Server) listInstances(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := &dao.InstanceFilter{
+		ServiceName:      query.Get("service"),
+		PlanID:           query.Get("plan"),
+		Namespace:        query.Get("namespace"),
+		Cluster:          query.Get("cluster"),
+		State:            query.Get("state"),
+		OrganizationGUID: query.Get("organization"),
+		SpaceGUID:        query.Get("space"),
+		Search:           query.Get("search"),
+		SortBy:           query.Get("sort"),
+	}
+
+	if filter.SortBy != "" && !sortable(filter.SortBy) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("sort must be one of %s", strings.Join(dao.InstanceSortColumns, ", ")))
+		return
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		writeError(w, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
+
+	page, err := intParameter(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		writeError(w, http.StatusBadRequest, "page must be a positive integer")
+		return
+	}
+	pageSize, err := intParameter(query.Get("page_size"), defaultPageSize)
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("page_size must be between 1 and %d", maxPageSize))
+		return
+	}
+	filter.Limit = pageSize
+	filter.Offset = (page - 1) * pageSize
+
+	db := s.logic.DB().WithContext(r.Context())
+	instances, err := db.ListInstances(filter)
+	if err != nil {
+		glog.Errorf("list instances failed, err is %+v", err)
+		writeError(w, http.StatusServiceUnavailable, "the broker store is unavailable")
+		return
+	}
+
+	total, err := db.CountMatchingInstances(filter)
+	if err != nil {
+		glog.Errorf("count instances failed, err is %+v", err)
+		writeError(w, http.StatusServiceUnavailable, "the broker store is unavailable")
+		return
+	}
+
+	summaries := make([]*instanceSummary, 0, len(instances))
+	for _, instance := range instances {
+		summaries = append(summaries, summarize(instance))
+	}
+	writeJSON(w, http.StatusOK, &listResponse{
+		Instances: summaries,
+		Total:     total,
+		Page:      page,
+		PageSize:  pageSize,
+	})
+}
+
+// instance returns the instance of the request, nil when the response is already written.
+func (s *Server) instance(w http.ResponseWriter, r *http.Request) *dao.Instance {
+	instanceId := mux.Vars(r)["instance_id"]
+	instance, err := s.logic.DB().WithContext(r.Context()).SelectInstance(instanceId)
+	if err != nil {
+		glog.Errorf("select instance by instance id failed, err is %+v", err)
+		writeError(w, http.StatusServiceUnavailable, "the broker store is unavailable")
+		return nil
+	}
+	if instance.InstanceID == "" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("instance id %s is not found", instanceId))
+		return nil
+	}
+	return instance
+}
+
+func (s *Server) getInstance(w http.ResponseWriter, r *http.Request) {
+	if instance := s.instance(w, r); instance != nil {
+		writeJSON(w, http.StatusOK, instance)
+	}
+}
+
+type statusResponse struct {
+	StoredState string `json:"stored_state"`
+	LiveState   string `json:"live_state"`
+}
+
+func (s *Server) getStatus(w http.ResponseWriter, r *http.Request) {
+	instance := s.instance(w, r)
+	if instance == nil {
+		return
+	}
+
+	state, err := s.logic.InstanceStatus(r.Context(), instance)
+	if err != nil {
+		glog.Errorf("get status of instance %s failed, err is %+v", instance.InstanceID, err)
+		writeError(w, http.StatusInternalServerError, "failed to get the status of the instance")
+		return
+	}
+	writeJSON(w, http.StatusOK, &statusResponse{
+		StoredState: instance.State,
+		LiveState:   string(state),
+	})
+}
+
+// reapplyInstance updates the instance to the current template, it is processing until its pods are ready.
+func (s *Server) reapplyInstance(w http.ResponseWriter, r *http.Request) {
+	instance := s.instance(w, r)
+	if instance == nil {
+		return
+	}
+
+	err := s.logic.ReapplyInstance(instance, identity(r))
+	if err != nil {
+		glog.Errorf("reapply instance %s failed, err is %+v", instance.InstanceID, err)
+		writeBrokerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, struct{}{})
+}
+
+// deleteInstance force-deletes the instance, for the instances stuck in an operation.
+func (s *Server) deleteInstance(w http.ResponseWriter, r *http.Request) {
+	instance := s.instance(w, r)
+	if instance == nil {
+		return
+	}
+
+	err := s.logic.ForceDeleteInstance(r.Context(), instance, identity(r))
+	if err != nil {
+		glog.Errorf("force delete instance %s failed, err is %+v", instance.InstanceID, err)
+		writeBrokerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+type backupsResponse struct {
+	Backups []*dao.Backup `json:"backups"`
+}
+
+func (s *Server) listBackups(w http.ResponseWriter, r *http.Request) {
+	instance := s.instance(w, r)
+	if instance == nil {
+		return
+	}
+
+	backups, err := s.logic.ListBackups(r.Context(), instance)
+	if err != nil {
+		writeBrokerError(w, err)
+		return
+	}
+	if backups == nil {
+		backups = []*dao.Backup{}
+	}
+	writeJSON(w, http.StatusOK, &backupsResponse{Backups: backups})
+}
+
+// backupInstance starts a backup of the instance, it is processing until its jobs are done.
+func (s *Server) backupInstance(w http.ResponseWriter, r *http.Request) {
+	instance := s.instance(w, r)
+	if instance == nil {
+		return
+	}
+
+	backup, err := s.logic.BackupInstance(r.Context(), instance, identity(r))
+	if err != nil {
+		glog.Errorf("backup instance %s failed, err is %+v", instance.InstanceID, err)
+		writeBrokerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, backup)
+}
+
+// restoreInstance replaces the data of the instance by the backup, the instance is processing
+// until its pods are ready again.
+func (s *Server) restoreInstance(w http.ResponseWriter, r *http.Request) {
+	instance := s.instance(w, r)
+	if instance == nil {
+		return
+	}
+
+	err := s.logic.RestoreInstance(r.Context(), instance, mux.Vars(r)["backup_id"], identity(r))
+	if err != nil {
+		glog.Errorf("restore instance %s failed, err is %+v", instance.InstanceID, err)
+		writeBrokerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, struct{}{})
+}
+
+// rotateBinding issues new credentials for the binding, the previous ones are revoked after the
+// grace_period query parameter, the --rotation-grace-period of the broker when it is missing.
+func (s *Server) rotateBinding(w http.ResponseWriter, r *http.Request) {
+	grace := time.Duration(-1)
+	if value := r.URL.Query().Get("grace_period"); value != "" {
+		var err error
+		grace, err = time.ParseDuration(value)
+		if err != nil || grace < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("grace_period %q is not a duration", value))
+			return
+		}
+	}
+	instance := s.instance(w, r)
+	if instance == nil {
+		return
+	}
+
+	binding, err := s.logic.RotateBinding(r.Context(), instance, mux.Vars(r)["binding_id"], grace, identity(r))
+	if err != nil {
+		glog.Errorf("rotate binding of instance %s failed, err is %+v", instance.InstanceID, err)
+		writeBrokerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, binding)
+}
+
+// dryRun renders a provision or an update without applying it, the body is a broker.DryRunRequest.
+func (s *Server) dryRun(w http.ResponseWriter, r *http.Request) {
+	request := &broker.DryRunRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("the dry run request is invalid: %v", err))
+		return
+	}
+
+	response, err := s.logic.DryRun(r.Context(), request)
+	if err != nil {
+		writeBrokerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func sortable(column string) bool {
+	for _, c := range dao.InstanceSortColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+func intParameter(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		glog.Errorf("write admin response failed, err is %+v", err)
+	}
+}
+
+type errorResponse struct {
+	Error       string `json:"error,omitempty"`
+	Description string `json:"description"`
+}
+
+func writeError(w http.ResponseWriter, status int, description string) {
+	writeJSON(w, status, &errorResponse{Description: description})
+}
+
+// writeBrokerError writes the status and the description the broker gives the platform.
+func writeBrokerError(w http.ResponseWriter, err error) {
+	statusErr, ok := osb.IsHTTPError(err)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := &errorResponse{}
+	if statusErr.ErrorMessage != nil {
+		response.Error = *statusErr.ErrorMessage
+	}
+	if statusErr.Description != nil {
+		response.Description = *statusErr.Description
+	}
+	writeJSON(w, statusErr.StatusCode, response)
+}
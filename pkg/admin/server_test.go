@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arugaki/osb-starter-pack/pkg/broker"
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	daofake "github.com/arugaki/osb-starter-pack/pkg/dao/fake"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes/fake"
+)
+
+func TestListInstancesRejects(t *testing.T) {
+	s, err := NewServer(nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		token  string
+		query  string
+		status int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"wrong", "", http.StatusUnauthorized},
+		{"secret", "?sort=parameters", http.StatusBadRequest},
+		{"secret", "?order=up", http.StatusBadRequest},
+		{"secret", "?page=0", http.StatusBadRequest},
+		{"secret", "?page_size=1000", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest("GET", "/admin/v1/instances"+c.query, nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Fatalf("expect %d for token %q and query %q, got %d", c.status, c.token, c.query, w.Code)
+		}
+	}
+}
+
+func TestListInstancesHidesSecrets(t *testing.T) {
+	store := daofake.NewStore()
+	logic, err := broker.NewBusinessLogicWithBackends(broker.Options{}, nil, store, kubernetes.NewClusters(fake.NewCluster(), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(logic, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.InsertInstance(&dao.Instance{
+		InstanceID:  "listed",
+		ServiceName: "zookeeper",
+		Namespace:   "test",
+		State:       "succeed",
+		Context:     `{"namespace":"test"}`,
+		Parameters:  `{"ZOO_PASSWORD":"p4ssw0rd"}`,
+		Yaml:        "value: p4ssw0rd",
+	})
+
+	r := httptest.NewRequest("GET", "/admin/v1/instances", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect 200, got %d: %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `"instance_id":"listed"`) {
+		t.Fatalf("expect the instance listed, got %s", body)
+	}
+	for _, leaked := range []string{"p4ssw0rd", "parameters", "yaml", "context"} {
+		if strings.Contains(body, leaked) {
+			t.Fatalf("expect no %s in the list, got %s", leaked, body)
+		}
+	}
+}
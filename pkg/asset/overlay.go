@@ -0,0 +1,66 @@
+package asset
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// overlay reads a file from upper when it exists there and from lower otherwise, the directories
+// list the files of both.
+type overlay struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+// Overlay returns base with the files under dir taking precedence, so a service is overridden by
+// putting its catalog or its apply template in dir under the same path, e.g. apply/zookeeper.yaml.
+func Overlay(base fs.FS, dir string) fs.FS {
+	return &overlay{
+		upper: os.DirFS(dir),
+		lower: base,
+	}
+}
+
+func (o *overlay) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.lower.Open(name)
+}
+
+func (o *overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, upperErr := fs.ReadDir(o.upper, name)
+	if upperErr != nil && !errors.Is(upperErr, fs.ErrNotExist) {
+		return nil, upperErr
+	}
+	lower, lowerErr := fs.ReadDir(o.lower, name)
+	if lowerErr != nil && !errors.Is(lowerErr, fs.ErrNotExist) {
+		return nil, lowerErr
+	}
+	if upperErr != nil && lowerErr != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make(map[string]fs.DirEntry, len(upper)+len(lower))
+	for _, e := range lower {
+		entries[e.Name()] = e
+	}
+	for _, e := range upper {
+		entries[e.Name()] = e
+	}
+
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		merged = append(merged, e)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Name() < merged[j].Name()
+	})
+	return merged, nil
+}
@@ -0,0 +1,58 @@
+package asset
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "apply"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"apply/zookeeper.yaml": "overridden",
+		"apply/kafka.yaml":     "added",
+	} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	templates := Overlay(Templates(), dir)
+
+	data, err := fs.ReadFile(templates, "apply/zookeeper.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "overridden" {
+		t.Fatalf("expect the external template to override the embedded one, got %q", data)
+	}
+
+	_, err = fs.ReadFile(templates, "catalog/zookeeper_generated.json")
+	if err != nil {
+		t.Fatalf("expect the embedded catalog without an external one: %v", err)
+	}
+
+	entries, err := fs.ReadDir(templates, "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != "kafka.yaml" || names[1] != "zookeeper.yaml" {
+		t.Fatalf("expect the files of both layers once, got %v", names)
+	}
+}
@@ -0,0 +1,167 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// The administration of the instances outside of the OSB api, shared by brokerctl and the
+// admin api so they act on the store and the clusters exactly as the broker does.
+
+// DB returns the store of the broker.
+func (b *BusinessLogic) DB() dao.Store {
+	return b.db
+}
+
+// InstanceStatus checks the live state of an instance in its cluster, the stored state is not
+// changed.
+func (b *BusinessLogic) InstanceStatus(ctx context.Context, instance *dao.Instance) (osb.LastOperationState, error) {
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return "", err
+	}
+	return b.instanceState(kcl.WithContext(ctx), instance)
+}
+
+// RenderInstance renders the current template of the service with the stored plan and
+// parameters of an instance, to compare with the yaml the instance was applied with.
+func (b *BusinessLogic) RenderInstance(ctx context.Context, instance *dao.Instance) (string, error) {
+	plan, err := b.getPlan(instance.ServiceID, instance.PlanID)
+	if err != nil {
+		return "", err
+	}
+
+	var params map[string]interface{}
+	if instance.Parameters != "" {
+		err = json.Unmarshal([]byte(instance.Parameters), &params)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	_, manifests, err := b.renderTemplate(ctx, instance.ServiceName, instance.Namespace, instance.InstanceName, instance.InstanceID, params, plan)
+	return manifests, err
+}
+
+// ReapplyInstance updates an instance to the current template with its stored plan and
+// parameters, as an update of the platform would.
+func (b *BusinessLogic) ReapplyInstance(instance *dao.Instance, identity *osb.OriginatingIdentity) error {
+	var params map[string]interface{}
+	if instance.Parameters != "" {
+		err := json.Unmarshal([]byte(instance.Parameters), &params)
+		if err != nil {
+			return err
+		}
+	}
+
+	planId := instance.PlanID
+	_, err := b.Update(&osb.UpdateInstanceRequest{
+		InstanceID:          instance.InstanceID,
+		ServiceID:           instance.ServiceID,
+		PlanID:              &planId,
+		Parameters:          params,
+		AcceptsIncomplete:   true,
+		OriginatingIdentity: identity,
+	}, nil)
+	return err
+}
+
+// ForceDeleteInstance removes an instance stuck in an operation: its bindings, its kubernetes objects
+// and its record, holding the lock of the instance throughout. The unbinds and the cleanup hooks of
+// the service are tried but do not stop the deletion. A lock left by a stopped replica expires after
+// --instance-lock-ttl.
+func (b *BusinessLogic) ForceDeleteInstance(ctx context.Context, instance *dao.Instance, identity *osb.OriginatingIdentity) (err error) {
+	defer func() {
+		b.audit(&auditEntry{
+			operation:  AuditForceDelete,
+			instanceId: instance.InstanceID,
+			serviceId:  instance.ServiceID,
+			planId:     instance.PlanID,
+			identity:   identity,
+		}, err)
+	}()
+
+	unlock, err := b.lockInstance(instance.InstanceID, AuditForceDelete)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db := b.db.WithContext(ctx)
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return fmt.Errorf("cluster %s of the instance: %v", instance.Cluster, err)
+	}
+	kcl = kcl.WithContext(ctx)
+
+	err = b.forceDeleteBindings(ctx, kcl, instance)
+	if err != nil {
+		return err
+	}
+
+	if e := b.beforeKubeDelete(instance); e != nil {
+		glog.Warningf("delete before kubernetes resources of instance %s failed, err is %+v", instance.InstanceID, e)
+	}
+
+	err = kcl.DeleteInstance(instance.Yaml)
+	if err != nil {
+		glog.Errorf("delete kubernetes resources failed, err is %+v", err)
+		return err
+	}
+
+	if e := b.afterKubeDelete(instance); e != nil {
+		glog.Warningf("delete after kubernetes resources of instance %s failed, err is %+v", instance.InstanceID, e)
+	}
+
+	_, err = kcl.DeleteInstanceNamespace(instance.Namespace, instance.InstanceID)
+	if err != nil {
+		glog.Errorf("delete instance namespace failed, err is %+v", err)
+		return err
+	}
+
+	_, err = db.DeleteInstance(instance.InstanceID)
+	if err != nil {
+		glog.Errorf("delete instance by instance id failed, err is %+v", err)
+		return err
+	}
+	return nil
+}
+
+// forceDeleteBindings unbinds every binding of the instance and deletes its Secret and its record. A
+// failed unbind is logged, the credentials it leaves go with the instance.
+func (b *BusinessLogic) forceDeleteBindings(ctx context.Context, kcl kubernetes.Cluster, instance *dao.Instance) error {
+	db := b.db.WithContext(ctx)
+	bindings, err := db.SelectBindings(instance.InstanceID)
+	if err != nil {
+		glog.Errorf("select bindings of instance failed, err is %+v", err)
+		return err
+	}
+
+	for _, binding := range bindings {
+		if e := b.unbindFromInstance(ctx, instance, binding); e != nil {
+			glog.Warningf("unbind binding %s of instance %s failed, err is %+v", binding.BindingID, instance.InstanceID, e)
+		}
+
+		if binding.SecretName != "" {
+			err = kcl.DeleteSecret(binding.SecretNamespace, binding.SecretName,
+				bindingSecretLabels(binding.InstanceID, binding.BindingID))
+			if err != nil {
+				glog.Errorf("delete binding secret failed, err is %+v", err)
+				return err
+			}
+		}
+
+		_, err = db.DeleteBinding(binding.BindingID)
+		if err != nil {
+			glog.Errorf("delete binding by binding id failed, err is %+v", err)
+			return err
+		}
+	}
+	return nil
+}
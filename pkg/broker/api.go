@@ -0,0 +1,210 @@
+package broker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	"github.com/pmorie/osb-broker-lib/pkg/metrics"
+	"github.com/pmorie/osb-broker-lib/pkg/rest"
+)
+
+// The vendored osb-broker-lib only binds and unbinds synchronously, it neither passes
+// accepts_incomplete nor answers 202, and has no endpoints to fetch a binding or to poll its last
+// operation. RegisterBindingAPI serves them from the BusinessLogic on the router of the library.
+
+const (
+	bindingPath              = "/v2/service_instances/{instance_id}/service_bindings/{binding_id}"
+	bindingLastOperationPath = bindingPath + "/last_operation"
+)
+
+type bindingAPI struct {
+	logic   *BusinessLogic
+	metrics *metrics.OSBMetricsCollector
+}
+
+// RegisterBindingAPI replaces the bind and unbind handlers of the OSB api on the router and adds
+// fetching a binding and the last operation of a binding.
+func RegisterBindingAPI(router *mux.Router, api *rest.APISurface, logic *BusinessLogic) {
+	h := &bindingAPI{logic: logic, metrics: api.Metrics}
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || path != bindingPath {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			switch method {
+			case http.MethodPut:
+				route.HandlerFunc(h.bind)
+			case http.MethodDelete:
+				route.HandlerFunc(h.unbind)
+			}
+		}
+		return nil
+	})
+	router.HandleFunc(bindingPath, h.getBinding).Methods(http.MethodGet)
+	router.HandleFunc(bindingLastOperationPath, h.lastOperation).Methods(http.MethodGet)
+}
+
+func (h *bindingAPI) bind(w http.ResponseWriter, r *http.Request) {
+	h.metrics.Actions.WithLabelValues("bind").Inc()
+	if !h.validateVersion(w, r) {
+		return
+	}
+
+	request := &osb.BindRequest{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, request)
+	}
+	if err != nil {
+		writeError(w, badRequest(err, "the request body is invalid"))
+		return
+	}
+	vars := mux.Vars(r)
+	request.InstanceID = vars[osb.VarKeyInstanceID]
+	request.BindingID = vars[osb.VarKeyBindingID]
+	request.AcceptsIncomplete = r.FormValue(osb.AcceptsIncomplete) == "true"
+	request.OriginatingIdentity = originatingIdentity(r)
+
+	response, err := h.logic.Bind(request, &broker.RequestContext{Writer: w, Request: r})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := http.StatusCreated
+	if response.Async {
+		status = http.StatusAccepted
+	} else if response.Exists {
+		status = http.StatusOK
+	}
+	writeResponse(w, status, response)
+}
+
+func (h *bindingAPI) unbind(w http.ResponseWriter, r *http.Request) {
+	h.metrics.Actions.WithLabelValues("unbind").Inc()
+	if !h.validateVersion(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	request := &osb.UnbindRequest{
+		InstanceID:          vars[osb.VarKeyInstanceID],
+		BindingID:           vars[osb.VarKeyBindingID],
+		ServiceID:           r.FormValue(osb.VarKeyServiceID),
+		PlanID:              r.FormValue(osb.VarKeyPlanID),
+		AcceptsIncomplete:   r.FormValue(osb.AcceptsIncomplete) == "true",
+		OriginatingIdentity: originatingIdentity(r),
+	}
+
+	response, err := h.logic.Unbind(request, &broker.RequestContext{Writer: w, Request: r})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if response.Async {
+		status = http.StatusAccepted
+	}
+	writeResponse(w, status, response)
+}
+
+func (h *bindingAPI) getBinding(w http.ResponseWriter, r *http.Request) {
+	h.metrics.Actions.WithLabelValues("get_binding").Inc()
+	if !h.validateVersion(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	request := &osb.GetBindingRequest{
+		InstanceID: vars[osb.VarKeyInstanceID],
+		BindingID:  vars[osb.VarKeyBindingID],
+	}
+	response, err := h.logic.GetBinding(request, &broker.RequestContext{Writer: w, Request: r})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, response)
+}
+
+func (h *bindingAPI) lastOperation(w http.ResponseWriter, r *http.Request) {
+	h.metrics.Actions.WithLabelValues("binding_last_operation").Inc()
+	if !h.validateVersion(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	request := &osb.BindingLastOperationRequest{
+		InstanceID:          vars[osb.VarKeyInstanceID],
+		BindingID:           vars[osb.VarKeyBindingID],
+		OriginatingIdentity: originatingIdentity(r),
+	}
+	response, err := h.logic.BindingLastOperation(request, &broker.RequestContext{Writer: w, Request: r})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, response)
+}
+
+func (h *bindingAPI) validateVersion(w http.ResponseWriter, r *http.Request) bool {
+	err := h.logic.ValidateBrokerAPIVersion(r.Header.Get(osb.APIVersionHeader))
+	if err != nil {
+		writeError(w, err)
+		return false
+	}
+	return true
+}
+
+// originatingIdentity returns the identity of the X-Broker-API-Originating-Identity header, the
+// platforms are not required to send it.
+func originatingIdentity(r *http.Request) *osb.OriginatingIdentity {
+	header := r.Header.Get(osb.OriginatingIdentityHeader)
+	if header == "" {
+		return nil
+	}
+	parts := strings.Split(header, " ")
+	if len(parts) != 2 {
+		glog.Infof("invalid originating identity header %q", header)
+		return nil
+	}
+	value, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		glog.Infof("invalid encoding of originating identity header %q", header)
+		return nil
+	}
+	return &osb.OriginatingIdentity{Platform: parts[0], Value: string(value)}
+}
+
+// writeError writes the error as osb-broker-lib does, the errors which are not an
+// osb.HTTPStatusCodeError are internal errors.
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := osb.IsHTTPError(err)
+	if !ok {
+		e, _ = osb.IsHTTPError(internalError(err, err.Error()))
+	}
+	body := struct {
+		ErrorMessage *string `json:"error,omitempty"`
+		Description  *string `json:"description,omitempty"`
+	}{e.ErrorMessage, e.Description}
+	writeResponse(w, e.StatusCode, body)
+}
+
+func writeResponse(w http.ResponseWriter, status int, object interface{}) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,103 @@
+package broker
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+const (
+	AuditProvision   = "provision"
+	AuditUpdate      = "update"
+	AuditDeprovision = "deprovision"
+	AuditBind        = "bind"
+	AuditUnbind      = "unbind"
+	AuditForceDelete = "force-delete"
+	AuditBackup      = "backup"
+	AuditRestore     = "restore"
+	AuditRotate      = "rotate"
+
+	AuditSucceeded = "succeeded"
+	AuditFailed    = "failed"
+)
+
+// parameters whose names match are never written to the audit trail
+var sensitiveParameter = regexp.MustCompile(`(?i)pass|secret|token|key|credential|cert`)
+
+const redacted = "******"
+
+// redactParameters returns a copy of the parameters with the values of sensitive ones replaced.
+func redactParameters(params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+
+	redactedParams := make(map[string]interface{}, len(params))
+	for name, value := range params {
+		if sensitiveParameter.MatchString(name) {
+			redactedParams[name] = redacted
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			redactedParams[name] = redactParameters(nested)
+			continue
+		}
+		redactedParams[name] = value
+	}
+	return redactedParams
+}
+
+// auditEntry describes the operation being audited, the result is filled in by audit.
+type auditEntry struct {
+	operation  string
+	instanceId string
+	bindingId  string
+	serviceId  string
+	planId     string
+	identity   *osb.OriginatingIdentity
+	context    map[string]interface{}
+	parameters map[string]interface{}
+}
+
+// audit appends the operation and its result to the audit trail, failures are logged and never
+// change the response of the operation.
+func (b *BusinessLogic) audit(entry *auditEntry, err error) {
+	log := &dao.AuditLog{
+		Operation:  entry.operation,
+		InstanceID: entry.instanceId,
+		BindingID:  entry.bindingId,
+		ServiceID:  entry.serviceId,
+		PlanID:     entry.planId,
+		Platform:   getContextString(entry.context, contextPlatform),
+		Result:     AuditSucceeded,
+	}
+
+	if entry.identity != nil {
+		log.Identity = entry.identity.Value
+		if log.Platform == "" {
+			log.Platform = entry.identity.Platform
+		}
+	}
+
+	if entry.parameters != nil {
+		params, e := json.Marshal(redactParameters(entry.parameters))
+		if e != nil {
+			glog.Errorf("marshal audit parameters failed, err is %+v", e)
+		} else {
+			log.Parameters = string(params)
+		}
+	}
+
+	if err != nil {
+		log.Result = AuditFailed
+		log.Description = err.Error()
+	}
+
+	_, e := b.db.InsertAuditLog(log)
+	if e != nil {
+		glog.Errorf("insert into audit logs failed, entry is %+v, err is %+v", log, e)
+	}
+}
@@ -0,0 +1,34 @@
+package broker
+
+import (
+	"testing"
+)
+
+func TestRedactParameters(t *testing.T) {
+	params := map[string]interface{}{
+		"ZOO_TICK_TIME":  "4000",
+		"ADMIN_PASSWORD": "p4ssw0rd",
+		"backup": map[string]interface{}{
+			"bucket":     "zk",
+			"secret_key": "s3cr3t",
+		},
+	}
+
+	redactedParams := redactParameters(params)
+
+	if redactedParams["ZOO_TICK_TIME"] != "4000" {
+		t.Fatalf("expect ZOO_TICK_TIME kept, got %v", redactedParams["ZOO_TICK_TIME"])
+	}
+	if redactedParams["ADMIN_PASSWORD"] != redacted {
+		t.Fatalf("expect ADMIN_PASSWORD redacted, got %v", redactedParams["ADMIN_PASSWORD"])
+	}
+
+	backup := redactedParams["backup"].(map[string]interface{})
+	if backup["bucket"] != "zk" || backup["secret_key"] != redacted {
+		t.Fatalf("expect nested secret_key redacted, got %v", backup)
+	}
+
+	if params["ADMIN_PASSWORD"] != "p4ssw0rd" {
+		t.Fatal("expect the original parameters untouched")
+	}
+}
@@ -0,0 +1,136 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	reconcilePeriod   = time.Minute
+	lockCollectPeriod = 10 * time.Minute
+)
+
+// RunBackground runs the background work until ctx is done. With several replicas it must only
+// run on the elected leader, it returns once every loop stopped so the leadership is only handed
+// over then.
+func (b *BusinessLogic) RunBackground(ctx context.Context) {
+	glog.Infof("Starting background work")
+	var wg sync.WaitGroup
+	for _, f := range []func(){b.reconcileBackups, b.revokeRotatedCredentials, b.failStaleBindings} {
+		wg.Add(1)
+		go func(f func()) {
+			defer wg.Done()
+			wait.Until(f, reconcilePeriod, ctx.Done())
+		}(f)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wait.Until(b.collectExpiredLocks, lockCollectPeriod, ctx.Done())
+	}()
+	wait.Until(b.reconcileStates, reconcilePeriod, ctx.Done())
+	wg.Wait()
+	glog.Infof("Stopped background work")
+}
+
+// reconcileStates polls the instances still processing, so their state and the readiness metrics
+// do not depend on the platform polling last operation.
+func (b *BusinessLogic) reconcileStates() {
+	instances, err := b.db.SelectInstancesByState(LastStateProcessing)
+	if err != nil {
+		glog.Errorf("select processing instances failed, err is %+v", err)
+		return
+	}
+
+	for _, instance := range instances {
+		kcl, err := b.getCluster(instance.Cluster)
+		if err != nil {
+			glog.Errorf("get cluster %s of instance %s failed, err is %+v", instance.Cluster, instance.InstanceID, err)
+			continue
+		}
+
+		state, err := b.instanceState(kcl, instance)
+		if err != nil {
+			glog.Errorf("get state of instance %s failed, err is %+v", instance.InstanceID, err)
+			continue
+		}
+		b.recordState(instance, state)
+	}
+}
+
+// collectExpiredLocks removes the instance locks of the replicas which crashed holding them.
+func (b *BusinessLogic) collectExpiredLocks() {
+	count, err := b.db.DeleteExpiredInstanceLocks()
+	if err != nil {
+		glog.Errorf("delete expired instance locks failed, err is %+v", err)
+		return
+	}
+	if count != 0 {
+		glog.Infof("deleted %d expired instance locks", count)
+	}
+}
+
+// reconcileBackups completes the backups and the restores whose Jobs are done.
+func (b *BusinessLogic) reconcileBackups() {
+	backups, err := b.db.SelectProcessingBackups(LastStateProcessing)
+	if err != nil {
+		glog.Errorf("select processing backups failed, err is %+v", err)
+		return
+	}
+
+	for _, backup := range backups {
+		err := b.refreshBackup(context.Background(), backup)
+		if err != nil {
+			glog.Errorf("refresh backup %s of instance %s failed, err is %+v", backup.BackupID, backup.InstanceID, err)
+		}
+	}
+}
+
+// revokeRotatedCredentials revokes the previous credentials of the rotated bindings whose grace
+// period is over. The instances locked by another operation are left to the next round.
+func (b *BusinessLogic) revokeRotatedCredentials() {
+	bindings, err := b.db.SelectBindingsToRevoke(time.Now())
+	if err != nil {
+		glog.Errorf("select bindings to revoke failed, err is %+v", err)
+		return
+	}
+
+	for _, binding := range bindings {
+		err := b.revokeBinding(binding)
+		if err != nil {
+			glog.Errorf("revoke previous credentials of binding %s failed, err is %+v", binding.BindingID, err)
+		}
+	}
+}
+
+func (b *BusinessLogic) revokeBinding(binding *dao.Binding) error {
+	instance, err := b.db.SelectInstance(binding.InstanceID)
+	if err != nil {
+		return err
+	}
+	if instance.InstanceID == "" {
+		// the credentials went with the instance
+		binding.PreviousCredentials = ""
+		binding.RevokeAt = ""
+		_, err = b.db.UpdateBinding(binding)
+		return err
+	}
+
+	unlock, err := b.lockInstance(instance.InstanceID, AuditRotate)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the binding may be unbound or revoked while the lock was taken, an unbind revokes them itself
+	binding, err = b.db.SelectBinding(binding.BindingID)
+	if err != nil || binding.PreviousCredentials == "" || binding.State == LastStateProcessing {
+		return err
+	}
+	return b.revokePreviousCredentials(context.Background(), instance, binding)
+}
@@ -0,0 +1,367 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/service"
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// Backups copy the data of an instance to the backup target with the Jobs of its service. A backup
+// or a restore is processing until its Jobs are done, then the Jobs are deleted and the state is
+// recorded, by the background work or by whoever lists the backups first.
+
+// newBackupID returns a sortable id which is also valid in the names of the kubernetes objects.
+func newBackupID() (string, error) {
+	suffix := make([]byte, 3)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix), nil
+}
+
+func (b *BusinessLogic) backuper(serviceName string) (service.Backuper, error) {
+	s, ok := b.services[serviceName]
+	if !ok {
+		return nil, ServiceNotFound
+	}
+	backuper, ok := s.(service.Backuper)
+	if !ok {
+		return nil, BackupNotSupported
+	}
+	if b.backupTarget == nil {
+		return nil, BackupNotConfigured
+	}
+	return backuper, nil
+}
+
+// BackupInstance starts a backup of the instance, it is processing until its Jobs are done.
+func (b *BusinessLogic) BackupInstance(ctx context.Context, instance *dao.Instance, identity *osb.OriginatingIdentity) (_ *dao.Backup, err error) {
+	defer func() {
+		b.audit(&auditEntry{
+			operation:  AuditBackup,
+			instanceId: instance.InstanceID,
+			serviceId:  instance.ServiceID,
+			planId:     instance.PlanID,
+			identity:   identity,
+		}, err)
+	}()
+	db := b.db.WithContext(ctx)
+
+	backuper, err := b.backuper(instance.ServiceName)
+	if err != nil {
+		return nil, unprocessable(err, "")
+	}
+	if instance.State != LastStateSuccess {
+		return nil, unprocessable(InstanceNotReady, "")
+	}
+
+	unlock, err := b.lockInstance(instance.InstanceID, AuditBackup)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return nil, internalError(err, "the cluster of the instance is not configured")
+	}
+	kcl = kcl.WithContext(ctx)
+
+	// the quota of the namespace only has room for the pod of one Job
+	err = b.checkIdle(ctx, kcl, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	backupId, err := newBackupID()
+	if err != nil {
+		glog.Errorf("generate backup id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+	backup := &dao.Backup{
+		BackupID:    backupId,
+		InstanceID:  instance.InstanceID,
+		ServiceName: instance.ServiceName,
+		Location:    path.Join(instance.InstanceID, backupId+".tgz"),
+		State:       LastStateProcessing,
+		StartedAt:   time.Now().Format(timeFormat),
+	}
+	manifests, err := backuper.BackupJobs(instance, backupId, backup.Location, b.backupTarget)
+	if err != nil {
+		glog.Errorf("render backup jobs of instance %s failed, err is %+v", instance.InstanceID, err)
+		return nil, internalError(err, "failed to render the backup jobs of the service")
+	}
+
+	_, err = db.InsertBackup(backup)
+	if err != nil {
+		glog.Errorf("insert into backups failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+
+	_, err = kcl.CreateInstance(manifests)
+	if err != nil {
+		glog.Errorf("create backup jobs of instance %s failed, err is %+v", instance.InstanceID, err)
+		b.finishBackup(kcl, db, backup, manifests, err)
+		return nil, internalError(err, "failed to create the backup jobs")
+	}
+	return backup, nil
+}
+
+// RestoreInstance replaces the data of the instance by a backup of it. The instance is scaled to zero
+// while the restore Jobs run and is processing until its pods are ready again.
+func (b *BusinessLogic) RestoreInstance(ctx context.Context, instance *dao.Instance, backupId string, identity *osb.OriginatingIdentity) (err error) {
+	defer func() {
+		b.audit(&auditEntry{
+			operation:  AuditRestore,
+			instanceId: instance.InstanceID,
+			serviceId:  instance.ServiceID,
+			planId:     instance.PlanID,
+			identity:   identity,
+			parameters: map[string]interface{}{"backup_id": backupId},
+		}, err)
+	}()
+	db := b.db.WithContext(ctx)
+
+	backuper, err := b.backuper(instance.ServiceName)
+	if err != nil {
+		return unprocessable(err, "")
+	}
+
+	unlock, err := b.lockInstance(instance.InstanceID, AuditRestore)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	backup, err := db.SelectBackup(backupId)
+	if err != nil {
+		glog.Errorf("select backup by backup id failed, err is %+v", err)
+		return storeUnavailable(err)
+	}
+	if backup.BackupID == "" || backup.InstanceID != instance.InstanceID {
+		return newError(http.StatusNotFound, "", fmt.Sprintf("backup %s of instance %s is not found", backupId, instance.InstanceID), BackupNotFound)
+	}
+	if backup.State != LastStateSuccess {
+		return unprocessable(BackupNotComplete, "")
+	}
+	// the lock is only held for the request, the state keeps the other operations out
+	if instance.State != LastStateSuccess {
+		return unprocessable(InstanceNotReady, "")
+	}
+
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return internalError(err, "the cluster of the instance is not configured")
+	}
+	kcl = kcl.WithContext(ctx)
+
+	err = b.checkIdle(ctx, kcl, instance)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := backuper.RestoreJobs(instance, backupId, backup.Location, b.backupTarget)
+	if err != nil {
+		glog.Errorf("render restore jobs of instance %s failed, err is %+v", instance.InstanceID, err)
+		return internalError(err, "failed to render the restore jobs of the service")
+	}
+
+	_, err = db.UpdateInstanceState(instance.InstanceID, LastStateProcessing)
+	if err != nil {
+		glog.Errorf("update instance state failed, err is %+v", err)
+		return storeUnavailable(err)
+	}
+	backup.RestoreState = LastStateProcessing
+	backup.Description = ""
+	backup.StartedAt = time.Now().Format(timeFormat)
+	_, err = db.UpdateBackup(backup)
+	if err != nil {
+		glog.Errorf("update backup failed, err is %+v", err)
+		return storeUnavailable(err)
+	}
+
+	err = kcl.ScaleInstance(instance.Yaml, 0)
+	if err == nil {
+		_, err = kcl.CreateInstance(manifests)
+	}
+	if err != nil {
+		glog.Errorf("start restore of instance %s failed, err is %+v", instance.InstanceID, err)
+		b.finishRestore(kcl, db, instance, backup, manifests, err)
+		return internalError(err, "failed to start the restore jobs")
+	}
+	return nil
+}
+
+// ListBackups returns the backups of the instance, the oldest first, with the ones processing
+// refreshed from their Jobs.
+func (b *BusinessLogic) ListBackups(ctx context.Context, instance *dao.Instance) ([]*dao.Backup, error) {
+	backups, err := b.db.WithContext(ctx).SelectBackups(instance.InstanceID)
+	if err != nil {
+		glog.Errorf("select backups of instance failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+
+	for _, backup := range backups {
+		if backup.State != LastStateProcessing && backup.RestoreState != LastStateProcessing {
+			continue
+		}
+		err := b.refreshBackup(ctx, backup)
+		if err != nil {
+			glog.Warningf("refresh backup %s of instance %s failed, err is %+v", backup.BackupID, instance.InstanceID, err)
+		}
+	}
+	return backups, nil
+}
+
+// refreshBackup records the result of the backup and the restore Jobs of the backup which are done.
+func (b *BusinessLogic) refreshBackup(ctx context.Context, backup *dao.Backup) error {
+	db := b.db.WithContext(ctx)
+	instance, err := db.SelectInstance(backup.InstanceID)
+	if err != nil {
+		return err
+	}
+	if instance.InstanceID == "" {
+		backup.State, backup.RestoreState = failedIfProcessing(backup.State), failedIfProcessing(backup.RestoreState)
+		backup.Description = "the instance is gone"
+		_, err = db.UpdateBackup(backup)
+		return err
+	}
+
+	backuper, err := b.backuper(instance.ServiceName)
+	if err != nil {
+		return err
+	}
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return err
+	}
+	kcl = kcl.WithContext(ctx)
+
+	if backup.State == LastStateProcessing {
+		running, _, failed, err := kcl.CheckJobs(instance.Namespace, kubernetes.BackupLabel+"="+backup.BackupID)
+		if err != nil {
+			return err
+		}
+		timedOut := running && b.backupTimedOut(backup)
+		if running && !timedOut {
+			return nil
+		}
+		manifests, err := backuper.BackupJobs(instance, backup.BackupID, backup.Location, b.backupTarget)
+		if err != nil {
+			return err
+		}
+		var cause error
+		switch {
+		case failed:
+			cause = fmt.Errorf("the backup jobs failed")
+		case timedOut:
+			cause = fmt.Errorf("the backup jobs did not complete within %s", b.backupTimeout)
+		}
+		b.finishBackup(kcl, db, backup, manifests, cause)
+	}
+
+	if backup.RestoreState == LastStateProcessing {
+		running, _, failed, err := kcl.CheckJobs(instance.Namespace, kubernetes.RestoreLabel+"="+backup.BackupID)
+		if err != nil {
+			return err
+		}
+		timedOut := running && b.backupTimedOut(backup)
+		if running && !timedOut {
+			return nil
+		}
+		manifests, err := backuper.RestoreJobs(instance, backup.BackupID, backup.Location, b.backupTarget)
+		if err != nil {
+			return err
+		}
+		var cause error
+		switch {
+		case failed:
+			cause = fmt.Errorf("the restore jobs failed")
+		case timedOut:
+			cause = fmt.Errorf("the restore jobs did not complete within %s", b.backupTimeout)
+		}
+		b.finishRestore(kcl, db, instance, backup, manifests, cause)
+	}
+	return nil
+}
+
+// backupTimedOut tells whether the backup or the restore of the backup runs longer than the timeout.
+// The backups started before their start time was recorded are timed from their last update.
+func (b *BusinessLogic) backupTimedOut(backup *dao.Backup) bool {
+	if b.backupTimeout <= 0 {
+		return false
+	}
+	startedAt := backup.StartedAt
+	if startedAt == "" {
+		startedAt = backup.UpdatedAt
+	}
+	started, err := time.ParseInLocation(timeFormat, startedAt, time.Local)
+	if err != nil {
+		glog.Errorf("parse start of backup %s failed, err is %+v", backup.BackupID, err)
+		return false
+	}
+	return time.Since(started) > b.backupTimeout
+}
+
+// finishBackup deletes the Jobs of the backup and records it succeeded, or failed with cause.
+func (b *BusinessLogic) finishBackup(kcl kubernetes.Cluster, db dao.Store, backup *dao.Backup, manifests string, cause error) {
+	err := kcl.DeleteInstance(manifests)
+	if err != nil {
+		glog.Errorf("delete backup jobs of backup %s failed, err is %+v", backup.BackupID, err)
+	}
+
+	backup.State = LastStateSuccess
+	if cause != nil {
+		backup.State = LastStateFailed
+		backup.Description = cause.Error()
+	}
+	_, err = db.UpdateBackup(backup)
+	if err != nil {
+		glog.Errorf("update backup failed, err is %+v", err)
+	}
+}
+
+// finishRestore deletes the Jobs of the restore and scales the instance back by reapplying its yaml,
+// the instance stays processing until its pods are ready.
+func (b *BusinessLogic) finishRestore(kcl kubernetes.Cluster, db dao.Store, instance *dao.Instance, backup *dao.Backup, manifests string, cause error) {
+	err := kcl.DeleteInstance(manifests)
+	if err != nil {
+		glog.Errorf("delete restore jobs of backup %s failed, err is %+v", backup.BackupID, err)
+	}
+
+	_, err = kcl.UpdateInstance(instance.InstanceID, instance.Yaml)
+	if err != nil {
+		glog.Errorf("scale instance %s back failed, err is %+v", instance.InstanceID, err)
+		if cause == nil {
+			cause = fmt.Errorf("the instance can not be scaled back: %v", err)
+		}
+	}
+
+	backup.RestoreState = LastStateSuccess
+	if cause != nil {
+		backup.RestoreState = LastStateFailed
+		backup.Description = cause.Error()
+	}
+	_, err = db.UpdateBackup(backup)
+	if err != nil {
+		glog.Errorf("update backup failed, err is %+v", err)
+	}
+}
+
+func failedIfProcessing(state string) string {
+	if state == LastStateProcessing {
+		return LastStateFailed
+	}
+	return state
+}
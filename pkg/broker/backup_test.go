@@ -0,0 +1,136 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/service"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	b, cluster := newTestLogic(t)
+	b.backupTarget = &service.BackupTarget{
+		Endpoint:  "http://minio.minio:9000",
+		Bucket:    "backups",
+		AccessKey: "minio",
+		SecretKey: "minio123",
+	}
+	ctx := context.Background()
+
+	instance := provisionTestInstance(t, b, "backup")
+	namespace := instance.Namespace
+
+	listBackup := func() *dao.Backup {
+		backups, err := b.ListBackups(ctx, instance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(backups) != 1 {
+			t.Fatalf("expect 1 backup, got %d", len(backups))
+		}
+		return backups[0]
+	}
+
+	if _, err := b.BackupInstance(ctx, instance, nil); err == nil {
+		t.Fatal("expect no backup of an instance whose last operation has not succeeded")
+	}
+	instance.State = LastStateSuccess
+	b.db.UpdateInstanceState(instance.InstanceID, LastStateSuccess)
+
+	// the acl job of a binding holds the room of the quota for a job
+	b.db.InsertBinding(&dao.Binding{BindingID: "binding", InstanceID: instance.InstanceID, State: LastStateProcessing, Operation: AuditBind})
+	if _, err := b.BackupInstance(ctx, instance, nil); err == nil {
+		t.Fatal("expect no backup while a binding of the instance is processing")
+	}
+	b.db.DeleteBinding("binding")
+
+	backup, err := b.BackupInstance(ctx, instance, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := "backup-" + backup.BackupID
+	if cluster.Get("Job", namespace, job) == nil || cluster.Get("Secret", namespace, job) == nil {
+		t.Fatalf("expect the backup job and its secret in %s", namespace)
+	}
+	cluster.SetJobRunning(namespace, job, true)
+	if state := listBackup().State; state != LastStateProcessing {
+		t.Fatalf("expect the backup processing while its job runs, got %s", state)
+	}
+	cluster.SetJobRunning(namespace, job, false)
+	if state := listBackup().State; state != LastStateSuccess {
+		t.Fatalf("expect the backup succeeded once its job completes, got %s", state)
+	}
+	if cluster.Get("Job", namespace, job) != nil || cluster.Get("Secret", namespace, job) != nil {
+		t.Fatal("expect the backup job and its secret deleted")
+	}
+
+	err = b.RestoreInstance(ctx, instance, backup.BackupID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := deploymentReplicas(t, cluster.Get("Deployment", namespace, "zk-zookeeper01")); replicas != 0 {
+		t.Fatalf("expect the instance scaled to zero during the restore, got %d replicas", replicas)
+	}
+	for _, job := range []string{"0", "1", "2"} {
+		if cluster.Get("Job", namespace, "restore-"+backup.BackupID+"-"+job) == nil {
+			t.Fatalf("expect a restore job per node, job %s is missing", job)
+		}
+	}
+	response, err := b.LastOperation(&osb.LastOperationRequest{InstanceID: instance.InstanceID}, &broker.RequestContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.State != osb.StateInProgress {
+		t.Fatalf("expect the instance in progress during the restore, got %s", response.State)
+	}
+
+	cluster.SetJobFailed(namespace, "restore-"+backup.BackupID+"-1", true)
+	restored := listBackup()
+	if restored.RestoreState != LastStateFailed || restored.Description == "" {
+		t.Fatalf("expect the restore failed with its job, got %s %q", restored.RestoreState, restored.Description)
+	}
+	if replicas := deploymentReplicas(t, cluster.Get("Deployment", namespace, "zk-zookeeper01")); replicas != 1 {
+		t.Fatalf("expect the instance scaled back after the restore, got %d replicas", replicas)
+	}
+	if cluster.Get("Job", namespace, "restore-"+backup.BackupID+"-0") != nil {
+		t.Fatal("expect the restore jobs deleted")
+	}
+
+	// a restore running past the timeout
+	cluster.SetJobFailed(namespace, "restore-"+backup.BackupID+"-1", false)
+	err = b.RestoreInstance(ctx, instance, backup.BackupID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range []string{"0", "1", "2"} {
+		cluster.SetJobRunning(namespace, "restore-"+backup.BackupID+"-"+job, true)
+	}
+	if state := listBackup().RestoreState; state != LastStateProcessing {
+		t.Fatalf("expect the restore processing while its jobs run, got %s", state)
+	}
+	b.backupTimeout = time.Nanosecond
+	restored = listBackup()
+	if restored.RestoreState != LastStateFailed || !strings.Contains(restored.Description, "did not complete") {
+		t.Fatalf("expect the restore failed after the timeout, got %s %q", restored.RestoreState, restored.Description)
+	}
+	if replicas := deploymentReplicas(t, cluster.Get("Deployment", namespace, "zk-zookeeper01")); replicas != 1 {
+		t.Fatalf("expect the instance scaled back after the timed out restore, got %d replicas", replicas)
+	}
+	if cluster.Get("Job", namespace, "restore-"+backup.BackupID+"-0") != nil {
+		t.Fatal("expect the jobs of the timed out restore deleted")
+	}
+}
+
+func deploymentReplicas(t *testing.T, deployment *unstructured.Unstructured) int64 {
+	if deployment == nil {
+		t.Fatal("expect the deployment of the instance")
+	}
+	replicas, _, _ := unstructured.NestedInt64(deployment.Object, "spec", "replicas")
+	return replicas
+}
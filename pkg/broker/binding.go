@@ -0,0 +1,88 @@
+package broker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// credentialSecret is the credential referencing the Secret of a binding.
+const credentialSecret = "binding_secret"
+
+// bindingSecretName names the Secret of a binding, the binding id made a valid object name and the
+// hash of the id appended, so the ids which map to the same name, e.g. Binding_1 and binding-1, do not
+// share a Secret.
+func bindingSecretName(bindingId string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '-'
+	}, "binding-"+strings.ToLower(bindingId))
+	if len(name) > 236 {
+		name = name[:236]
+	}
+	sum := sha256.Sum256([]byte(bindingId))
+	return strings.TrimRight(name, "-.") + "-" + hex.EncodeToString(sum[:8])
+}
+
+// bindingSecretLabels are the labels of the Secret of a binding, which is only replaced or deleted
+// for the binding when it still has them.
+func bindingSecretLabels(instanceId, bindingId string) map[string]string {
+	return map[string]string{
+		kubernetes.InstanceLabel: kubernetes.LabelValue(instanceId),
+		kubernetes.BindingLabel:  kubernetes.LabelValue(bindingId),
+	}
+}
+
+// bindingSecretNamespace is the namespace of the application in the platform context of the bind
+// request, the namespace of the instance when the platform sends none.
+func bindingSecretNamespace(request *osb.BindRequest, instance *dao.Instance) string {
+	if namespace := getContextNamespace(request.Context); namespace != "" {
+		return namespace
+	}
+	return instance.Namespace
+}
+
+// newBindingSecret returns the Secret holding the credentials of a binding, the values which are
+// not strings are written as json.
+func newBindingSecret(namespace, name, instanceId, bindingId string, credentials map[string]interface{}) (*v1.Secret, error) {
+	data := make(map[string][]byte, len(credentials))
+	for key, value := range credentials {
+		if s, ok := value.(string); ok {
+			data[key] = []byte(s)
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("credential %s: %v", key, err)
+		}
+		data[key] = encoded
+	}
+
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    bindingSecretLabels(instanceId, bindingId),
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: data,
+	}, nil
+}
+
+// sameBinding tells whether a bind request repeats the stored binding.
+func sameBinding(binding *dao.Binding, request *osb.BindRequest) (bool, error) {
+	if binding.InstanceID != request.InstanceID || binding.ServiceID != request.ServiceID || binding.PlanID != request.PlanID {
+		return false, nil
+	}
+	return sameParameters(binding.Parameters, request.Parameters)
+}
@@ -0,0 +1,283 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// asyncContext is a request of a platform which polls the asynchronous binding operations.
+func asyncContext() *broker.RequestContext {
+	request, _ := http.NewRequest(http.MethodPut, "/", nil)
+	request.Header.Set(osb.APIVersionHeader, versionAsyncBindings.String())
+	return &broker.RequestContext{Request: request}
+}
+
+func TestBindingSecret(t *testing.T) {
+	b, cluster := newTestLogic(t)
+	b.bindingSecrets = true
+	c := asyncContext()
+
+	service := b.catalogs[0]
+	provisionTestInstance(t, b, "bound")
+
+	request := &osb.BindRequest{
+		InstanceID:        "bound",
+		BindingID:         "Binding_1",
+		ServiceID:         service.ID,
+		PlanID:            service.Plans[0].ID,
+		AcceptsIncomplete: true,
+		Context: map[string]interface{}{
+			contextPlatform:  osb.PlatformKubernetes,
+			contextNamespace: "app",
+		},
+	}
+	_, err := b.Bind(request, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.operations.Wait()
+	response, err := b.Bind(request, c)
+	if err != nil || !response.Exists {
+		t.Fatalf("expect a retry of the bind to find the binding, got %v", err)
+	}
+	name := bindingSecretName("Binding_1")
+	ref, _ := response.Credentials[credentialSecret].(map[string]interface{})
+	if ref["namespace"] != "app" || ref["name"] != name {
+		t.Fatalf("expect the reference of the secret in the application namespace, got %v", response.Credentials)
+	}
+	secret := cluster.Get("Secret", "app", name)
+	if secret == nil {
+		t.Fatal("expect the secret of the binding")
+	}
+	labels := secret.GetLabels()
+	if labels[kubernetes.InstanceLabel] != "bound" || labels[kubernetes.BindingLabel] != "Binding_1" {
+		t.Fatalf("expect the secret labelled with the instance and the binding, got %v", labels)
+	}
+	if name == bindingSecretName("binding-1") {
+		t.Fatalf("expect the binding ids made the same name to get different secrets, got %s", name)
+	}
+	if value := kubernetes.LabelValue("app:1"); strings.Contains(value, ":") || value == kubernetes.LabelValue("app-1") {
+		t.Fatalf("expect a valid label value distinct from the one of app-1, got %s", value)
+	}
+
+	// the secret of another binding is neither replaced nor deleted
+	foreign, err := newBindingSecret("app", bindingSecretName("foreign"), "other", "other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.ApplySecret(foreign); err != nil {
+		t.Fatal(err)
+	}
+	request.BindingID = "foreign"
+	_, err = b.Bind(request, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.operations.Wait()
+	operation, err := b.BindingLastOperation(&osb.BindingLastOperationRequest{InstanceID: "bound", BindingID: "foreign"}, c)
+	if err != nil || operation.State != osb.StateFailed {
+		t.Fatalf("expect the bind to fail on the secret of another binding, got %v, %v", operation, err)
+	}
+	err = cluster.DeleteSecret("app", foreign.Name, bindingSecretLabels("bound", "foreign"))
+	if err != nil || cluster.Get("Secret", "app", foreign.Name) == nil {
+		t.Fatalf("expect the secret of another binding kept, got %v", err)
+	}
+	request.BindingID = "Binding_1"
+
+	request.Parameters = map[string]interface{}{"chroot": "/other"}
+	_, err = b.Bind(request, c)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusConflict {
+		t.Fatalf("expect a conflict for different parameters, got %v", err)
+	}
+
+	unbind := &osb.UnbindRequest{
+		InstanceID:        "bound",
+		BindingID:         "Binding_1",
+		ServiceID:         service.ID,
+		PlanID:            service.Plans[0].ID,
+		AcceptsIncomplete: true,
+	}
+	_, err = b.Unbind(unbind, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.operations.Wait()
+	if cluster.Get("Secret", "app", name) != nil {
+		t.Fatal("expect the secret deleted with the binding")
+	}
+	_, err = b.Unbind(unbind, c)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusGone {
+		t.Fatalf("expect the binding to be gone, got %v", err)
+	}
+}
+
+func TestZookeeperBinding(t *testing.T) {
+	b, cluster := newTestLogic(t)
+	c := asyncContext()
+
+	service := b.catalogs[0]
+	namespace := provisionTestInstance(t, b, "zookeeper").Namespace
+
+	request := &osb.BindRequest{
+		InstanceID: "zookeeper",
+		BindingID:  "app:1",
+		ServiceID:  service.ID,
+		PlanID:     service.Plans[0].ID,
+	}
+	_, err := b.Bind(request, c)
+	if !osb.IsAsyncRequiredError(err) {
+		t.Fatalf("expect the acl jobs to require an asynchronous bind, got %v", err)
+	}
+	request.AcceptsIncomplete = true
+	response, err := b.Bind(request, c)
+	if err != nil || !response.Async {
+		t.Fatalf("expect an asynchronous bind, got %v", err)
+	}
+	b.operations.Wait()
+
+	operation, err := b.BindingLastOperation(&osb.BindingLastOperationRequest{InstanceID: "zookeeper", BindingID: "app:1"}, c)
+	if err != nil || operation.State != osb.StateSucceeded {
+		t.Fatalf("expect the bind to succeed, got %v, %v", operation, err)
+	}
+	binding, err := b.GetBinding(&osb.GetBindingRequest{InstanceID: "zookeeper", BindingID: "app:1"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials := binding.Credentials
+	_, err = b.GetBinding(&osb.GetBindingRequest{InstanceID: "zookeeper", BindingID: "app:1"}, &broker.RequestContext{})
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expect no binding fetched by a platform older than 2.14, got %v", err)
+	}
+	_, err = b.BindingLastOperation(&osb.BindingLastOperationRequest{InstanceID: "zookeeper", BindingID: "app:1"}, &broker.RequestContext{})
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expect no binding operation polled by a platform older than 2.14, got %v", err)
+	}
+	user, _ := credentials["username"].(string)
+	if !strings.HasPrefix(user, "app-1-") || credentials["chroot"] != "/bindings/"+user {
+		t.Fatalf("expect a user of the binding owning its subtree, got %v", credentials)
+	}
+	connect, _ := credentials["connect_string"].(string)
+	if !strings.HasPrefix(connect, "zk-zookeeper01-open."+namespace+".svc:2181") || !strings.HasSuffix(connect, ":2181/bindings/"+user) {
+		t.Fatalf("expect the connect string chrooted to the subtree, got %q", connect)
+	}
+	if password, _ := credentials["password"].(string); len(password) != 32 {
+		t.Fatalf("expect a generated password, got %q", password)
+	}
+	for _, obj := range cluster.Objects() {
+		if obj.GetKind() == "Job" {
+			t.Fatalf("expect the acl job deleted once complete, found %s", obj.GetName())
+		}
+	}
+
+	// the parameters are checked before the bind is accepted
+	request.BindingID = "relative"
+	request.Parameters = map[string]interface{}{"chroot": "relative/path"}
+	_, err = b.Bind(request, c)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusBadRequest ||
+		!strings.Contains(*statusErr.Description, "not an absolute znode path") {
+		t.Fatalf("expect a chroot which is not absolute to be a bad request, got %v", err)
+	}
+	_, err = b.BindingLastOperation(&osb.BindingLastOperationRequest{InstanceID: "zookeeper", BindingID: "relative"}, c)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusGone {
+		t.Fatalf("expect the rejected bind not to be recorded, got %v", err)
+	}
+
+	// the acl jobs fail in the background
+	request.BindingID = "failed"
+	request.Parameters = nil
+	cluster.SetError("CreateInstance", errors.New("admission webhook denied the request"))
+	_, err = b.Bind(request, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.operations.Wait()
+	cluster.SetError("CreateInstance", nil)
+	operation, err = b.BindingLastOperation(&osb.BindingLastOperationRequest{InstanceID: "zookeeper", BindingID: "failed"}, c)
+	if err != nil || operation.State != osb.StateFailed || operation.Description == nil {
+		t.Fatalf("expect the bind to fail with its acl jobs, got %+v, %v", operation, err)
+	}
+	_, err = b.GetBinding(&osb.GetBindingRequest{InstanceID: "zookeeper", BindingID: "failed"}, c)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expect a failed binding not to be fetched, got %v", err)
+	}
+	_, err = b.Bind(request, c)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expect a retry of the failed bind to be rejected, got %v", err)
+	}
+
+	for _, bindingId := range []string{"app:1", "failed"} {
+		response, err := b.Unbind(&osb.UnbindRequest{
+			InstanceID:        "zookeeper",
+			BindingID:         bindingId,
+			ServiceID:         service.ID,
+			PlanID:            service.Plans[0].ID,
+			AcceptsIncomplete: true,
+		}, c)
+		if err != nil || !response.Async {
+			t.Fatalf("expect an asynchronous unbind, got %v", err)
+		}
+	}
+	b.operations.Wait()
+	_, err = b.BindingLastOperation(&osb.BindingLastOperationRequest{InstanceID: "zookeeper", BindingID: "app:1"}, c)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusGone {
+		t.Fatalf("expect the binding gone once unbound, got %v", err)
+	}
+}
+
+func TestShutdownFinishesBindingOperations(t *testing.T) {
+	b, _ := newTestLogic(t)
+
+	for _, id := range []string{"quick", "slow", "late"} {
+		_, err := b.db.InsertBinding(&dao.Binding{BindingID: id, InstanceID: "instance", State: LastStateProcessing, Operation: AuditBind})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	binding := func(id string) *dao.Binding {
+		binding, err := b.db.SelectBinding(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return binding
+	}
+
+	quick := binding("quick")
+	b.runBindingOperation(quick, func(ctx context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		quick.State = LastStateSuccess
+		_, err := b.db.UpdateBinding(quick)
+		return err
+	})
+	release := make(chan struct{})
+	defer close(release)
+	b.runBindingOperation(binding("slow"), func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	b.Shutdown(100 * time.Millisecond)
+	if state := binding("quick").State; state != LastStateSuccess {
+		t.Fatalf("expect the shutdown to wait for the operation, got %s", state)
+	}
+	if slow := binding("slow"); slow.State != LastStateFailed || slow.Description != string(BrokerShuttingDown) {
+		t.Fatalf("expect the operation still running failed, got %s %q", slow.State, slow.Description)
+	}
+
+	started := false
+	b.runBindingOperation(binding("late"), func(ctx context.Context) error {
+		started = true
+		return nil
+	})
+	if late := binding("late"); started || late.State != LastStateFailed {
+		t.Fatalf("expect no operation started after the shutdown, got %s", late.State)
+	}
+}
@@ -1,16 +1,22 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/arugaki/osb-starter-pack/pkg/asset"
 	"github.com/arugaki/osb-starter-pack/pkg/dao"
 	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/metrics"
 	"github.com/arugaki/osb-starter-pack/pkg/service"
+	"github.com/arugaki/osb-starter-pack/pkg/trace"
 	"github.com/arugaki/osb-starter-pack/pkg/util"
 	"github.com/golang/glog"
 	"github.com/pmorie/go-open-service-broker-client/v2"
 	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	"io/fs"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"path"
 	"strings"
 )
 
@@ -31,45 +37,74 @@ var _ broker.Interface = &BusinessLogic{}
 // NewBusinessLogic is a hook that is called with the Options the program is run
 // with. NewBusinessLogic is the place where you will initialize your
 // BusinessLogic the parameters passed in.
-func NewBusinessLogic(o Options) (*BusinessLogic, error) {
-	b := &BusinessLogic{
-		async:            o.Async,
-		catalogs:         make([]v2.Service, 0, 10),
-		serviceTemplates: make(map[string][]byte),
-		serivceIdName:    make(map[string]string),
-		serviceIdPlan:    make(map[string]map[string]v2.Plan),
-		services:         make(map[string]service.Service),
+func NewBusinessLogic(o Options, m *metrics.BrokerMetricsCollector) (*BusinessLogic, error) {
+	d, err := NewStore(o)
+	if err != nil {
+		glog.Errorf("init dao failed, err is %+v", err)
+		return nil, err
+	}
+	if m != nil {
+		d.Observer = m.ObserveDB
 	}
 
-	b.InitServices()
-
-	err := b.InitServiceCatalog()
+	clusters, err := newClusters(o)
 	if err != nil {
-		glog.Errorf("init service failed, err is %+v", err)
+		glog.Errorf("init kubernetes failed, err is %+v", err)
 		return nil, err
 	}
 
-	c := &dao.Config{
-		Addr:     o.MysqlAddress,
-		Port:     o.MysqlPort,
-		UserName: o.MysqlUserName,
-		Password: o.MysqlPassword,
-		DB:       o.MysqlDB,
-		Active:   o.MysqlActive,
-		Idle:     o.MysqlIdle,
+	return NewBusinessLogicWithBackends(o, m, d, clusters)
+}
+
+// NewStore opens the mysql store encrypting with the keys of the options.
+func NewStore(o Options) (*dao.Dao, error) {
+	keyring, err := o.Keyring()
+	if err != nil {
+		return nil, err
 	}
-	b.db, err = dao.New(c)
+	d, err := dao.New(o.MysqlConfig())
 	if err != nil {
-		glog.Errorf("init dao failed, err is %+v", err)
 		return nil, err
 	}
+	d.Keyring = keyring
+	return d, nil
+}
+
+// NewBusinessLogicWithBackends initializes the BusinessLogic on the given store and clusters,
+// the tests run the broker on the fakes of both.
+func NewBusinessLogicWithBackends(o Options, m *metrics.BrokerMetricsCollector, store dao.Store, clusters *kubernetes.Clusters) (*BusinessLogic, error) {
+	b := &BusinessLogic{
+		db:                      store,
+		clusters:                clusters,
+		metrics:                 m,
+		async:                   o.Async,
+		allowParameterNamespace: o.AllowParameterNamespace,
+		replica:                 replicaName(),
+		lockTTL:                 o.InstanceLockTTL,
+		catalogs:                make([]v2.Service, 0, 10),
+		serviceTemplates:        make(map[string][]byte),
+		serivceIdName:           make(map[string]string),
+		serviceIdPlan:           make(map[string]map[string]v2.Plan),
+		services:                make(map[string]service.Service),
+		backupTarget:            o.BackupTarget(),
+		backupTimeout:           o.BackupTimeout,
+		bindingSecrets:          o.BindingSecrets,
+		rotationGrace:           o.RotationGracePeriod,
+	}
 
-	b.kcl, err = kubernetes.New(o.KubeConfig)
+	b.InitServices()
+
+	err := b.InitServiceCatalog(serviceTemplates(o))
 	if err != nil {
-		glog.Errorf("init kubernetes failed, err is %+v", err)
+		glog.Errorf("init service failed, err is %+v", err)
 		return nil, err
 	}
 
+	if m != nil {
+		m.SetInstanceCountsFunc(b.db.CountInstances)
+		m.SetBindingCountsFunc(b.db.CountBindings)
+	}
+
 	return b, nil
 }
 
@@ -79,10 +114,54 @@ func (b *BusinessLogic) InitServices() {
 	zookeeperService := &service.ZookeeperService{}
 
 	services[zookeeperService.Name()] = zookeeperService
+
+	b.services = services
 }
 
-func (b *BusinessLogic) InitServiceCatalog() error {
-	catalogs, serviceTemplates, serivceIdName, serviceIdPlan, err := InitServiceTemplate()
+func (b *BusinessLogic) InitClusters(o Options) error {
+	clusters, err := newClusters(o)
+	if err != nil {
+		return err
+	}
+
+	b.clusters = clusters
+	return nil
+}
+
+// newClusters registers the local cluster and the clusters of the kubeconfig directory and Secrets.
+func newClusters(o Options) (*kubernetes.Clusters, error) {
+	local, err := kubernetes.New(o.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := kubernetes.NewClusters(local, o.DefaultCluster)
+
+	if o.ClusterConfigDir != "" {
+		err = clusters.LoadFromDir(o.ClusterConfigDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.ClusterSecretNamespace != "" {
+		err = clusters.LoadFromSecrets(local.Client, o.ClusterSecretNamespace)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = clusters.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
+
+// InitServiceCatalog loads the catalogs and the apply templates of the services from templates,
+// laid out as asset.Templates.
+func (b *BusinessLogic) InitServiceCatalog(templates fs.FS) error {
+	catalogs, serviceTemplates, serivceIdName, serviceIdPlan, err := InitServiceTemplate(templates)
 	if err != nil {
 		return err
 	}
@@ -94,38 +173,68 @@ func (b *BusinessLogic) InitServiceCatalog() error {
 	return nil
 }
 
-func InitServiceTemplate() ([]v2.Service, map[string][]byte, map[string]string, map[string]map[string]v2.Plan, error) {
+// serviceTemplates returns the embedded templates, overridden by the template directory of the options.
+func serviceTemplates(o Options) fs.FS {
+	templates := asset.Templates()
+	if o.TemplateDir != "" {
+		templates = asset.Overlay(templates, o.TemplateDir)
+	}
+	return templates
+}
 
+// InitServiceTemplate reads every catalog under catalog/ and the apply template of each service,
+// apply/<service>.yaml.
+func InitServiceTemplate(templates fs.FS) ([]v2.Service, map[string][]byte, map[string]string, map[string]map[string]v2.Plan, error) {
 	var catalogs []v2.Service
 	serviceTemplates := make(map[string][]byte)
 	serivceIdName := make(map[string]string)
 	serviceIdPlan := make(map[string]map[string]v2.Plan)
 
-	for _, name := range asset.AssetNames() {
-		data, err := asset.Asset(name)
+	files, err := fs.ReadDir(templates, "apply")
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || path.Ext(f.Name()) != ".yaml" {
+			continue
+		}
+		data, err := fs.ReadFile(templates, path.Join("apply", f.Name()))
 		if err != nil {
 			return nil, nil, nil, nil, err
 		}
+		serviceTemplates[strings.TrimSuffix(f.Name(), ".yaml")] = data
+	}
 
-		if strings.Contains(name, "_generated.json") {
-			var catalog v2.Service
-			err = json.Unmarshal(data, &catalog)
-			if err != nil {
-				return nil, nil, nil, nil, err
-			}
-			catalogs = append(catalogs, catalog)
-			serivceIdName[catalog.ID] = catalog.Name
+	files, err = fs.ReadDir(templates, "catalog")
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || path.Ext(f.Name()) != ".json" {
+			continue
+		}
+		name := path.Join("catalog", f.Name())
+		data, err := fs.ReadFile(templates, name)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
 
-			plans := make(map[string]v2.Plan)
-			for _, plan := range catalog.Plans {
-				plans[plan.ID] = plan
-			}
-			serviceIdPlan[catalog.ID] = plans
+		var catalog v2.Service
+		err = json.Unmarshal(data, &catalog)
+		if err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if _, ok := serviceTemplates[catalog.Name]; !ok {
+			return nil, nil, nil, nil, fmt.Errorf("%s: service %s has no template apply/%s.yaml", name, catalog.Name, catalog.Name)
+		}
+		catalogs = append(catalogs, catalog)
+		serivceIdName[catalog.ID] = catalog.Name
 
-		} else {
-			serviceName := strings.Split(name, ".")[0]
-			serviceTemplates[serviceName] = data
+		plans := make(map[string]v2.Plan)
+		for _, plan := range catalog.Plans {
+			plans[plan.ID] = plan
 		}
+		serviceIdPlan[catalog.ID] = plans
 	}
 
 	return catalogs, serviceTemplates, serivceIdName, serviceIdPlan, nil
@@ -151,6 +260,87 @@ func (b *BusinessLogic) getPlan(serviceId, planId string) (*v2.Plan, error) {
 	}
 }
 
+// namespacePerInstance is the service option, set in the catalog metadata, which creates a
+// dedicated namespace per instance. Pods and Volumes scale the per container and per volume
+// plan quota up to the quota of the whole namespace.
+type namespacePerInstance struct {
+	Pods    int64 `json:"pods"`
+	Volumes int64 `json:"volumes"`
+}
+
+// getNamespacePerInstance returns nil when the service shares the namespace given by the caller.
+func (b *BusinessLogic) getNamespacePerInstance(serviceId string) (*namespacePerInstance, error) {
+	for _, catalog := range b.catalogs {
+		if catalog.ID != serviceId {
+			continue
+		}
+
+		option, ok := catalog.Metadata["namespace_per_instance"]
+		if !ok {
+			return nil, nil
+		}
+
+		data, err := json.Marshal(option)
+		if err != nil {
+			return nil, err
+		}
+		o := &namespacePerInstance{Pods: 1, Volumes: 1}
+		err = json.Unmarshal(data, o)
+		if err != nil {
+			return nil, err
+		}
+		return o, nil
+	}
+
+	return nil, ServiceNotFound
+}
+
+// instanceNamespace names the dedicated namespace of an instance.
+func instanceNamespace(instanceId string) string {
+	name := "sb-" + strings.ToLower(instanceId)
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// getQuota derives the quota of a dedicated namespace from the cpu, memory(Mi) and disk(Gi) bullets of the plan.
+func getQuota(plan *v2.Plan, option *namespacePerInstance) (*kubernetes.Quota, error) {
+	cpu, memory, disk, err := util.GetQuotaFromPlan(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	quota := &kubernetes.Quota{
+		Pods:    option.Pods,
+		Volumes: option.Volumes,
+	}
+	quota.CPU, err = resource.ParseQuantity(cpu)
+	if err != nil {
+		return nil, err
+	}
+	quota.Memory, err = resource.ParseQuantity(memory + "Mi")
+	if err != nil {
+		return nil, err
+	}
+	quota.Storage, err = resource.ParseQuantity(disk + "Gi")
+	if err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// serviceFlag reads a boolean of the catalog metadata of the service, false when it is absent.
+func (b *BusinessLogic) serviceFlag(serviceId, key string) bool {
+	for _, catalog := range b.catalogs {
+		if catalog.ID == serviceId {
+			flag, _ := catalog.Metadata[key].(bool)
+			return flag
+		}
+	}
+	return false
+}
+
 func (b *BusinessLogic) getServiceTemplate(serviceName string) (string, error) {
 	if t, ok := b.serviceTemplates[serviceName]; ok {
 		return string(t), nil
@@ -159,20 +349,25 @@ func (b *BusinessLogic) getServiceTemplate(serviceName string) (string, error) {
 	}
 }
 
-func getNamespace(params map[string]interface{}) (string, error) {
-	if ns, ok := params["NAMESPACE"]; ok {
-		return fmt.Sprintf("%v", ns), nil
-	} else {
-		return "", NamespaceNotFound
+// getClusterName chooses the cluster of a new instance, the CLUSTER parameter wins over
+// the cluster of the plan metadata, which wins over the default cluster.
+func (b *BusinessLogic) getClusterName(params map[string]interface{}, plan *v2.Plan) string {
+	if cluster, ok := params["CLUSTER"]; ok {
+		return fmt.Sprintf("%v", cluster)
+	}
+	if cluster, ok := plan.Metadata["cluster"]; ok {
+		return fmt.Sprintf("%v", cluster)
 	}
+	return b.clusters.Default()
 }
 
-func getInstanceName(params map[string]interface{}) (string, error) {
-	if ns, ok := params["INSTANCE_NAME"]; ok {
-		return fmt.Sprintf("%v", ns), nil
-	} else {
-		return "", InstanceNameNotFound
+func (b *BusinessLogic) getCluster(name string) (kubernetes.Cluster, error) {
+	kcl, err := b.clusters.Get(name)
+	if err != nil {
+		glog.Errorf("get cluster failed, err is %+v", err)
+		return nil, ClusterNotFound
 	}
+	return kcl, nil
 }
 
 func getStorageClass(params map[string]interface{}) string {
@@ -183,78 +378,194 @@ func getStorageClass(params map[string]interface{}) string {
 	}
 }
 
-// replace template instanceid and namespace
-func templateInit(template, namespace, instanceName, storageClass, id string) (string, error) {
-	type Params struct {
-		InstanceId   string
-		Namespace    string
-		InstanceName string
-		StorageClass string
+// rendering is the template of a service with the values of an instance. The template is always
+// rendered from its source with all the values, a value is never parsed as a template.
+type rendering struct {
+	template string
+	data     map[string]interface{}
+}
+
+// manifests renders the template, the actions of the values still missing are kept as they are.
+func (r *rendering) manifests() (string, error) {
+	return util.ExecutePartialTemplate(r.template, r.data)
+}
+
+// unresolved returns the variables of the template no step gave a value.
+func (r *rendering) unresolved() ([]string, error) {
+	return util.MissingFields(r.template, r.data)
+}
+
+// with returns the rendering with the values added, they replace those of the same name.
+func (r *rendering) with(values map[string]interface{}) *rendering {
+	data := make(map[string]interface{}, len(r.data)+len(values))
+	for name, value := range r.data {
+		data[name] = value
+	}
+	for name, value := range values {
+		data[name] = value
+	}
+	return &rendering{template: r.template, data: data}
+}
+
+// templateInit returns the values of the instance the broker sets, they replace the parameters.
+func templateInit(ctx context.Context, namespace, instanceName, storageClass, id string) map[string]interface{} {
+	_, span := trace.Start(ctx, "templateInit")
+	defer span.Finish(nil)
+
+	return map[string]interface{}{
+		"InstanceId":   id,
+		"Namespace":    namespace,
+		"InstanceName": instanceName,
+		"StorageClass": storageClass,
+	}
+}
+
+// brokerParameters are the parameters the broker reads itself, the others must be properties of
+// the create schema of the plan.
+var brokerParameters = map[string]bool{
+	"CLUSTER": true,
+}
+
+// validateParameters rejects the parameters the create schema of the plan does not define and the
+// values which are not a string, a number or a boolean, the only values the templates take.
+func validateParameters(plan *v2.Plan, params map[string]interface{}) error {
+	var properties map[string]interface{}
+	if plan.Schemas != nil && plan.Schemas.ServiceInstance != nil && plan.Schemas.ServiceInstance.Create != nil {
+		properties, _ = plan.Schemas.ServiceInstance.Create.Parameters.(map[string]interface{})
 	}
 
-	var params Params
-	if storageClass == "" {
-		params = Params{
-			InstanceId:   id,
-			Namespace:    namespace,
-			InstanceName: instanceName,
+	for name, value := range params {
+		if _, ok := properties[name]; !ok && !brokerParameters[name] {
+			return service.ParameterError(fmt.Sprintf("parameter %s is not defined by the plan", name))
 		}
-	} else {
-		params = Params{
-			InstanceId:   id,
-			Namespace:    namespace,
-			InstanceName: instanceName,
-			StorageClass: storageClass,
+		switch value.(type) {
+		case string, bool, float64, json.Number:
+		default:
+			return service.ParameterError(fmt.Sprintf("parameter %s is not a string, a number or a boolean", name))
 		}
 	}
+	return nil
+}
+
+// withDefaults returns the parameters completed with the defaults of the create schema of the plan.
+func withDefaults(plan *v2.Plan, params map[string]interface{}) map[string]interface{} {
+	completed := make(map[string]interface{}, len(params))
+	for name, value := range params {
+		completed[name] = value
+	}
+	if plan.Schemas == nil || plan.Schemas.ServiceInstance == nil || plan.Schemas.ServiceInstance.Create == nil {
+		return completed
+	}
+
+	properties, _ := plan.Schemas.ServiceInstance.Create.Parameters.(map[string]interface{})
+	for name, property := range properties {
+		p, _ := property.(map[string]interface{})
+		if value, ok := p["default"]; ok && value != "" {
+			if _, ok := completed[name]; !ok {
+				completed[name] = value
+			}
+		}
+	}
+	return completed
+}
 
-	newTemplate, err := util.ExecuteTemplate(template, params)
+// renderTemplate renders the template of the service for an instance up to the plan, the values
+// only known once the kubernetes services exist are added to the rendering by applySpecial.
+func (b *BusinessLogic) renderTemplate(ctx context.Context, serviceName, namespace, instanceName, instanceId string, params map[string]interface{}, plan *v2.Plan) (*rendering, string, error) {
+	srcTemplate, err := b.getServiceTemplate(serviceName)
 	if err != nil {
-		return "", err
+		glog.Errorf("get service template by serivce name failed, err is %+v", err)
+		return nil, "", internalError(err, "the template of the service is missing")
 	}
 
-	return newTemplate, nil
+	rendered := &rendering{template: srcTemplate}
+	values, err := b.applyParameters(ctx, serviceName, withDefaults(plan, params))
+	if err != nil {
+		glog.Errorf("apply parameters to templates failed, err is %+v", err)
+		return nil, "", unprocessable(err, "the parameters can not be applied to the service")
+	}
+	rendered = rendered.with(values)
+
+	values, err = b.applyPlan(ctx, serviceName, plan)
+	if err != nil {
+		glog.Errorf("apply plan to templates failed, err is %+v", err)
+		return nil, "", internalError(err, "failed to apply the plan to the template of the service")
+	}
+	rendered = rendered.with(values)
+	rendered = rendered.with(templateInit(ctx, namespace, instanceName, getStorageClass(params), instanceId))
+
+	// the kubernetes services are created from it, the other objects still miss the special values
+	manifests, err := rendered.manifests()
+	if err != nil {
+		glog.Errorf("render templates failed, err is %+v", err)
+		return nil, "", internalError(err, "failed to render the template of the service")
+	}
+	return rendered, manifests, nil
 }
 
-func (b *BusinessLogic) applyParameters(serviceName, template string, params map[string]interface{}) (string, error) {
+func (b *BusinessLogic) applyParameters(ctx context.Context, serviceName string, params map[string]interface{}) (_ map[string]interface{}, err error) {
+	_, span := trace.Start(ctx, "applyParameters")
+	defer func() {
+		span.Finish(err)
+	}()
+
 	if s, ok := b.services[serviceName]; ok {
-		t, err := s.ApplyParameters(template, params)
-		if err != nil {
-			return "", err
-		}
-		return t, nil
+		return s.ParameterValues(params)
 	}
 
-	return "", ServiceNotFound
+	return nil, ServiceNotFound
 }
 
-func (b *BusinessLogic) applyPlan(serviceName, template string, plan *v2.Plan) (string, error) {
+func (b *BusinessLogic) applyPlan(ctx context.Context, serviceName string, plan *v2.Plan) (_ map[string]interface{}, err error) {
+	_, span := trace.Start(ctx, "applyPlan")
+	defer func() {
+		span.Finish(err)
+	}()
+
 	if s, ok := b.services[serviceName]; ok {
-		t, err := s.ApplyPlan(template, plan)
-		if err != nil {
-			return "", err
-		}
-		return t, nil
+		return s.PlanValues(plan)
 	}
 
-	return "", ServiceNotFound
+	return nil, ServiceNotFound
 }
 
-func (b *BusinessLogic) applySpecial(serviceName, template string, kubeServices map[string]string) (string, error) {
-	if s, ok := b.services[serviceName]; ok {
-		t, err := s.ApplySpecial(template, kubeServices, b.kcl.Client)
-		if err != nil {
-			return "", err
-		}
-		return t, nil
+// applySpecial adds the values the service takes from the kubernetes services to the rendering
+// and renders the manifests of the instance.
+func (b *BusinessLogic) applySpecial(ctx context.Context, kcl kubernetes.Cluster, serviceName string, rendered *rendering, kubeServices map[string]string) (_ *rendering, _ string, err error) {
+	_, span := trace.Start(ctx, "applySpecial")
+	defer func() {
+		span.Finish(err)
+	}()
+
+	s, ok := b.services[serviceName]
+	if !ok {
+		return nil, "", ServiceNotFound
 	}
 
-	return "", ServiceNotFound
+	manifests, err := rendered.manifests()
+	if err != nil {
+		return nil, "", err
+	}
+	values, err := s.SpecialValues(manifests, kubeServices, kcl)
+	if err != nil {
+		return nil, "", err
+	}
+	rendered = rendered.with(values)
+	manifests, err = rendered.manifests()
+	if err != nil {
+		return nil, "", err
+	}
+	return rendered, manifests, nil
 }
 
-func (b *BusinessLogic) getDashboardURL(serviceName string, params map[string]interface{}, kubeServices map[string]string) (string, error) {
+func (b *BusinessLogic) getDashboardURL(ctx context.Context, kcl kubernetes.Cluster, serviceName string, params map[string]interface{}, kubeServices map[string]string) (_ string, err error) {
+	_, span := trace.Start(ctx, "getDashboardURL")
+	defer func() {
+		span.Finish(err)
+	}()
+
 	if s, ok := b.services[serviceName]; ok {
-		url, err := s.GetDashboardURL(params, kubeServices, b.kcl.Client)
+		url, err := s.GetDashboardURL(params, kubeServices, kcl)
 		if err != nil {
 			return "", err
 		}
@@ -300,9 +611,13 @@ func (b *BusinessLogic) lastStateCheck(instance *dao.Instance) (bool, bool, bool
 	return false, false, false, ServiceNotFound
 }
 
-func (b *BusinessLogic) bindInstance(serviceName string, request *v2.BindRequest) (map[string]interface{}, error) {
-	if s, ok := b.services[serviceName]; ok {
-		creds, err := s.BindInstance(request)
+func (b *BusinessLogic) bindInstance(ctx context.Context, instance *dao.Instance, request *v2.BindRequest) (map[string]interface{}, error) {
+	if s, ok := b.services[instance.ServiceName]; ok {
+		kcl, err := b.getCluster(instance.Cluster)
+		if err != nil {
+			return nil, err
+		}
+		creds, err := s.BindInstance(instance, request, kcl.WithContext(ctx))
 		if err != nil {
 			return nil, err
 		}
@@ -312,9 +627,27 @@ func (b *BusinessLogic) bindInstance(serviceName string, request *v2.BindRequest
 	return nil, ServiceNotFound
 }
 
-func (b *BusinessLogic) unbindInstance(serviceName string, request *v2.UnbindRequest) error {
-	if s, ok := b.services[serviceName]; ok {
-		err := s.UnbindInstance(request)
+// validateBindParameters has the service check the parameters of a new binding, if it has any.
+func (b *BusinessLogic) validateBindParameters(serviceName string, params map[string]interface{}) error {
+	validator, ok := b.services[serviceName].(service.BindParameterValidator)
+	if !ok {
+		return nil
+	}
+	return validator.ValidateBindParameters(params)
+}
+
+// unbindInstance revokes the current credentials of the binding.
+func (b *BusinessLogic) unbindInstance(ctx context.Context, instance *dao.Instance, binding *dao.Binding) error {
+	if s, ok := b.services[instance.ServiceName]; ok {
+		credentials, err := decodeCredentials(binding.Credentials)
+		if err != nil {
+			return err
+		}
+		kcl, err := b.getCluster(instance.Cluster)
+		if err != nil {
+			return err
+		}
+		err = s.UnbindInstance(instance, binding, credentials, kcl.WithContext(ctx))
 		if err != nil {
 			return err
 		}
@@ -2,6 +2,14 @@ package broker
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/service"
 )
 
 // Options holds the options specified by the broker's code on the command
@@ -21,6 +29,88 @@ type Options struct {
 
 	AuthenticateK8SToken bool
 	KubeConfig           string
+
+	ClusterConfigDir       string
+	ClusterSecretNamespace string
+	DefaultCluster         string
+
+	AllowParameterNamespace bool
+
+	InstanceLockTTL time.Duration
+
+	TemplateDir string
+
+	BackupEndpoint  string
+	BackupBucket    string
+	BackupAccessKey string
+	BackupSecretKey string
+	BackupImage     string
+	BackupTimeout   time.Duration
+
+	BindingSecrets      bool
+	RotationGracePeriod time.Duration
+
+	EncryptionKeyFile   string
+	EncryptionKeySecret string
+}
+
+// keyringSecretKey is the key of the keyring in the Secret of --encryption-key-secret.
+const keyringSecretKey = "keyring"
+
+// MysqlConfig returns the dao config of the mysql options.
+func (o *Options) MysqlConfig() *dao.Config {
+	return &dao.Config{
+		Addr:     o.MysqlAddress,
+		Port:     o.MysqlPort,
+		UserName: o.MysqlUserName,
+		Password: o.MysqlPassword,
+		DB:       o.MysqlDB,
+		Active:   o.MysqlActive,
+		Idle:     o.MysqlIdle,
+	}
+}
+
+// BackupTarget returns the storage of the backups, nil when backups are disabled.
+func (o *Options) BackupTarget() *service.BackupTarget {
+	if o.BackupEndpoint == "" {
+		return nil
+	}
+	return &service.BackupTarget{
+		Endpoint:  o.BackupEndpoint,
+		Bucket:    o.BackupBucket,
+		AccessKey: o.BackupAccessKey,
+		SecretKey: o.BackupSecretKey,
+		Image:     o.BackupImage,
+	}
+}
+
+// Keyring loads the keys encrypting the store from the key file or the Secret, nil when neither is
+// set and the store is not encrypted.
+func (o *Options) Keyring() (*dao.Keyring, error) {
+	var data []byte
+	var err error
+	switch {
+	case o.EncryptionKeyFile != "" && o.EncryptionKeySecret != "":
+		return nil, fmt.Errorf("--encryption-key-file and --encryption-key-secret are exclusive")
+	case o.EncryptionKeyFile != "":
+		data, err = ioutil.ReadFile(o.EncryptionKeyFile)
+	case o.EncryptionKeySecret != "":
+		parts := strings.Split(o.EncryptionKeySecret, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("--encryption-key-secret %q is not <namespace>/<name>", o.EncryptionKeySecret)
+		}
+		var client kubernetes.Interface
+		client, err = kubernetes.GetKubernetesClient(o.KubeConfig)
+		if err == nil {
+			data, err = kubernetes.GetSecretData(client, parts[0], parts[1], keyringSecretKey)
+		}
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load the encryption keys: %v", err)
+	}
+	return dao.ParseKeyring(data)
 }
 
 // AddFlags is a hook called to initialize the CLI flags for broker options.
@@ -39,8 +129,38 @@ func AddFlags(o *Options) {
 	flag.IntVar(&o.MysqlActive, "mysql-active-connections", 100, "specify the mysql max active connections to be limited")
 	flag.IntVar(&o.MysqlIdle, "mysql-idle-connections", 50, "specify the mysql max idle connections to be limited")
 
+	// clusters
+	flag.StringVar(&o.ClusterConfigDir, "cluster-config-dir", "", "specify the directory of kube config files, each file registers a target cluster named after it")
+	flag.StringVar(&o.ClusterSecretNamespace, "cluster-secret-namespace", "", "specify the namespace of the secrets labelled ruyiyun.servicebroker/cluster which hold target cluster kube configs")
+	flag.StringVar(&o.DefaultCluster, "default-cluster", "local", "specify the cluster to provision instances to when neither the plan nor the parameters choose one")
+
+	// platform context
+	flag.BoolVar(&o.AllowParameterNamespace, "allow-parameter-namespace", true, "specify if the NAMESPACE parameter is used when the platform context has no namespace")
+
+	// concurrency
+	flag.DurationVar(&o.InstanceLockTTL, "instance-lock-ttl", 5*time.Minute, "specify how long an operation holds the lock of an instance at most, the lock of a crashed replica expires after it")
+
+	// templates
+	flag.StringVar(&o.TemplateDir, "template-dir", "", "specify a directory of catalog/<service>_generated.json and apply/<service>.yaml files overriding the embedded ones")
+
+	// backups
+	flag.StringVar(&o.BackupEndpoint, "backup-endpoint", "", "specify the url of the S3 compatible storage the backups are copied to, e.g. http://minio.minio:9000, backups are disabled when empty")
+	flag.StringVar(&o.BackupBucket, "backup-bucket", "servicebroker-backups", "specify the bucket of the backups")
+	flag.StringVar(&o.BackupAccessKey, "backup-access-key", "", "specify the access key of the backup storage")
+	flag.StringVar(&o.BackupSecretKey, "backup-secret-key", "", "specify the secret key of the backup storage")
+	flag.StringVar(&o.BackupImage, "backup-image", service.DefaultBackupImage, "specify the image of the S3 client run by the backup and restore jobs")
+	flag.DurationVar(&o.BackupTimeout, "backup-timeout", time.Hour, "specify how long a backup or a restore may run before it is failed and its jobs are deleted, 0 waits forever")
+
+	// bindings
+	flag.BoolVar(&o.BindingSecrets, "binding-secrets", false, "specify if the credentials of a binding are also written to a Secret in the namespace of the application, or of the instance when the platform sends none")
+	flag.DurationVar(&o.RotationGracePeriod, "rotation-grace-period", 10*time.Minute, "specify how long the previous credentials of a rotated binding stay valid by default")
+
+	// encryption at rest
+	flag.StringVar(&o.EncryptionKeyFile, "encryption-key-file", "", "specify the file of the versioned keys encrypting the parameters, the yaml and the binding credentials in the store, they are stored in plaintext when neither this nor --encryption-key-secret is set")
+	flag.StringVar(&o.EncryptionKeySecret, "encryption-key-secret", "", "specify the <namespace>/<name> of the Secret holding the encryption keys under its keyring key")
+
 	// log level
 	flag.Set("logtostderr", "true")
 	flag.Set("stderrthreshold", "WARNING")
 	flag.Set("v", "2")
-}
\ No newline at end of file
+}
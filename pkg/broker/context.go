@@ -0,0 +1,157 @@
+package broker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// keys of the platform context objects defined by the OSB context profiles
+const (
+	contextPlatform     = "platform"
+	contextNamespace    = "namespace"
+	contextInstanceName = "instance_name"
+	contextSpaceGUID    = "space_guid"
+)
+
+func getContextString(context map[string]interface{}, key string) string {
+	if v, ok := context[key]; ok && v != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return ""
+}
+
+// getContextNamespace returns the namespace an instance belongs to according to the platform,
+// a kubernetes namespace or the space of cloud foundry.
+func getContextNamespace(context map[string]interface{}) string {
+	switch getContextString(context, contextPlatform) {
+	case osb.PlatformKubernetes:
+		return getContextString(context, contextNamespace)
+	case osb.PlatformCloudFoundry:
+		return strings.ToLower(getContextString(context, contextSpaceGUID))
+	}
+	return ""
+}
+
+// getNamespace prefers the namespace of the platform context, the NAMESPACE parameter is
+// only used when the platform sends none and the broker allows it.
+func (b *BusinessLogic) getNamespace(context, params map[string]interface{}) (string, error) {
+	namespace := getContextNamespace(context)
+	if namespace != "" {
+		if ns, ok := params["NAMESPACE"]; ok && fmt.Sprintf("%v", ns) != namespace {
+			glog.Warningf("ignore the NAMESPACE parameter %v, the platform context namespace is %s", ns, namespace)
+		}
+		return namespace, nil
+	}
+
+	if !b.allowParameterNamespace {
+		return "", NamespaceNotFound
+	}
+
+	if ns, ok := params["NAMESPACE"]; ok {
+		return fmt.Sprintf("%v", ns), nil
+	}
+	return "", NamespaceNotFound
+}
+
+// maxInstanceNameLength leaves room in the 63 characters of an object name for the suffixes the
+// templates append to the instance name, -zookeeper01-open is 17 of them.
+const maxInstanceNameLength = 40
+
+// getInstanceName uses the INSTANCE_NAME parameter, which must be a valid instance name. The name of
+// the instance on the platform is the default, a display name like "My ZK" is made a valid one.
+func getInstanceName(context, params map[string]interface{}) (string, error) {
+	if name, ok := params["INSTANCE_NAME"]; ok {
+		if s := fmt.Sprintf("%v", name); validInstanceName(s) {
+			return s, nil
+		}
+		return "", InstanceNameInvalid
+	}
+
+	if name := getContextString(context, contextInstanceName); name != "" {
+		return instanceName(name), nil
+	}
+	return "", InstanceNameNotFound
+}
+
+// validInstanceName tells whether the name is a dns label starting with a letter, as the names of
+// the Services must, and leaves room for the suffixes of the templates.
+func validInstanceName(name string) bool {
+	if name == "" || len(name) > maxInstanceNameLength || name[0] < 'a' || name[0] > 'z' || name[len(name)-1] == '-' {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// instanceName makes the name a valid instance name. It is lower cased, the other characters are
+// replaced by '-', it is shortened and the first 8 hex digits of its sha256 are appended, so the
+// names which map to the same one, e.g. "My ZK" and "my-zk", do not share the objects.
+func instanceName(name string) string {
+	if validInstanceName(name) {
+		return name
+	}
+	cleaned := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(name))
+	cleaned = strings.TrimLeft(cleaned, "-0123456789")
+	if len(cleaned) > maxInstanceNameLength-9 {
+		cleaned = cleaned[:maxInstanceNameLength-9]
+	}
+	cleaned = strings.TrimRight(cleaned, "-")
+	if cleaned == "" {
+		cleaned = "instance"
+	}
+	sum := sha256.Sum256([]byte(name))
+	return cleaned + "-" + hex.EncodeToString(sum[:4])
+}
+
+// isContextUpdate reports whether an update request only carries a new platform context. The
+// platforms send the context with every update, an unchanged one is a plain update.
+func isContextUpdate(request *osb.UpdateInstanceRequest, instance *dao.Instance) bool {
+	if request.Context == nil || len(request.Parameters) != 0 {
+		return false
+	}
+	if request.PlanID != nil && *request.PlanID != instance.PlanID {
+		return false
+	}
+	return !sameContext(request.Context, instance.Context)
+}
+
+// sameContext compares the context of a request with the stored one, both as decoded from json.
+func sameContext(context map[string]interface{}, stored string) bool {
+	var previous map[string]interface{}
+	if stored != "" {
+		if err := json.Unmarshal([]byte(stored), &previous); err != nil {
+			return false
+		}
+	}
+	data, err := json.Marshal(context)
+	if err != nil {
+		return false
+	}
+	var current map[string]interface{}
+	if err := json.Unmarshal(data, &current); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(current, previous)
+}
+
+// allowContextUpdates reports whether the service accepts updates which only change the platform context.
+func (b *BusinessLogic) allowContextUpdates(serviceId string) bool {
+	return b.serviceFlag(serviceId, "allow_context_updates")
+}
@@ -0,0 +1,160 @@
+package broker
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+func TestGetNamespace(t *testing.T) {
+	b := &BusinessLogic{allowParameterNamespace: false}
+
+	kubernetes := map[string]interface{}{"platform": "kubernetes", "namespace": "tenant-a"}
+	params := map[string]interface{}{"NAMESPACE": "tenant-b"}
+
+	ns, err := b.getNamespace(kubernetes, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns != "tenant-a" {
+		t.Fatalf("expect the context namespace tenant-a, got %s", ns)
+	}
+
+	cloudfoundry := map[string]interface{}{"platform": "cloudfoundry", "space_guid": "ABC-123"}
+	ns, err = b.getNamespace(cloudfoundry, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns != "abc-123" {
+		t.Fatalf("expect the space guid abc-123, got %s", ns)
+	}
+
+	_, err = b.getNamespace(nil, params)
+	if err != NamespaceNotFound {
+		t.Fatalf("expect NamespaceNotFound when parameters are not allowed, got %v", err)
+	}
+
+	b.allowParameterNamespace = true
+	ns, err = b.getNamespace(nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns != "tenant-b" {
+		t.Fatalf("expect the parameter namespace tenant-b, got %s", ns)
+	}
+}
+
+func TestGetInstanceName(t *testing.T) {
+	context := map[string]interface{}{"platform": "kubernetes", "instance_name": "zk"}
+
+	name, err := getInstanceName(context, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "zk" {
+		t.Fatalf("expect the context instance name zk, got %s", name)
+	}
+
+	name, err = getInstanceName(context, map[string]interface{}{"INSTANCE_NAME": "zk-prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "zk-prod" {
+		t.Fatalf("expect the parameter instance name zk-prod, got %s", name)
+	}
+
+	_, err = getInstanceName(nil, nil)
+	if err != InstanceNameNotFound {
+		t.Fatalf("expect InstanceNameNotFound, got %v", err)
+	}
+
+	// a display name of cloud foundry
+	name, err = getInstanceName(map[string]interface{}{"platform": "cloudfoundry", "instance_name": "My ZK"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(name, "my-zk-") || !validInstanceName(name) || name == instanceName("my-zk") {
+		t.Fatalf("expect a valid name made of My ZK, distinct from my-zk, got %s", name)
+	}
+	for _, display := range []string{"1st " + strings.Repeat("Zookeeper", 10), "é"} {
+		if name := instanceName(display); !validInstanceName(name) {
+			t.Fatalf("expect a valid name made of %s, got %s", display, name)
+		}
+	}
+
+	_, err = getInstanceName(context, map[string]interface{}{"INSTANCE_NAME": "My ZK"})
+	if err != InstanceNameInvalid {
+		t.Fatalf("expect InstanceNameInvalid for a parameter which is not a dns label, got %v", err)
+	}
+}
+
+func TestIsContextUpdate(t *testing.T) {
+	planId := "plan"
+	otherPlanId := "other"
+	context := map[string]interface{}{"platform": "cloudfoundry", "space_guid": "space"}
+	instance := &dao.Instance{PlanID: planId, Context: `{"platform":"cloudfoundry","space_guid":"old"}`}
+
+	if !isContextUpdate(&osb.UpdateInstanceRequest{Context: context}, instance) {
+		t.Fatal("expect a context update without plan and parameters")
+	}
+	if !isContextUpdate(&osb.UpdateInstanceRequest{Context: context, PlanID: &planId}, instance) {
+		t.Fatal("expect a context update with the current plan")
+	}
+	if isContextUpdate(&osb.UpdateInstanceRequest{Context: context, PlanID: &otherPlanId}, instance) {
+		t.Fatal("expect no context update when the plan changes")
+	}
+	if isContextUpdate(&osb.UpdateInstanceRequest{Context: context, Parameters: map[string]interface{}{"a": 1}}, instance) {
+		t.Fatal("expect no context update when parameters change")
+	}
+
+	instance.Context = `{"space_guid":"space","platform":"cloudfoundry"}`
+	if isContextUpdate(&osb.UpdateInstanceRequest{Context: context, PlanID: &planId}, instance) {
+		t.Fatal("expect an unchanged context to be a plain update")
+	}
+}
+
+func TestUpdateWithUnchangedContext(t *testing.T) {
+	b, _ := newTestLogic(t)
+	instance := provisionTestInstance(t, b, "context")
+
+	request := &osb.UpdateInstanceRequest{
+		InstanceID:        instance.InstanceID,
+		ServiceID:         instance.ServiceID,
+		PlanID:            &instance.PlanID,
+		AcceptsIncomplete: true,
+		Context: map[string]interface{}{
+			contextPlatform:     osb.PlatformKubernetes,
+			contextNamespace:    "test",
+			contextInstanceName: "zk",
+		},
+	}
+	response, err := b.Update(request, &broker.RequestContext{})
+	if err != nil || !response.Async {
+		t.Fatalf("expect the update with the same context applied, got %v", err)
+	}
+
+	// the service allows context updates, a new context is only recorded
+	request.AcceptsIncomplete = false
+	request.Context[contextInstanceName] = "renamed"
+	response, err = b.Update(request, &broker.RequestContext{})
+	if err != nil || response.Async {
+		t.Fatalf("expect the new context recorded synchronously, got %v", err)
+	}
+	updated, err := b.db.SelectInstance(instance.InstanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameContext(request.Context, updated.Context) {
+		t.Fatalf("expect the new context stored, got %s", updated.Context)
+	}
+
+	// without a new context an update is applied, which needs accepts_incomplete
+	_, err = b.Update(request, &broker.RequestContext{})
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expect the unchanged context to be a plain update, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/trace"
+	"github.com/golang/glog"
+)
+
+// dryRunInstanceId is the instance id of a dry run of a provision without an instance id.
+const dryRunInstanceId = "dry-run"
+
+// DryRunRequest is the provision, or the update of an existing instance, to render.
+type DryRunRequest struct {
+	ServiceID string `json:"service_id"`
+	// PlanID of an update defaults to the plan of the instance
+	PlanID string `json:"plan_id"`
+	// InstanceID of an existing instance renders an update of it, a provision otherwise
+	InstanceID string `json:"instance_id"`
+	// Parameters of an update default to the parameters of the instance
+	Parameters map[string]interface{} `json:"parameters"`
+	Context    map[string]interface{} `json:"context"`
+	// Server also submits the manifests to the api server with dryRun=All, the namespace dedicated
+	// to a new instance does not exist yet so its objects are rejected as not found
+	Server bool `json:"server"`
+}
+
+// DryRunResponse holds the manifests the provision or the update would apply.
+type DryRunResponse struct {
+	Operation string `json:"operation"`
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Manifests string `json:"manifests"`
+	// Unresolved are the variables no step of the pipeline filled in
+	Unresolved []string `json:"unresolved,omitempty"`
+	// Objects are the answers of the api server with Server
+	Objects []*kubernetes.DryRunResult `json:"objects,omitempty"`
+}
+
+// DryRun runs the render pipeline of a provision or an update, templateInit, applyParameters,
+// applyPlan and applySpecial, without creating anything and without touching the store.
+func (b *BusinessLogic) DryRun(ctx context.Context, request *DryRunRequest) (_ *DryRunResponse, err error) {
+	ctx, span := trace.Start(ctx, "broker.DryRun")
+	span.SetAttribute("osb.instance_id", request.InstanceID)
+	defer func() {
+		span.Finish(err)
+	}()
+
+	serviceName, err := b.getServiceName(request.ServiceID)
+	if err != nil {
+		return nil, badRequest(err, "unknown service id")
+	}
+
+	response := &DryRunResponse{Operation: AuditProvision}
+	instanceId := request.InstanceID
+	params := request.Parameters
+	planId := request.PlanID
+	var instanceName string
+
+	if instanceId != "" {
+		instance, err := b.db.WithContext(ctx).SelectInstance(instanceId)
+		if err != nil {
+			glog.Errorf("select instance by instance id failed, err is %+v", err)
+			return nil, storeUnavailable(err)
+		}
+		if instance.InstanceID != "" {
+			response.Operation = AuditUpdate
+			response.Cluster = instance.Cluster
+			response.Namespace = instance.Namespace
+			instanceName = instance.InstanceName
+			if planId == "" {
+				planId = instance.PlanID
+			}
+			if params == nil && instance.Parameters != "" {
+				err = json.Unmarshal([]byte(instance.Parameters), &params)
+				if err != nil {
+					return nil, internalError(err, "the stored parameters of the instance are invalid")
+				}
+			}
+		}
+	} else {
+		instanceId = dryRunInstanceId
+	}
+
+	plan, err := b.getPlan(request.ServiceID, planId)
+	if err != nil {
+		return nil, badRequest(err, "unknown plan id")
+	}
+	if request.Parameters != nil {
+		err = validateParameters(plan, request.Parameters)
+		if err != nil {
+			return nil, badRequest(err, err.Error())
+		}
+	}
+
+	if response.Operation == AuditProvision {
+		nsOption, err := b.getNamespacePerInstance(request.ServiceID)
+		if err != nil {
+			return nil, internalError(err, "the namespace option of the service is invalid")
+		}
+		if nsOption != nil {
+			response.Namespace = instanceNamespace(instanceId)
+		} else {
+			response.Namespace, err = b.getNamespace(request.Context, params)
+			if err != nil {
+				return nil, badRequest(err, "the namespace of the instance is missing")
+			}
+		}
+
+		instanceName, err = getInstanceName(request.Context, params)
+		if err != nil {
+			return nil, badRequest(err, "the name of the instance is missing")
+		}
+		response.Cluster = b.getClusterName(params, plan)
+	}
+
+	kcl, err := b.getCluster(response.Cluster)
+	if err != nil {
+		return nil, unprocessable(err, "unknown cluster")
+	}
+	kcl = kcl.WithContext(ctx)
+
+	rendered, _, err := b.renderTemplate(ctx, serviceName, response.Namespace, instanceName, instanceId, params, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	// no kubernetes service exists yet, the services of the template are what applySpecial gets
+	rendered, response.Manifests, err = b.applySpecial(ctx, kcl, serviceName, rendered, nil)
+	if err != nil {
+		glog.Errorf("apply special variables failed, err is %+v", err)
+		return nil, internalError(err, "failed to render the template of the service")
+	}
+
+	response.Unresolved, err = rendered.unresolved()
+	if err != nil {
+		return nil, internalError(err, "the template of the service is invalid")
+	}
+
+	if request.Server {
+		response.Objects, err = kcl.DryRunApply(response.Manifests)
+		if err != nil {
+			return nil, unprocessable(err, "the rendered manifests can not be decoded")
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/arugaki/osb-starter-pack/pkg/asset"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+func newRenderer(t *testing.T) *BusinessLogic {
+	b := &BusinessLogic{}
+	b.InitServices()
+	err := b.InitServiceCatalog(asset.Templates())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestZookeeperRendersCompletely(t *testing.T) {
+	b := newRenderer(t)
+
+	plan := b.catalogs[0].Plans[0]
+	rendered, _, err := b.renderTemplate(context.Background(), "zookeeper", "ns", "zk", "id", nil, &plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, manifests, err := b.applySpecial(context.Background(), nil, "zookeeper", rendered, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, err := rendered.unresolved()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 0 {
+		t.Fatalf("expect every variable rendered, %v left", fields)
+	}
+	for _, expect := range []string{"cpu: 0.5\n", `value: "4000"`, "server.1=zk-zookeeper01.ns.svc:2888:3888"} {
+		if !strings.Contains(manifests, expect) {
+			t.Fatalf("expect %q in the rendered template", expect)
+		}
+	}
+	if strings.Contains(manifests, "storageClassName") {
+		t.Fatal("expect no storage class without the STORAGECLASS parameter")
+	}
+}
+
+func TestParametersStayValues(t *testing.T) {
+	b := newRenderer(t)
+	plan := b.catalogs[0].Plans[0]
+
+	tickTime := "2000\"\n          securityContext: {privileged: true}\n          x: \"{{ .PLAN_LIMIT_CPU }} {{"
+	params := map[string]interface{}{"ZOO_TICK_TIME": tickTime, "STORAGECLASS": "fast\nhostPath: /"}
+	err := validateParameters(&plan, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, _, err := b.renderTemplate(context.Background(), "zookeeper", "ns", "zk", "id", params, &plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, manifests, err := b.applySpecial(context.Background(), nil, "zookeeper", rendered, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifests), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(obj)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch obj.GetKind() {
+		case "Deployment":
+			containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+			container := containers[0].(map[string]interface{})
+			if _, ok := container["securityContext"]; ok {
+				t.Fatalf("expect no security context from a parameter in %s", obj.GetName())
+			}
+			env, _, _ := unstructured.NestedSlice(container, "env")
+			for _, e := range env {
+				e := e.(map[string]interface{})
+				if e["name"] == "ZOO_TICK_TIME" && e["value"] != tickTime {
+					t.Fatalf("expect the parameter as the value of ZOO_TICK_TIME, got %q", e["value"])
+				}
+			}
+		case "PersistentVolumeClaim":
+			if class, _, _ := unstructured.NestedString(obj.Object, "spec", "storageClassName"); class != "fast\nhostPath: /" {
+				t.Fatalf("expect the parameter as the storage class, got %q", class)
+			}
+		}
+	}
+
+	for _, params := range []map[string]interface{}{
+		{"ZOO_TICK_TIME": "2000", "UNKNOWN": "1"},
+		{"ZOO_TICK_TIME": map[string]interface{}{"a": "b"}},
+		{"ZOO_TICK_TIME": []interface{}{"2000"}},
+	} {
+		if err := validateParameters(&plan, params); err == nil {
+			t.Fatalf("expect the parameters %v rejected", params)
+		}
+	}
+	if err := validateParameters(&plan, map[string]interface{}{"ZOO_TICK_TIME": 2000.0, "CLUSTER": "default"}); err != nil {
+		t.Fatalf("expect a number and the cluster accepted, got %v", err)
+	}
+}
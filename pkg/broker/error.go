@@ -1,11 +1,99 @@
 package broker
 
-import "errors"
-
-var (
-	ServiceNotFound         = errors.New("service id is not found")
-	ServiceTemplateNotFound = errors.New("service template is not found")
-	PlanNotfound            = errors.New("plan id is not found")
-	NamespaceNotFound       = errors.New("namespace is not found")
-	InstanceNameNotFound    = errors.New("instance name is not found")
+import (
+	"net/http"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// Error is a failure whose message is safe to return to the platform.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+const (
+	ServiceNotFound         = Error("service id is not found")
+	ServiceTemplateNotFound = Error("service template is not found")
+	PlanNotfound            = Error("plan id is not found")
+	NamespaceNotFound       = Error("namespace is not found")
+	InstanceNameNotFound    = Error("instance name is not found")
+	InstanceNameInvalid     = Error("instance name must start with a letter and hold at most 40 lowercase letters, digits and dashes")
+	ClusterNotFound         = Error("cluster is not found")
+	ClusterNotUpdatable     = Error("cluster of an instance can not be updated")
+	NamespaceNotUpdatable   = Error("namespace of an instance can not be updated")
+	ContextUpdateNotAllowed = Error("service does not allow context updates")
+	BackupNotSupported      = Error("service does not support backups")
+	BackupNotConfigured     = Error("backup target is not configured")
+	BackupNotFound          = Error("backup is not found")
+	BackupNotComplete       = Error("backup is not complete")
+	InstanceNotReady        = Error("instance is not ready, its last operation has not succeeded")
+	BindingNotFound         = Error("binding is not found")
+	BindingNotReady         = Error("binding is not ready, its last operation has not succeeded")
+	RotationPending         = Error("previous credentials of the binding are not revoked yet")
+	BrokerShuttingDown      = Error("broker is shutting down, retry the operation")
+)
+
+// Error codes of the OSB spec, returned in the error field of the response.
+const (
+	ErrorAsyncRequired = osb.AsyncErrorMessage
+	ErrorConcurrency   = "ConcurrencyError"
+	ErrorRequiresApp   = osb.AppGUIDRequiredErrorMessage
 )
+
+// newError returns the response of a failed operation. Only the status, the error code and the
+// description are written to the platform by osb-broker-lib, the cause is kept in ResponseError
+// for the logs and the audit trail.
+func newError(statusCode int, errorCode, description string, cause error) osb.HTTPStatusCodeError {
+	e := osb.HTTPStatusCodeError{
+		StatusCode:    statusCode,
+		Description:   &description,
+		ResponseError: cause,
+	}
+	if errorCode != "" {
+		e.ErrorMessage = &errorCode
+	}
+	return e
+}
+
+// describe returns the message of cause when it is an Error, description otherwise.
+func describe(cause error, description string) string {
+	if e, ok := cause.(Error); ok {
+		return e.Error()
+	}
+	return description
+}
+
+// badRequest is a malformed request or a request missing mandatory data.
+func badRequest(cause error, description string) error {
+	return newError(http.StatusBadRequest, "", describe(cause, description), cause)
+}
+
+// unprocessable is a well formed request the broker can not apply, e.g. parameters the service rejects.
+func unprocessable(cause error, description string) error {
+	return newError(http.StatusUnprocessableEntity, "", describe(cause, description), cause)
+}
+
+// internalError is a failure of the broker or of the kubernetes cluster, the platform may retry.
+func internalError(cause error, description string) error {
+	return newError(http.StatusInternalServerError, "", describe(cause, description), cause)
+}
+
+// storeUnavailable is a failure of the broker database.
+func storeUnavailable(cause error) error {
+	return newError(http.StatusServiceUnavailable, "", "the broker store is unavailable", cause)
+}
+
+func asyncRequired() error {
+	return newError(http.StatusUnprocessableEntity, ErrorAsyncRequired, osb.AsyncErrorDescription, nil)
+}
+
+func concurrencyError() error {
+	return newError(http.StatusUnprocessableEntity, ErrorConcurrency,
+		"another operation for this service instance is in progress", nil)
+}
+
+func requiresApp() error {
+	return newError(http.StatusUnprocessableEntity, ErrorRequiresApp, osb.AppGUIDRequiredErrorDescription, nil)
+}
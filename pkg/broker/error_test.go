@@ -0,0 +1,30 @@
+package broker
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+func TestErrorDescriptions(t *testing.T) {
+	e := badRequest(PlanNotfound, "unknown plan id").(osb.HTTPStatusCodeError)
+	if e.StatusCode != http.StatusBadRequest || *e.Description != PlanNotfound.Error() {
+		t.Fatalf("expect the message of a broker error returned, got %v", e)
+	}
+
+	cause := errors.New(`deployments.apps "zk" is forbidden: user "system:serviceaccount:sb:broker" cannot create`)
+	e = internalError(cause, "failed to create the kubernetes objects of the instance").(osb.HTTPStatusCodeError)
+	if e.StatusCode != http.StatusInternalServerError || *e.Description != "failed to create the kubernetes objects of the instance" {
+		t.Fatalf("expect the cause hidden from the platform, got %v", e)
+	}
+	if e.ResponseError != cause {
+		t.Fatal("expect the cause kept for the logs")
+	}
+
+	e = asyncRequired().(osb.HTTPStatusCodeError)
+	if e.StatusCode != http.StatusUnprocessableEntity || !osb.IsAsyncRequiredError(e) {
+		t.Fatalf("expect an AsyncRequired error, got %v", e)
+	}
+}
@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	daofake "github.com/arugaki/osb-starter-pack/pkg/dao/fake"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes/fake"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// newTestLogic returns a broker on the fake store and a fake cluster.
+func newTestLogic(t *testing.T) (*BusinessLogic, *fake.Cluster) {
+	cluster := fake.NewCluster()
+	b, err := NewBusinessLogicWithBackends(Options{InstanceLockTTL: time.Minute}, nil, daofake.NewStore(),
+		kubernetes.NewClusters(cluster, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, cluster
+}
+
+// provisionTestInstance provisions an instance named zk of the first plan for the namespace test
+// of a kubernetes platform and returns it as stored.
+func provisionTestInstance(t *testing.T, b *BusinessLogic, instanceId string) *dao.Instance {
+	service := b.catalogs[0]
+	_, err := b.Provision(&osb.ProvisionRequest{
+		InstanceID:        instanceId,
+		ServiceID:         service.ID,
+		PlanID:            service.Plans[0].ID,
+		AcceptsIncomplete: true,
+		Context: map[string]interface{}{
+			contextPlatform:     osb.PlatformKubernetes,
+			contextNamespace:    "test",
+			contextInstanceName: "zk",
+		},
+	}, &broker.RequestContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, err := b.db.SelectInstance(instanceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance
+}
+
+func TestProvisionToDeprovision(t *testing.T) {
+	b, cluster := newTestLogic(t)
+	c := &broker.RequestContext{}
+
+	service := b.catalogs[0]
+	instanceId := "lifecycle"
+
+	namespace := provisionTestInstance(t, b, instanceId).Namespace
+	if cluster.Get("Service", namespace, "zk-zookeeper01") == nil || cluster.Get("Deployment", namespace, "zk-zookeeper01") == nil {
+		t.Fatalf("expect the services and deployments of the instance, got %d objects", len(cluster.Objects()))
+	}
+
+	lastOperation := func() osb.LastOperationState {
+		response, err := b.LastOperation(&osb.LastOperationRequest{InstanceID: instanceId}, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.State
+	}
+	if state := lastOperation(); state != osb.StateSucceeded {
+		t.Fatalf("expect the instance to succeed once its pods are ready, got %s", state)
+	}
+	cluster.SetFailed(instanceId, true)
+	if state := lastOperation(); state != osb.StateFailed {
+		t.Fatalf("expect the instance to fail with its pods, got %s", state)
+	}
+
+	// an object the manifests do not list, like the claim of a StatefulSet, goes with the namespace
+	leftover, err := newBindingSecret(namespace, "leftover", instanceId, "leftover", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.ApplySecret(leftover); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.Deprovision(&osb.DeprovisionRequest{
+		InstanceID:        instanceId,
+		ServiceID:         service.ID,
+		PlanID:            service.Plans[0].ID,
+		AcceptsIncomplete: true,
+	}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objects := cluster.Objects(); len(objects) != 0 {
+		t.Fatalf("expect every object deleted, %d left", len(objects))
+	}
+	if cluster.Get("Namespace", "", namespace) != nil {
+		t.Fatalf("expect the namespace %s of the instance deleted", namespace)
+	}
+
+	_, err = b.LastOperation(&osb.LastOperationRequest{InstanceID: instanceId}, c)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusGone {
+		t.Fatalf("expect the deprovisioned instance to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/golang/glog"
+)
+
+// replicaName names this broker replica in the instance locks, the pod name in kubernetes.
+func replicaName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		glog.Errorf("get hostname failed, err is %+v", err)
+		return "unknown"
+	}
+	return hostname
+}
+
+// lockInstance takes the lock of an instance across the broker replicas for the time of the
+// request, the returned func releases it. A ConcurrencyError is returned while another operation
+// holds the lock, so the platform retries later.
+func (b *BusinessLogic) lockInstance(instanceId, operation string) (func(), error) {
+	token := make([]byte, 8)
+	_, err := rand.Read(token)
+	if err != nil {
+		glog.Errorf("generate lock token failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+	holder := b.replica + "/" + hex.EncodeToString(token)
+
+	acquired, err := b.db.AcquireInstanceLock(instanceId, operation, holder, b.lockTTL)
+	if err != nil {
+		glog.Errorf("acquire lock of instance failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+	if !acquired {
+		glog.Warningf("instance %s is locked by another operation, %s rejected", instanceId, operation)
+		return nil, concurrencyError()
+	}
+
+	return func() {
+		err := b.db.ReleaseInstanceLock(instanceId, holder)
+		if err != nil {
+			glog.Errorf("release lock of instance failed, err is %+v", err)
+		}
+	}, nil
+}
+
+// checkIdle returns a ConcurrencyError while a backup, a restore or a binding of the instance or its
+// own last operation is processing. The lock is only held for the time of a request, these states
+// keep out the operations which would run into them. The processing states are refreshed first, so
+// an operation which is done but not polled since does not hold the instance.
+func (b *BusinessLogic) checkIdle(ctx context.Context, kcl kubernetes.Cluster, instance *dao.Instance) error {
+	db := b.db.WithContext(ctx)
+	backups, err := db.SelectBackups(instance.InstanceID)
+	if err != nil {
+		glog.Errorf("select backups of instance failed, err is %+v", err)
+		return storeUnavailable(err)
+	}
+	for _, backup := range backups {
+		if backup.State != LastStateProcessing && backup.RestoreState != LastStateProcessing {
+			continue
+		}
+		err := b.refreshBackup(ctx, backup)
+		if err != nil {
+			glog.Warningf("refresh backup %s of instance %s failed, err is %+v", backup.BackupID, instance.InstanceID, err)
+		}
+		if backup.State == LastStateProcessing || backup.RestoreState == LastStateProcessing {
+			glog.Warningf("backup %s of instance %s is processing", backup.BackupID, instance.InstanceID)
+			return concurrencyError()
+		}
+	}
+
+	bindings, err := db.SelectBindings(instance.InstanceID)
+	if err != nil {
+		glog.Errorf("select bindings of instance failed, err is %+v", err)
+		return storeUnavailable(err)
+	}
+	for _, binding := range bindings {
+		if binding.State == LastStateProcessing {
+			glog.Warningf("%s of binding %s of instance %s is processing", binding.Operation, binding.BindingID, instance.InstanceID)
+			return concurrencyError()
+		}
+	}
+
+	if instance.State != LastStateProcessing {
+		return nil
+	}
+	state, err := b.instanceState(kcl, instance)
+	if err != nil {
+		return internalError(err, "failed to get the status of the instance")
+	}
+	b.recordState(instance, state)
+	if state == LastStateProcessing {
+		glog.Warningf("last operation of instance %s is processing", instance.InstanceID)
+		return concurrencyError()
+	}
+	instance.State = string(state)
+	return nil
+}
@@ -0,0 +1,149 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+func expectConcurrencyError(t *testing.T, err error, operation string) {
+	statusErr, ok := osb.IsHTTPError(err)
+	if !ok || statusErr.ErrorMessage == nil || *statusErr.ErrorMessage != ErrorConcurrency {
+		t.Fatalf("expect a ConcurrencyError for the %s, got %v", operation, err)
+	}
+}
+
+func TestConcurrentOperations(t *testing.T) {
+	b, cluster := newTestLogic(t)
+	c := &broker.RequestContext{}
+	instance := provisionTestInstance(t, b, "concurrent")
+
+	update := &osb.UpdateInstanceRequest{
+		InstanceID:        instance.InstanceID,
+		ServiceID:         instance.ServiceID,
+		AcceptsIncomplete: true,
+	}
+	deprovision := &osb.DeprovisionRequest{
+		InstanceID:        instance.InstanceID,
+		ServiceID:         instance.ServiceID,
+		PlanID:            instance.PlanID,
+		AcceptsIncomplete: true,
+	}
+	bind := &osb.BindRequest{
+		InstanceID: instance.InstanceID,
+		BindingID:  "concurrent",
+		ServiceID:  instance.ServiceID,
+		PlanID:     instance.PlanID,
+	}
+	unbind := &osb.UnbindRequest{
+		InstanceID: instance.InstanceID,
+		BindingID:  "concurrent",
+		ServiceID:  instance.ServiceID,
+		PlanID:     instance.PlanID,
+	}
+
+	// the provision is processing until the pods are ready
+	cluster.SetCreating(instance.InstanceID, true)
+	_, err := b.Update(update, c)
+	expectConcurrencyError(t, err, "update during the provision")
+	_, err = b.Deprovision(deprovision, c)
+	expectConcurrencyError(t, err, "deprovision during the provision")
+	cluster.SetCreating(instance.InstanceID, false)
+
+	// a restore whose state can not be refreshed, no backup target is configured
+	b.db.InsertBackup(&dao.Backup{BackupID: "restoring", InstanceID: instance.InstanceID,
+		State: LastStateSuccess, RestoreState: LastStateProcessing})
+	_, err = b.Update(update, c)
+	expectConcurrencyError(t, err, "update during a restore")
+	_, err = b.Deprovision(deprovision, c)
+	expectConcurrencyError(t, err, "deprovision during a restore")
+	b.db.UpdateBackup(&dao.Backup{BackupID: "restoring", State: LastStateSuccess, RestoreState: LastStateSuccess})
+
+	b.db.InsertBinding(&dao.Binding{BindingID: "binding", InstanceID: instance.InstanceID,
+		State: LastStateProcessing, Operation: AuditBind})
+	_, err = b.Update(update, c)
+	expectConcurrencyError(t, err, "update during a bind")
+	_, err = b.Deprovision(deprovision, c)
+	expectConcurrencyError(t, err, "deprovision during a bind")
+	b.db.DeleteBinding("binding")
+
+	// another replica holds the lock
+	b.db.AcquireInstanceLock(instance.InstanceID, AuditUpdate, "other", time.Minute)
+	_, err = b.Bind(bind, c)
+	expectConcurrencyError(t, err, "bind during an update")
+	_, err = b.Unbind(unbind, c)
+	expectConcurrencyError(t, err, "unbind during an update")
+	b.db.DeleteInstanceLock(instance.InstanceID)
+
+	_, err = b.Update(update, c)
+	if err != nil {
+		t.Fatalf("expect the update once the instance is idle, got %v", err)
+	}
+}
+
+func TestForceDeleteInstance(t *testing.T) {
+	b, cluster := newTestLogic(t)
+	b.bindingSecrets = true
+	c := asyncContext()
+	instance := provisionTestInstance(t, b, "stuck")
+
+	_, err := b.Bind(&osb.BindRequest{
+		InstanceID:        instance.InstanceID,
+		BindingID:         "app:1",
+		ServiceID:         instance.ServiceID,
+		PlanID:            instance.PlanID,
+		AcceptsIncomplete: true,
+		Context: map[string]interface{}{
+			contextPlatform:  osb.PlatformKubernetes,
+			contextNamespace: "app",
+		},
+	}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.operations.Wait()
+	secretName := bindingSecretName("app:1")
+	if cluster.Get("Secret", "app", secretName) == nil {
+		t.Fatal("expect the secret of the binding")
+	}
+
+	// an operation holding the lock keeps the deletion out
+	unlock, err := b.lockInstance(instance.InstanceID, AuditUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.ForceDeleteInstance(context.Background(), instance, nil)
+	expectConcurrencyError(t, err, "force delete during an update")
+	if stored, _ := b.db.SelectInstance(instance.InstanceID); stored.InstanceID == "" {
+		t.Fatal("expect the instance kept while it is locked")
+	}
+	unlock()
+
+	err = b.ForceDeleteInstance(context.Background(), instance, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binding, _ := b.db.SelectBinding("app:1"); binding.BindingID != "" {
+		t.Fatal("expect the binding deleted with the instance")
+	}
+	if cluster.Get("Secret", "app", secretName) != nil {
+		t.Fatal("expect the secret of the binding deleted with the instance")
+	}
+	if objects := cluster.Objects(); len(objects) != 0 {
+		t.Fatalf("expect every object deleted, %d left", len(objects))
+	}
+	if stored, _ := b.db.SelectInstance(instance.InstanceID); stored.InstanceID != "" {
+		t.Fatal("expect the instance deleted")
+	}
+
+	// the lock is released at the end
+	unlock, err = b.lockInstance(instance.InstanceID, AuditProvision)
+	if err != nil {
+		t.Fatalf("expect the lock released, got %v", err)
+	}
+	unlock()
+}
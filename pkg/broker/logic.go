@@ -1,14 +1,19 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/arugaki/osb-starter-pack/pkg/dao"
 	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/metrics"
 	"github.com/arugaki/osb-starter-pack/pkg/service"
 	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"net/http"
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/pmorie/osb-broker-lib/pkg/broker"
 
@@ -20,8 +25,6 @@ import (
 type BusinessLogic struct {
 	// Indicates if the broker should handle the requests asynchronously.
 	async bool
-	// Synchronize go routines.
-	sync.RWMutex
 	// Catalog Infomations
 	catalogs []osb.Service
 	// Kubectl apply -f template
@@ -31,157 +34,198 @@ type BusinessLogic struct {
 	// serviceId planId mapping
 	serviceIdPlan map[string]map[string]osb.Plan
 	// mysql db
-	db *dao.Dao
-	// kubernetes client
-	kcl *kubernetes.KubeCli
+	db dao.Store
+	// kubernetes clients of the target clusters
+	clusters *kubernetes.Clusters
+	// fall back to the NAMESPACE parameter when the platform context has no namespace
+	allowParameterNamespace bool
 	// services
 	services map[string]service.Service
+	// name of this replica in the instance locks
+	replica string
+	// how long an instance lock is held at most
+	lockTTL time.Duration
+	// domain metrics of the broker
+	metrics *metrics.BrokerMetricsCollector
+	// storage of the backups, nil when backups are disabled
+	backupTarget *service.BackupTarget
+	// how long a backup or a restore may run, 0 waits forever
+	backupTimeout time.Duration
+	// write the credentials of the bindings into Secrets
+	bindingSecrets bool
+	// how long the previous credentials of a rotated binding stay valid by default
+	rotationGrace time.Duration
+	// the binding operations running in the background by binding id, none are started once
+	// shuttingDown is set
+	operations   sync.WaitGroup
+	operationsMu sync.Mutex
+	running      map[string]*dao.Binding
+	shuttingDown bool
 }
 
 func (b *BusinessLogic) GetCatalog(c *broker.RequestContext) (*broker.CatalogResponse, error) {
 	// Your catalog business logic goes here
 	response := &broker.CatalogResponse{}
 	osbResponse := &osb.CatalogResponse{
-		Services: b.catalogs,
+		Services: catalogForVersion(b.catalogs, requestAPIVersion(c)),
 	}
 
 	response.CatalogResponse = *osbResponse
 	return response, nil
 }
 
-func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (*broker.ProvisionResponse, error) {
-	instance, err := b.db.SelectInstance(request.InstanceID)
+func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (_ *broker.ProvisionResponse, err error) {
+	ctx, span := startOperation(c, "osb.Provision", request.InstanceID)
+	defer func() {
+		span.Finish(err)
+	}()
+	db := b.db.WithContext(ctx)
+
+	defer func() {
+		b.audit(&auditEntry{
+			operation:  AuditProvision,
+			instanceId: request.InstanceID,
+			serviceId:  request.ServiceID,
+			planId:     request.PlanID,
+			identity:   request.OriginatingIdentity,
+			context:    request.Context,
+			parameters: request.Parameters,
+		}, err)
+	}()
+	defer b.observeStage(AuditProvision, metrics.StageTotal, time.Now())
+
+	// the instance is ready once its pods are, which the platform learns by polling last operation
+	if !request.AcceptsIncomplete {
+		return nil, asyncRequired()
+	}
+
+	unlock, err := b.lockInstance(request.InstanceID, AuditProvision)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	instance, err := db.SelectInstance(request.InstanceID)
 	if err != nil {
 		glog.Errorf("select instance by instance id failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusServiceUnavailable,
-			ResponseError: err,
-		}
+		return nil, storeUnavailable(err)
 	}
 
 	if instance.InstanceID == request.InstanceID {
-		description := fmt.Sprintf("instance id %s is exist", request.InstanceID)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:  http.StatusConflict,
-			Description: &description,
-		}
+		return provisionExisting(instance, request)
 	}
 
 	serviceName, err := b.getServiceName(request.ServiceID)
 	if err != nil {
 		glog.Errorf("get service name by service id failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		return nil, badRequest(err, "unknown service id")
 	}
 
 	plan, err := b.getPlan(request.ServiceID, request.PlanID)
 	if err != nil {
 		glog.Errorf("get plan by serivce id and plan id failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		return nil, badRequest(err, "unknown plan id")
 	}
 
-	srcTemplate, err := b.getServiceTemplate(serviceName)
+	err = validateParameters(plan, request.Parameters)
 	if err != nil {
-		glog.Errorf("get service template by serivce name failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		glog.Errorf("validate parameters failed, err is %+v", err)
+		return nil, badRequest(err, err.Error())
 	}
 
-	namespace, err := getNamespace(request.Parameters)
+	nsOption, err := b.getNamespacePerInstance(request.ServiceID)
 	if err != nil {
-		glog.Errorf("get namespace from parameters failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		glog.Errorf("get namespace option of service failed, err is %+v", err)
+		return nil, internalError(err, "the namespace option of the service is invalid")
 	}
 
-	instanceName, err := getInstanceName(request.Parameters)
-	if err != nil {
-		glog.Errorf("get instance name from parameters failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
+	var namespace string
+	if nsOption != nil {
+		namespace = instanceNamespace(request.InstanceID)
+	} else {
+		namespace, err = b.getNamespace(request.Context, request.Parameters)
+		if err != nil {
+			glog.Errorf("get namespace from context and parameters failed, err is %+v", err)
+			return nil, badRequest(err, "the namespace of the instance is missing")
 		}
 	}
 
-	templateAfterInit, err := templateInit(srcTemplate, namespace, instanceName, instance.InstanceID)
+	instanceName, err := getInstanceName(request.Context, request.Parameters)
 	if err != nil {
-		glog.Errorf("apply namespace to templates failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		glog.Errorf("get instance name from context and parameters failed, err is %+v", err)
+		return nil, badRequest(err, "the name of the instance is missing")
 	}
 
-	templateAfterParams, err := b.applyParameters(serviceName, templateAfterInit, request.Parameters)
+	clusterName := b.getClusterName(request.Parameters, plan)
+	kcl, err := b.getCluster(clusterName)
 	if err != nil {
-		glog.Errorf("apply parameters to templates failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		return nil, unprocessable(err, "unknown cluster")
 	}
+	kcl = kcl.WithContext(ctx)
 
-	templateAfterPlan, err := b.applyPlan(serviceName, templateAfterParams, plan)
+	renderStart := time.Now()
+	rendered, templateAfterPlan, err := b.renderTemplate(ctx, serviceName, namespace, instanceName, request.InstanceID, request.Parameters, plan)
 	if err != nil {
-		glog.Errorf("apply plan to templates failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		return nil, err
 	}
+	b.observeStage(AuditProvision, metrics.StageRender, renderStart)
 
 	params, err := json.Marshal(request.Parameters)
 	if err != nil {
-		glog.Errorf("apply plan to templates failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
+		glog.Errorf("marshal parameters failed, err is %+v", err)
+		return nil, badRequest(err, "the parameters are invalid")
+	}
+
+	context, err := json.Marshal(request.Context)
+	if err != nil {
+		glog.Errorf("marshal context failed, err is %+v", err)
+		return nil, badRequest(err, "the context of the request is invalid")
+	}
+
+	applyStart := time.Now()
+	if nsOption != nil {
+		quota, err := getQuota(plan, nsOption)
+		if err != nil {
+			glog.Errorf("get namespace quota from plan failed, err is %+v", err)
+			return nil, internalError(err, "the quota of the plan is invalid")
+		}
+
+		err = kcl.EnsureInstanceNamespace(namespace, request.InstanceID, quota)
+		if err != nil {
+			glog.Errorf("create instance namespace in kubernetes failed, err is %+v", err)
+			return nil, internalError(err, "failed to prepare the namespace of the instance")
 		}
 	}
 
-	kubeServices, err := b.kcl.CreateService(templateAfterPlan)
+	kubeServices, err := kcl.CreateService(templateAfterPlan)
 	if err != nil {
+		b.observeKubernetesError(err)
 		glog.Errorf("create services in kubernetes failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		cleanupProvision(kcl, templateAfterPlan, namespace, request.InstanceID, err)
+		return nil, internalError(err, "failed to create the kubernetes services of the instance")
 	}
 
-	templateFinish, err := b.applySpecial(serviceName, templateAfterPlan, kubeServices)
+	_, templateFinish, err := b.applySpecial(ctx, kcl, serviceName, rendered, kubeServices)
 	if err != nil {
 		glog.Errorf("apply special variables failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		cleanupProvision(kcl, templateAfterPlan, namespace, request.InstanceID, err)
+		return nil, internalError(err, "failed to render the template of the service")
 	}
 
-	_, err = b.kcl.CreateInstance(templateFinish)
+	_, err = kcl.CreateInstance(templateFinish)
 	if err != nil {
+		b.observeKubernetesError(err)
 		glog.Errorf("create deployments in kubernetes failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		cleanupProvision(kcl, templateFinish, namespace, request.InstanceID, err)
+		return nil, internalError(err, "failed to create the kubernetes objects of the instance")
 	}
+	b.observeStage(AuditProvision, metrics.StageApply, applyStart)
 
-	dashboardURL, err := b.getDashboardURL(serviceName, request.Parameters, kubeServices)
+	dashboardURL, err := b.getDashboardURL(ctx, kcl, serviceName, request.Parameters, kubeServices)
 	if err != nil {
 		glog.Errorf("get dashboard url failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		cleanupProvision(kcl, templateFinish, namespace, request.InstanceID, err)
+		return nil, internalError(err, "failed to get the dashboard url of the instance")
 	}
 
 	instance = &dao.Instance{
@@ -193,17 +237,18 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 		OrganizationGUID: request.OrganizationGUID,
 		PlanID:           request.PlanID,
 		Namespace:        namespace,
+		Cluster:          clusterName,
+		Context:          string(context),
+		State:            LastStateProcessing,
 		Parameters:       string(params),
 		Yaml:             templateFinish,
 	}
 
-	_, err = b.db.InsertInstance(instance)
+	_, err = db.InsertInstance(instance)
 	if err != nil {
 		glog.Errorf("insert into instance failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusServiceUnavailable,
-			ResponseError: err,
-		}
+		cleanupProvision(kcl, templateFinish, namespace, request.InstanceID, err)
+		return nil, storeUnavailable(err)
 	}
 
 	response := broker.ProvisionResponse{}
@@ -213,16 +258,120 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 	return &response, nil
 }
 
-func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.RequestContext) (*broker.DeprovisionResponse, error) {
-	instance, err := b.db.SelectInstance(request.InstanceID)
+// cleanupProvision deletes the objects of a failed provision. The instance is not recorded, so the
+// deprovision the platform sends for orphan mitigation finds nothing to delete. The objects are kept
+// when the failure is a name collision, they may belong to another instance.
+func cleanupProvision(kcl kubernetes.Cluster, template, namespace, instanceId string, cause error) {
+	if objErr, ok := cause.(*kubernetes.ObjectError); ok && objErr.Reason() == metav1.StatusReasonAlreadyExists {
+		glog.Warningf("keep the objects of the failed provision of instance %s, %v", instanceId, cause)
+		return
+	}
+
+	err := kcl.DeleteInstance(template)
 	if err != nil {
-		glog.Errorf("select instance by instance id failed, err is %+v", err)
+		glog.Errorf("delete the objects of the failed provision of instance %s failed, err is %+v", instanceId, err)
+		return
+	}
+
+	_, err = kcl.DeleteInstanceNamespace(namespace, instanceId)
+	if err != nil {
+		glog.Errorf("delete the namespace of the failed provision of instance %s failed, err is %+v", instanceId, err)
+	}
+}
+
+// provisionExisting answers a provision of an instance which exists. A retry of the original request
+// gets 200, or 202 while the instance is not ready yet. Different attributes and a failed instance
+// are a conflict, the platform deprovisions the failed instance before it provisions again.
+func provisionExisting(instance *dao.Instance, request *osb.ProvisionRequest) (*broker.ProvisionResponse, error) {
+	same, err := sameParameters(instance.Parameters, request.Parameters)
+	if err != nil {
+		glog.Errorf("compare parameters of instance failed, err is %+v", err)
+		return nil, internalError(err, "the stored parameters of the instance are invalid")
+	}
+
+	if !same || instance.ServiceID != request.ServiceID || instance.PlanID != request.PlanID {
+		description := fmt.Sprintf("instance id %s exists with different attributes", request.InstanceID)
 		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusServiceUnavailable,
-			ResponseError: err,
+			StatusCode:  http.StatusConflict,
+			Description: &description,
 		}
 	}
 
+	response := broker.ProvisionResponse{}
+	switch instance.State {
+	case LastStateFailed:
+		description := fmt.Sprintf("instance id %s exists and its last operation failed", request.InstanceID)
+		return nil, osb.HTTPStatusCodeError{
+			StatusCode:  http.StatusConflict,
+			Description: &description,
+		}
+	case LastStateProcessing:
+		// the platform learns the outcome by polling last operation as for the original request
+		response.Async = true
+		response.OperationKey = succeed()
+	default:
+		response.Exists = true
+	}
+	return &response, nil
+}
+
+// sameParameters compares the stored parameters of an instance with the parameters of a request,
+// both are normalized through json so numbers and nested objects compare equal.
+func sameParameters(stored string, params map[string]interface{}) (bool, error) {
+	var storedParams map[string]interface{}
+	if stored != "" {
+		err := json.Unmarshal([]byte(stored), &storedParams)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return false, err
+	}
+	var requestParams map[string]interface{}
+	err = json.Unmarshal(data, &requestParams)
+	if err != nil {
+		return false, err
+	}
+
+	if len(storedParams) == 0 && len(requestParams) == 0 {
+		return true, nil
+	}
+	return reflect.DeepEqual(storedParams, requestParams), nil
+}
+
+func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.RequestContext) (_ *broker.DeprovisionResponse, err error) {
+	ctx, span := startOperation(c, "osb.Deprovision", request.InstanceID)
+	defer func() {
+		span.Finish(err)
+	}()
+	db := b.db.WithContext(ctx)
+
+	defer func() {
+		b.audit(&auditEntry{
+			operation:  AuditDeprovision,
+			instanceId: request.InstanceID,
+			serviceId:  request.ServiceID,
+			planId:     request.PlanID,
+			identity:   request.OriginatingIdentity,
+		}, err)
+	}()
+	defer b.observeStage(AuditDeprovision, metrics.StageTotal, time.Now())
+
+	unlock, err := b.lockInstance(request.InstanceID, AuditDeprovision)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	instance, err := db.SelectInstance(request.InstanceID)
+	if err != nil {
+		glog.Errorf("select instance by instance id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+
 	if instance.InstanceID == "" {
 		description := fmt.Sprintf("instance id %s is gone", request.InstanceID)
 		return nil, osb.HTTPStatusCodeError{
@@ -231,40 +380,49 @@ func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.R
 		}
 	}
 
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return nil, internalError(err, "the cluster of the instance is not configured")
+	}
+	kcl = kcl.WithContext(ctx)
+
+	err = b.checkIdle(ctx, kcl, instance)
+	if err != nil {
+		return nil, err
+	}
+
 	err = b.beforeKubeDelete(instance)
 	if err != nil {
 		glog.Errorf("delete before kubernetes resources failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		return nil, internalError(err, "failed to clean up the service of the instance")
 	}
 
-	err = b.kcl.DeleteInstance(instance.Yaml)
+	applyStart := time.Now()
+	err = kcl.DeleteInstance(instance.Yaml)
 	if err != nil {
+		b.observeKubernetesError(err)
 		glog.Errorf("delete kubernetes resources failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		return nil, internalError(err, "failed to delete the kubernetes objects of the instance")
 	}
+	b.observeStage(AuditDeprovision, metrics.StageApply, applyStart)
 
 	err = b.afterKubeDelete(instance)
 	if err != nil {
-		glog.Errorf("delete before kubernetes resources failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		glog.Errorf("delete after kubernetes resources failed, err is %+v", err)
+		return nil, internalError(err, "failed to clean up the service of the instance")
 	}
 
-	_, err = b.db.DeleteInstance(request.InstanceID)
+	// only removes the namespace dedicated to this instance
+	_, err = kcl.DeleteInstanceNamespace(instance.Namespace, instance.InstanceID)
+	if err != nil {
+		glog.Errorf("delete instance namespace failed, err is %+v", err)
+		return nil, internalError(err, "failed to delete the namespace of the instance")
+	}
+
+	_, err = db.DeleteInstance(request.InstanceID)
 	if err != nil {
 		glog.Errorf("delete instance by instance id failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusServiceUnavailable,
-			ResponseError: err,
-		}
+		return nil, storeUnavailable(err)
 	}
 
 	response := broker.DeprovisionResponse{}
@@ -273,14 +431,17 @@ func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.R
 	return &response, nil
 }
 
-func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
-	instance, err := b.db.SelectInstance(request.InstanceID)
+func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *broker.RequestContext) (_ *broker.LastOperationResponse, err error) {
+	ctx, span := startOperation(c, "osb.LastOperation", request.InstanceID)
+	defer func() {
+		span.Finish(err)
+	}()
+	db := b.db.WithContext(ctx)
+
+	instance, err := db.SelectInstance(request.InstanceID)
 	if err != nil {
 		glog.Errorf("select instance by instance id failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusServiceUnavailable,
-			ResponseError: err,
-		}
+		return nil, storeUnavailable(err)
 	}
 
 	if instance.InstanceID == "" {
@@ -291,195 +452,244 @@ func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *brok
 		}
 	}
 
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return nil, internalError(err, "the cluster of the instance is not configured")
+	}
+	kcl = kcl.WithContext(ctx)
+
+	state, err := b.instanceState(kcl, instance)
+	if err != nil {
+		return nil, internalError(err, "failed to get the status of the instance")
+	}
+	b.recordState(instance, state)
+
 	response := &broker.LastOperationResponse{}
+	response.State = osbState(state)
+	return response, nil
+}
 
-	podCreating, _, podFailed, err := b.kcl.CheckInstance(instance.InstanceID, instance.Namespace)
+// osbState returns the state of the OSB spec for a state of the store.
+func osbState(state osb.LastOperationState) osb.LastOperationState {
+	switch state {
+	case LastStateSuccess:
+		return osb.StateSucceeded
+	case LastStateProcessing:
+		return osb.StateInProgress
+	}
+	return osb.StateFailed
+}
+
+// instanceState checks the pods of the instance and the service specific state.
+func (b *BusinessLogic) instanceState(kcl kubernetes.Cluster, instance *dao.Instance) (osb.LastOperationState, error) {
+	podCreating, _, podFailed, err := kcl.CheckInstance(instance.InstanceID, instance.Namespace)
 	if err != nil {
 		glog.Errorf("get deployment status from kubernetes failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		return "", err
 	}
 
 	if podFailed {
-		response.State = LastStateFailed
-		return response, nil
+		return LastStateFailed, nil
 	}
 
 	if podCreating {
-		response.State = LastStateProcessing
-		return response, nil
+		return LastStateProcessing, nil
 	}
 
 	processing, _, failed, err := b.lastStateCheck(instance)
 	if err != nil {
 		glog.Errorf("last state check failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		return "", err
 	}
 
 	if processing {
-		response.State = LastStateProcessing
-		return response, nil
+		return LastStateProcessing, nil
 	}
 
 	if failed {
-		response.State = LastStateFailed
-		return response, nil
+		return LastStateFailed, nil
 	}
 
-	response.State = LastStateSuccess
-	return response, nil
+	return LastStateSuccess, nil
 }
 
-func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
-	instance, err := b.db.SelectInstance(request.InstanceID)
+func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (_ *broker.UpdateInstanceResponse, err error) {
+	ctx, span := startOperation(c, "osb.Update", request.InstanceID)
+	defer func() {
+		span.Finish(err)
+	}()
+	db := b.db.WithContext(ctx)
+
+	entry := &auditEntry{
+		operation:  AuditUpdate,
+		instanceId: request.InstanceID,
+		serviceId:  request.ServiceID,
+		identity:   request.OriginatingIdentity,
+		context:    request.Context,
+		parameters: request.Parameters,
+	}
+	if request.PlanID != nil {
+		entry.planId = *request.PlanID
+	}
+	defer func() {
+		b.audit(entry, err)
+	}()
+	defer b.observeStage(AuditUpdate, metrics.StageTotal, time.Now())
+
+	unlock, err := b.lockInstance(request.InstanceID, AuditUpdate)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	instance, err := db.SelectInstance(request.InstanceID)
 	if err != nil {
 		glog.Errorf("select instance by instance id failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusServiceUnavailable,
-			ResponseError: err,
-		}
+		return nil, storeUnavailable(err)
 	}
 
-	if instance.InstanceID == request.InstanceID {
-		description := fmt.Sprintf("instance id %s is exist", request.InstanceID)
+	if instance.InstanceID == "" {
+		description := fmt.Sprintf("instance id %s is gone", request.InstanceID)
 		return nil, osb.HTTPStatusCodeError{
-			StatusCode:  http.StatusConflict,
+			StatusCode:  http.StatusGone,
 			Description: &description,
 		}
 	}
 
-	serviceName, err := b.getServiceName(request.ServiceID)
-	if err != nil {
-		glog.Errorf("get service name by service id failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+	if cluster, ok := request.Parameters["CLUSTER"]; ok && fmt.Sprintf("%v", cluster) != instance.Cluster {
+		return nil, unprocessable(ClusterNotUpdatable, "")
 	}
 
-	plan, err := b.getPlan(request.ServiceID, *request.PlanID)
+	kcl, err := b.getCluster(instance.Cluster)
 	if err != nil {
-		glog.Errorf("get plan by serivce id and plan id failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		return nil, internalError(err, "the cluster of the instance is not configured")
 	}
+	kcl = kcl.WithContext(ctx)
 
-	srcTemplate, err := b.getServiceTemplate(serviceName)
+	nsOption, err := b.getNamespacePerInstance(request.ServiceID)
 	if err != nil {
-		glog.Errorf("get service template by serivce name failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		glog.Errorf("get namespace option of service failed, err is %+v", err)
+		return nil, internalError(err, "the namespace option of the service is invalid")
+	}
+
+	// the instance stays in its namespace whatever the platform context says
+	namespace := instance.Namespace
+	if ns := getContextNamespace(request.Context); nsOption == nil && ns != "" && ns != namespace {
+		return nil, unprocessable(NamespaceNotUpdatable, "")
+	}
+
+	if isContextUpdate(request, instance) {
+		return b.updateContext(db, instance, request)
+	}
+
+	if !request.AcceptsIncomplete {
+		return nil, asyncRequired()
 	}
 
-	namespace, err := getNamespace(request.Parameters)
+	err = b.checkIdle(ctx, kcl, instance)
 	if err != nil {
-		glog.Errorf("get namespace from parameters failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		return nil, err
 	}
 
-	instanceName, err := getInstanceName(request.Parameters)
+	serviceName, err := b.getServiceName(request.ServiceID)
 	if err != nil {
-		glog.Errorf("get instance name from parameters failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		glog.Errorf("get service name by service id failed, err is %+v", err)
+		return nil, badRequest(err, "unknown service id")
 	}
 
-	storageClass := getStorageClass(request.Parameters)
+	planId := instance.PlanID
+	if request.PlanID != nil {
+		planId = *request.PlanID
+	}
 
-	templateAfterInit, err := templateInit(srcTemplate, namespace, instanceName, storageClass, instance.InstanceID)
+	plan, err := b.getPlan(request.ServiceID, planId)
 	if err != nil {
-		glog.Errorf("apply namespace to templates failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		glog.Errorf("get plan by serivce id and plan id failed, err is %+v", err)
+		return nil, badRequest(err, "unknown plan id")
 	}
 
-	templateAfterParams, err := b.applyParameters(serviceName, templateAfterInit, request.Parameters)
-	if err != nil {
-		glog.Errorf("apply parameters to templates failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
+	// an update without parameters keeps the parameters of the instance
+	if request.Parameters != nil {
+		err = validateParameters(plan, request.Parameters)
+		if err != nil {
+			glog.Errorf("validate parameters failed, err is %+v", err)
+			return nil, badRequest(err, err.Error())
+		}
+	} else {
+		err = json.Unmarshal([]byte(instance.Parameters), &request.Parameters)
+		if err != nil {
+			glog.Errorf("unmarshal parameters of instance failed, err is %+v", err)
+			return nil, internalError(err, "the stored parameters of the instance are invalid")
 		}
 	}
 
-	templateAfterPlan, err := b.applyPlan(serviceName, templateAfterParams, plan)
+	renderStart := time.Now()
+	rendered, templateAfterPlan, err := b.renderTemplate(ctx, serviceName, namespace, instance.InstanceName, instance.InstanceID, request.Parameters, plan)
 	if err != nil {
-		glog.Errorf("apply plan to templates failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
-		}
+		return nil, err
 	}
+	b.observeStage(AuditUpdate, metrics.StageRender, renderStart)
 
 	params, err := json.Marshal(request.Parameters)
 	if err != nil {
-		glog.Errorf("apply plan to templates failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
+		glog.Errorf("marshal parameters failed, err is %+v", err)
+		return nil, badRequest(err, "the parameters are invalid")
+	}
+
+	applyStart := time.Now()
+	if nsOption != nil {
+		quota, err := getQuota(plan, nsOption)
+		if err != nil {
+			glog.Errorf("get namespace quota from plan failed, err is %+v", err)
+			return nil, internalError(err, "the quota of the plan is invalid")
+		}
+
+		err = kcl.EnsureInstanceNamespace(namespace, instance.InstanceID, quota)
+		if err != nil {
+			glog.Errorf("update instance namespace in kubernetes failed, err is %+v", err)
+			return nil, internalError(err, "failed to prepare the namespace of the instance")
 		}
 	}
 
-	kubeServices, err := b.kcl.UpdateService(instance.InstanceID, templateAfterPlan)
+	kubeServices, err := kcl.UpdateService(instance.InstanceID, templateAfterPlan)
 	if err != nil {
+		b.observeKubernetesError(err)
 		glog.Errorf("update services in kubernetes failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		return nil, internalError(err, "failed to update the kubernetes services of the instance")
 	}
 
-	templateFinish, err := b.applySpecial(serviceName, templateAfterPlan, kubeServices)
+	_, templateFinish, err := b.applySpecial(ctx, kcl, serviceName, rendered, kubeServices)
 	if err != nil {
 		glog.Errorf("apply special variables failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		return nil, internalError(err, "failed to render the template of the service")
 	}
 
-	_, err = b.kcl.UpdateInstance(instance.InstanceID, templateFinish)
+	_, err = kcl.UpdateInstance(instance.InstanceID, templateFinish)
 	if err != nil {
-		glog.Errorf("create deployments in kubernetes failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
-		}
+		b.observeKubernetesError(err)
+		glog.Errorf("update deployments in kubernetes failed, err is %+v", err)
+		return nil, internalError(err, "failed to update the kubernetes objects of the instance")
 	}
+	b.observeStage(AuditUpdate, metrics.StageApply, applyStart)
 
-	instance = &dao.Instance{
-		InstanceID:  request.InstanceID,
-		ServiceID:   request.ServiceID,
-		ServiceName: serviceName,
-		PlanID:      *request.PlanID,
-		Namespace:   namespace,
-		Parameters:  string(params),
-		Yaml:        templateFinish,
+	if request.Context != nil {
+		context, err := json.Marshal(request.Context)
+		if err != nil {
+			glog.Errorf("marshal context failed, err is %+v", err)
+			return nil, badRequest(err, "the context of the request is invalid")
+		}
+		instance.Context = string(context)
 	}
 
-	_, err = b.db.UpdateInstance(instance)
+	instance.PlanID = planId
+	instance.State = LastStateProcessing
+	instance.Parameters = string(params)
+	instance.Yaml = templateFinish
+
+	_, err = db.UpdateInstance(instance)
 	if err != nil {
 		glog.Errorf("update instance by instance id failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusServiceUnavailable,
-			ResponseError: err,
-		}
+		return nil, storeUnavailable(err)
 	}
 
 	response := broker.UpdateInstanceResponse{}
@@ -488,25 +698,214 @@ func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.Req
 	return &response, nil
 }
 
-func (b *BusinessLogic) Bind(request *osb.BindRequest, c *broker.RequestContext) (*broker.BindResponse, error) {
-	serviceName, err := b.getServiceName(request.ServiceID)
+// updateContext records the new platform context of an instance without touching kubernetes.
+func (b *BusinessLogic) updateContext(db dao.Store, instance *dao.Instance, request *osb.UpdateInstanceRequest) (*broker.UpdateInstanceResponse, error) {
+	if !b.allowContextUpdates(request.ServiceID) {
+		return nil, unprocessable(ContextUpdateNotAllowed, "")
+	}
+
+	context, err := json.Marshal(request.Context)
+	if err != nil {
+		glog.Errorf("marshal context failed, err is %+v", err)
+		return nil, badRequest(err, "the context of the request is invalid")
+	}
+	instance.Context = string(context)
+
+	_, err = db.UpdateInstance(instance)
+	if err != nil {
+		glog.Errorf("update instance by instance id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+
+	response := broker.UpdateInstanceResponse{}
+	response.Async = false
+	return &response, nil
+}
+
+func (b *BusinessLogic) Bind(request *osb.BindRequest, c *broker.RequestContext) (_ *broker.BindResponse, err error) {
+	ctx, span := startOperation(c, "osb.Bind", request.InstanceID)
+	span.SetAttribute("osb.binding_id", request.BindingID)
+	defer func() {
+		span.Finish(err)
+	}()
+	db := b.db.WithContext(ctx)
+
+	defer func() {
+		b.audit(&auditEntry{
+			operation:  AuditBind,
+			instanceId: request.InstanceID,
+			bindingId:  request.BindingID,
+			serviceId:  request.ServiceID,
+			planId:     request.PlanID,
+			identity:   request.OriginatingIdentity,
+			context:    request.Context,
+			parameters: request.Parameters,
+		}, err)
+		b.observeBinding(request.ServiceID, AuditBind, err)
+	}()
+
+	if b.serviceFlag(request.ServiceID, "requires_app") &&
+		request.AppGUID == nil && (request.BindResource == nil || request.BindResource.AppGUID == nil) {
+		return nil, requiresApp()
+	}
+
+	_, err = b.getServiceName(request.ServiceID)
 	if err != nil {
 		glog.Errorf("get service name by service id failed, err is %+v", err)
+		return nil, badRequest(err, "unknown service id")
+	}
+
+	// an asynchronous bind runs after the lock is released, its processing state keeps the update
+	// and the deprovision out
+	unlock, err := b.lockInstance(request.InstanceID, AuditBind)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	instance, err := db.SelectInstance(request.InstanceID)
+	if err != nil {
+		glog.Errorf("select instance by instance id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+	if instance.InstanceID == "" {
+		description := fmt.Sprintf("instance id %s is not found", request.InstanceID)
 		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
+			StatusCode:  http.StatusNotFound,
+			Description: &description,
 		}
 	}
 
-	cred, err := b.bindInstance(serviceName, request)
+	// a retry of the original request binds again, only different attributes are a conflict
+	binding, err := db.SelectBinding(request.BindingID)
 	if err != nil {
-		glog.Errorf("bind instance failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
+		glog.Errorf("select binding by binding id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+	exists := binding.BindingID != ""
+	if exists {
+		same, err := sameBinding(binding, request)
+		if err != nil {
+			glog.Errorf("compare parameters of binding failed, err is %+v", err)
+			return nil, internalError(err, "the stored parameters of the binding are invalid")
+		}
+		if !same {
+			description := fmt.Sprintf("binding id %s exists with different attributes", request.BindingID)
+			return nil, osb.HTTPStatusCodeError{
+				StatusCode:  http.StatusConflict,
+				Description: &description,
+			}
 		}
 	}
 
+	if exists {
+		switch {
+		case binding.State == LastStateProcessing && binding.Operation == AuditBind:
+			if !acceptsAsyncBinding(request.AcceptsIncomplete, c) {
+				return nil, asyncRequired()
+			}
+			response := &broker.BindResponse{}
+			response.Async = true
+			response.OperationKey = bindingOperationKey(AuditBind)
+			return response, nil
+		case binding.State == LastStateProcessing:
+			return nil, concurrencyError()
+		case binding.State == LastStateFailed:
+			return nil, unprocessable(BindingNotReady, "")
+		}
+
+		// the retry gets the credentials issued for the original request, or by their last rotation
+		cred, err := decodeCredentials(binding.Credentials)
+		if err != nil {
+			glog.Errorf("decode credentials of binding failed, err is %+v", err)
+			return nil, internalError(err, "the stored credentials of the binding are invalid")
+		}
+		if binding.SecretName != "" {
+			cred, err = b.writeBindingSecret(ctx, instance, binding, cred)
+			if err != nil {
+				return nil, err
+			}
+		}
+		response := &broker.BindResponse{}
+		response.Credentials = cred
+		response.OperationKey = succeed()
+		response.Exists = true
+		return response, nil
+	}
+
+	// the parameters are checked before an asynchronous bind is accepted
+	err = b.validateBindParameters(instance.ServiceName, request.Parameters)
+	if err != nil {
+		glog.Errorf("validate binding parameters failed, err is %+v", err)
+		if _, ok := err.(service.ParameterError); ok {
+			return nil, badRequest(err, err.Error())
+		}
+		return nil, internalError(err, "failed to check the parameters of the binding")
+	}
+
+	async, err := b.asyncBindings(instance.ServiceName, request.AcceptsIncomplete, c)
+	if err != nil {
+		return nil, err
+	}
+	params, err := json.Marshal(request.Parameters)
+	if err != nil {
+		glog.Errorf("marshal binding parameters failed, err is %+v", err)
+		return nil, badRequest(err, "the parameters are invalid")
+	}
+	binding = &dao.Binding{
+		BindingID:  request.BindingID,
+		InstanceID: request.InstanceID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Parameters: string(params),
+		State:      LastStateSuccess,
+		Operation:  AuditBind,
+	}
+	if b.bindingSecrets {
+		binding.SecretNamespace = bindingSecretNamespace(request, instance)
+		binding.SecretName = bindingSecretName(request.BindingID)
+	}
+
+	if async {
+		// the binding is recorded first, its last operation is polled while the service binds
+		binding.State = LastStateProcessing
+		_, err = db.InsertBinding(binding)
+		if err != nil {
+			glog.Errorf("insert into bindings failed, err is %+v", err)
+			return nil, storeUnavailable(err)
+		}
+		b.runBindingOperation(binding, func(ctx context.Context) error {
+			_, err := b.issueCredentials(ctx, instance, binding, request)
+			if err != nil {
+				return err
+			}
+			binding.State = LastStateSuccess
+			_, err = b.db.WithContext(ctx).UpdateBinding(binding)
+			if err != nil {
+				glog.Errorf("update binding failed, err is %+v", err)
+				b.unbindFailedBind(ctx, instance, binding)
+				return storeUnavailable(err)
+			}
+			return nil
+		})
+
+		response := &broker.BindResponse{}
+		response.Async = true
+		response.OperationKey = bindingOperationKey(AuditBind)
+		return response, nil
+	}
+
+	cred, err := b.issueCredentials(ctx, instance, binding, request)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.InsertBinding(binding)
+	if err != nil {
+		glog.Errorf("insert into bindings failed, err is %+v", err)
+		b.unbindFailedBind(ctx, instance, binding)
+		return nil, storeUnavailable(err)
+	}
+
 	response := &broker.BindResponse{}
 	response.Credentials = cred
 	response.OperationKey = succeed()
@@ -514,23 +913,190 @@ func (b *BusinessLogic) Bind(request *osb.BindRequest, c *broker.RequestContext)
 	return response, nil
 }
 
-func (b *BusinessLogic) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*broker.UnbindResponse, error) {
-	serviceName, err := b.getServiceName(request.ServiceID)
+// issueCredentials has the service bind the instance for the new binding and writes the credentials
+// to the binding and its Secret, they are revoked again when they can not be handed out. The
+// credentials are returned with the reference of the Secret.
+func (b *BusinessLogic) issueCredentials(ctx context.Context, instance *dao.Instance, binding *dao.Binding, request *osb.BindRequest) (map[string]interface{}, error) {
+	cred, err := b.bindInstance(ctx, instance, request)
+	if err != nil {
+		glog.Errorf("bind instance failed, err is %+v", err)
+		if _, ok := err.(service.ParameterError); ok {
+			return nil, badRequest(err, err.Error())
+		}
+		return nil, internalError(err, "failed to bind the instance")
+	}
+	credentials, err := json.Marshal(cred)
+	if err != nil {
+		glog.Errorf("marshal binding credentials failed, err is %+v", err)
+		b.unbindFailedBind(ctx, instance, binding)
+		return nil, internalError(err, "the credentials of the binding are invalid")
+	}
+	binding.Credentials = string(credentials)
+
+	if binding.SecretName != "" {
+		cred, err = b.writeBindingSecret(ctx, instance, binding, cred)
+		if err != nil {
+			b.unbindFailedBind(ctx, instance, binding)
+			return nil, err
+		}
+	}
+	return cred, nil
+}
+
+// writeBindingSecret writes the credentials into the Secret of the binding and returns them with
+// the reference of the Secret.
+func (b *BusinessLogic) writeBindingSecret(ctx context.Context, instance *dao.Instance, binding *dao.Binding, cred map[string]interface{}) (map[string]interface{}, error) {
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return nil, internalError(err, "the cluster of the instance is not configured")
+	}
+
+	secret, err := newBindingSecret(binding.SecretNamespace, binding.SecretName, instance.InstanceID, binding.BindingID, cred)
+	if err != nil {
+		glog.Errorf("render binding secret failed, err is %+v", err)
+		return nil, internalError(err, "the credentials of the binding can not be written to a secret")
+	}
+	err = kcl.WithContext(ctx).ApplySecret(secret)
+	if err != nil {
+		glog.Errorf("apply binding secret failed, err is %+v", err)
+		return nil, internalError(err, "failed to write the credentials of the binding to a secret")
+	}
+	return withSecretRef(binding, cred), nil
+}
+
+// withSecretRef returns the credentials with the reference of the Secret of the binding.
+func withSecretRef(binding *dao.Binding, cred map[string]interface{}) map[string]interface{} {
+	withRef := make(map[string]interface{}, len(cred)+1)
+	for key, value := range cred {
+		withRef[key] = value
+	}
+	withRef[credentialSecret] = map[string]interface{}{
+		"namespace": binding.SecretNamespace,
+		"name":      binding.SecretName,
+	}
+	return withRef
+}
+
+// unbindFailedBind revokes the credentials of a bind which failed after the service issued them. The
+// parameters are left out, nothing was written with the credentials which could be kept.
+func (b *BusinessLogic) unbindFailedBind(ctx context.Context, instance *dao.Instance, binding *dao.Binding) {
+	failed := *binding
+	failed.Parameters = ""
+	err := b.unbindInstance(ctx, instance, &failed)
+	if err != nil {
+		glog.Errorf("unbind the failed bind of binding %s failed, err is %+v", binding.BindingID, err)
+	}
+}
+
+func (b *BusinessLogic) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (_ *broker.UnbindResponse, err error) {
+	ctx, span := startOperation(c, "osb.Unbind", request.InstanceID)
+	span.SetAttribute("osb.binding_id", request.BindingID)
+	defer func() {
+		span.Finish(err)
+	}()
+	db := b.db.WithContext(ctx)
+
+	defer func() {
+		b.audit(&auditEntry{
+			operation:  AuditUnbind,
+			instanceId: request.InstanceID,
+			bindingId:  request.BindingID,
+			serviceId:  request.ServiceID,
+			planId:     request.PlanID,
+			identity:   request.OriginatingIdentity,
+		}, err)
+		b.observeBinding(request.ServiceID, AuditUnbind, err)
+	}()
+
+	_, err = b.getServiceName(request.ServiceID)
 	if err != nil {
 		glog.Errorf("get service name by service id failed, err is %+v", err)
+		return nil, badRequest(err, "unknown service id")
+	}
+
+	unlock, err := b.lockInstance(request.InstanceID, AuditUnbind)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	binding, err := db.SelectBinding(request.BindingID)
+	if err != nil {
+		glog.Errorf("select binding by binding id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+	if binding.BindingID == "" {
+		description := fmt.Sprintf("binding id %s is gone", request.BindingID)
 		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusBadRequest,
-			ResponseError: err,
+			StatusCode:  http.StatusGone,
+			Description: &description,
 		}
 	}
 
-	err = b.unbindInstance(serviceName, request)
+	instance, err := db.SelectInstance(binding.InstanceID)
 	if err != nil {
-		glog.Errorf("unbind instance failed, err is %+v", err)
-		return nil, osb.HTTPStatusCodeError{
-			StatusCode:    http.StatusInternalServerError,
-			ResponseError: err,
+		glog.Errorf("select instance by instance id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+	if instance.InstanceID == "" {
+		// the credentials went with the instance, a Secret is left to its namespace
+		glog.Warningf("instance %s of binding %s is gone, only the binding is deleted", binding.InstanceID, binding.BindingID)
+	} else {
+		switch {
+		case binding.State == LastStateProcessing && binding.Operation == AuditUnbind:
+			if !acceptsAsyncBinding(request.AcceptsIncomplete, c) {
+				return nil, asyncRequired()
+			}
+			response := &broker.UnbindResponse{}
+			response.Async = true
+			response.OperationKey = bindingOperationKey(AuditUnbind)
+			return response, nil
+		case binding.State == LastStateProcessing:
+			return nil, concurrencyError()
+		}
+
+		async, err := b.asyncBindings(instance.ServiceName, request.AcceptsIncomplete, c)
+		if err != nil {
+			return nil, err
+		}
+		if async {
+			binding.State = LastStateProcessing
+			binding.Operation = AuditUnbind
+			binding.Description = ""
+			_, err = db.UpdateBinding(binding)
+			if err != nil {
+				glog.Errorf("update binding failed, err is %+v", err)
+				return nil, storeUnavailable(err)
+			}
+			b.runBindingOperation(binding, func(ctx context.Context) error {
+				err := b.unbindFromInstance(ctx, instance, binding)
+				if err != nil {
+					return err
+				}
+				_, err = b.db.WithContext(ctx).DeleteBinding(binding.BindingID)
+				if err != nil {
+					glog.Errorf("delete binding by binding id failed, err is %+v", err)
+					return storeUnavailable(err)
+				}
+				return nil
+			})
+
+			response := &broker.UnbindResponse{}
+			response.Async = true
+			response.OperationKey = bindingOperationKey(AuditUnbind)
+			return response, nil
 		}
+
+		err = b.unbindFromInstance(ctx, instance, binding)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = db.DeleteBinding(request.BindingID)
+	if err != nil {
+		glog.Errorf("delete binding by binding id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
 	}
 
 	response := &broker.UnbindResponse{}
@@ -538,6 +1104,35 @@ func (b *BusinessLogic) Unbind(request *osb.UnbindRequest, c *broker.RequestCont
 	return response, nil
 }
 
-func (b *BusinessLogic) ValidateBrokerAPIVersion(version string) error {
+// unbindFromInstance revokes the credentials of the binding, the previous ones of a rotation still in
+// its grace period too, and deletes its Secret.
+func (b *BusinessLogic) unbindFromInstance(ctx context.Context, instance *dao.Instance, binding *dao.Binding) error {
+	if binding.PreviousCredentials != "" {
+		err := b.revokePreviousCredentials(ctx, instance, binding)
+		if err != nil {
+			glog.Errorf("revoke previous credentials of binding %s failed, err is %+v", binding.BindingID, err)
+			return internalError(err, "failed to revoke the previous credentials of the binding")
+		}
+	}
+
+	err := b.unbindInstance(ctx, instance, binding)
+	if err != nil {
+		glog.Errorf("unbind instance failed, err is %+v", err)
+		return internalError(err, "failed to unbind the instance")
+	}
+
+	if binding.SecretName == "" {
+		return nil
+	}
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return internalError(err, "the cluster of the instance is not configured")
+	}
+	err = kcl.WithContext(ctx).DeleteSecret(binding.SecretNamespace, binding.SecretName,
+		bindingSecretLabels(binding.InstanceID, binding.BindingID))
+	if err != nil {
+		glog.Errorf("delete binding secret failed, err is %+v", err)
+		return internalError(err, "failed to delete the secret of the binding")
+	}
 	return nil
 }
@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+func TestSameParameters(t *testing.T) {
+	stored := `{"ZOO_TICK_TIME":2000,"backup":{"bucket":"zk"}}`
+
+	same, err := sameParameters(stored, map[string]interface{}{
+		"ZOO_TICK_TIME": 2000,
+		"backup":        map[string]interface{}{"bucket": "zk"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same {
+		t.Fatal("expect a retry with the same parameters to be identical")
+	}
+
+	same, err = sameParameters(stored, map[string]interface{}{"ZOO_TICK_TIME": 4000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same {
+		t.Fatal("expect different parameters to conflict")
+	}
+
+	same, err = sameParameters("null", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same {
+		t.Fatal("expect no parameters and empty parameters to be identical")
+	}
+}
+
+func TestProvisionExisting(t *testing.T) {
+	b, _ := newTestLogic(t)
+	c := &broker.RequestContext{}
+	instance := provisionTestInstance(t, b, "existing")
+
+	retry := &osb.ProvisionRequest{
+		InstanceID:        instance.InstanceID,
+		ServiceID:         instance.ServiceID,
+		PlanID:            instance.PlanID,
+		AcceptsIncomplete: true,
+	}
+	response, err := b.Provision(retry, c)
+	if err != nil || !response.Async {
+		t.Fatalf("expect 202 for a retry while the instance is processing, got %+v, %v", response, err)
+	}
+
+	if _, err := b.LastOperation(&osb.LastOperationRequest{InstanceID: instance.InstanceID}, c); err != nil {
+		t.Fatal(err)
+	}
+	response, err = b.Provision(retry, c)
+	if err != nil || !response.Exists || response.Async {
+		t.Fatalf("expect 200 for a retry of a provisioned instance, got %+v, %v", response, err)
+	}
+
+	different := *retry
+	different.Parameters = map[string]interface{}{"ZOO_TICK_TIME": 4000}
+	_, err = b.Provision(&different, c)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusConflict {
+		t.Fatalf("expect 409 for different parameters, got %v", err)
+	}
+
+	b.db.UpdateInstanceState(instance.InstanceID, LastStateFailed)
+	_, err = b.Provision(retry, c)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusConflict {
+		t.Fatalf("expect 409 for a retry of a failed instance, got %v", err)
+	}
+}
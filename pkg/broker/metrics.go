@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/metrics"
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+const unknownLabel = "Unknown"
+
+func (b *BusinessLogic) observeStage(operation, stage string, start time.Time) {
+	if b.metrics != nil {
+		b.metrics.ObserveStage(operation, stage, start)
+	}
+}
+
+// observeKubernetesError counts a failure to apply a template by the kind of the object and
+// the reason of the api server.
+func (b *BusinessLogic) observeKubernetesError(err error) {
+	if b.metrics == nil {
+		return
+	}
+
+	kind, reason := unknownLabel, unknownLabel
+	if objErr, ok := err.(*kubernetes.ObjectError); ok {
+		kind = objErr.Kind
+		if r := objErr.Reason(); r != "" {
+			reason = string(r)
+		}
+	}
+	b.metrics.KubernetesErrors.WithLabelValues(kind, reason).Inc()
+}
+
+func (b *BusinessLogic) observeBinding(serviceId, operation string, err error) {
+	if b.metrics == nil {
+		return
+	}
+
+	serviceName, e := b.getServiceName(serviceId)
+	if e != nil {
+		serviceName = unknownLabel
+	}
+	result := AuditSucceeded
+	if err != nil {
+		result = AuditFailed
+	}
+	b.metrics.BindingOperations.WithLabelValues(serviceName, operation, result).Inc()
+}
+
+// recordState stores the state reported by last operation, the readiness of a provision or an
+// update is observed when it leaves the processing state.
+func (b *BusinessLogic) recordState(instance *dao.Instance, state osb.LastOperationState) {
+	if instance.State == string(state) {
+		return
+	}
+
+	_, err := b.db.UpdateInstanceState(instance.InstanceID, string(state))
+	if err != nil {
+		glog.Errorf("update instance state failed, err is %+v", err)
+		return
+	}
+
+	if instance.State != LastStateProcessing {
+		return
+	}
+
+	start, err := time.ParseInLocation("2006-01-02 15:04:05", instance.UpdatedAt, time.Local)
+	if err != nil {
+		glog.Errorf("parse updated at of instance failed, err is %+v", err)
+		return
+	}
+
+	operation := AuditUpdate
+	if instance.CreatedAt == instance.UpdatedAt {
+		operation = AuditProvision
+	}
+	b.observeStage(operation, metrics.StageReadiness, start)
+}
@@ -0,0 +1,251 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/service"
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// The binds and unbinds of the services implementing service.AsyncBinder run in the background. The
+// binding is recorded processing with its operation before the platform is answered 202, the
+// background work records the result and the platform polls the last operation of the binding. A
+// binding left processing by a replica which stopped is failed by the background work of the leader.
+
+// bindingOperationTimeout bounds a binding operation, a binding processing for twice as long is failed.
+const bindingOperationTimeout = 10 * time.Minute
+
+func bindingOperationKey(operation string) *osb.OperationKey {
+	key := osb.OperationKey(operation)
+	return &key
+}
+
+// acceptsAsyncBinding tells whether the platform polls the binding operations, from 2.14 on.
+func acceptsAsyncBinding(acceptsIncomplete bool, c *broker.RequestContext) bool {
+	return acceptsIncomplete && requestAPIVersion(c).AtLeast(versionAsyncBindings)
+}
+
+// endpointSince answers 404 Not Found to the platforms older than the version introducing the
+// endpoint, they do not know it.
+func endpointSince(version APIVersion, c *broker.RequestContext) error {
+	if requestAPIVersion(c).AtLeast(version) {
+		return nil
+	}
+	description := fmt.Sprintf("the endpoint is not found before broker api version %s", version)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusNotFound,
+		Description: &description,
+	}
+}
+
+// asyncBindings tells whether the binding operations of the service run in the background, it fails
+// with AsyncRequired when the service needs them to but the platform does not accept it.
+func (b *BusinessLogic) asyncBindings(serviceName string, acceptsIncomplete bool, c *broker.RequestContext) (bool, error) {
+	binder, ok := b.services[serviceName].(service.AsyncBinder)
+	if !ok || !binder.AsyncBindings() {
+		return false, nil
+	}
+	if !acceptsAsyncBinding(acceptsIncomplete, c) {
+		return false, asyncRequired()
+	}
+	return true, nil
+}
+
+// runBindingOperation runs the work of the binding in the background. The work records its result,
+// a failure is recorded as the state of the binding. Once the broker shuts down the work is not
+// started, the binding is failed and the platform retries.
+func (b *BusinessLogic) runBindingOperation(binding *dao.Binding, work func(ctx context.Context) error) {
+	b.operationsMu.Lock()
+	if b.shuttingDown {
+		b.operationsMu.Unlock()
+		b.failBindingOperation(context.Background(), binding, BrokerShuttingDown)
+		return
+	}
+	if b.running == nil {
+		b.running = make(map[string]*dao.Binding)
+	}
+	// the work changes the binding, Shutdown fails a copy
+	running := *binding
+	b.running[binding.BindingID] = &running
+	b.operations.Add(1)
+	b.operationsMu.Unlock()
+
+	go func() {
+		defer func() {
+			b.operationsMu.Lock()
+			delete(b.running, binding.BindingID)
+			b.operationsMu.Unlock()
+			b.operations.Done()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), bindingOperationTimeout)
+		defer cancel()
+
+		err := work(ctx)
+		if err != nil {
+			b.failBindingOperation(ctx, binding, err)
+		}
+	}()
+}
+
+// failBindingOperation records the failure of the operation of the binding.
+func (b *BusinessLogic) failBindingOperation(ctx context.Context, binding *dao.Binding, err error) {
+	glog.Errorf("%s of binding %s failed, err is %+v", binding.Operation, binding.BindingID, err)
+	binding.State = LastStateFailed
+	binding.Description = operationDescription(err)
+	_, err = b.db.WithContext(ctx).UpdateBinding(binding)
+	if err != nil {
+		glog.Errorf("update binding failed, err is %+v", err)
+	}
+}
+
+// Shutdown stops accepting binding operations and waits at most timeout for those running in the
+// background. The operations still running then are failed, the process exits and kills them.
+func (b *BusinessLogic) Shutdown(timeout time.Duration) {
+	b.operationsMu.Lock()
+	b.shuttingDown = true
+	b.operationsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.operations.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	b.operationsMu.Lock()
+	running := make([]*dao.Binding, 0, len(b.running))
+	for _, binding := range b.running {
+		running = append(running, binding)
+	}
+	b.operationsMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, binding := range running {
+		glog.Warningf("%s of binding %s did not complete in %v", binding.Operation, binding.BindingID, timeout)
+		b.failBindingOperation(ctx, binding, BrokerShuttingDown)
+	}
+}
+
+// operationDescription returns the description of a failed operation which is safe to return to the
+// platform.
+func operationDescription(err error) string {
+	if e, ok := osb.IsHTTPError(err); ok && e.Description != nil {
+		return *e.Description
+	}
+	return describe(err, "the operation failed")
+}
+
+// BindingLastOperation returns the state of the last operation of a binding, 410 Gone once it is
+// unbound.
+func (b *BusinessLogic) BindingLastOperation(request *osb.BindingLastOperationRequest, c *broker.RequestContext) (_ *broker.LastOperationResponse, err error) {
+	ctx, span := startOperation(c, "osb.BindingLastOperation", request.InstanceID)
+	span.SetAttribute("osb.binding_id", request.BindingID)
+	defer func() {
+		span.Finish(err)
+	}()
+
+	err = endpointSince(versionAsyncBindings, c)
+	if err != nil {
+		return nil, err
+	}
+	binding, err := b.db.WithContext(ctx).SelectBinding(request.BindingID)
+	if err != nil {
+		glog.Errorf("select binding by binding id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+	if binding.BindingID == "" || binding.InstanceID != request.InstanceID {
+		description := fmt.Sprintf("binding id %s is gone", request.BindingID)
+		return nil, osb.HTTPStatusCodeError{
+			StatusCode:  http.StatusGone,
+			Description: &description,
+		}
+	}
+
+	response := &broker.LastOperationResponse{}
+	response.State = osbState(osb.LastOperationState(binding.State))
+	if binding.Description != "" {
+		response.Description = &binding.Description
+	}
+	return response, nil
+}
+
+// GetBinding returns the credentials of a binding whose bind succeeded, the platform fetches them
+// after an asynchronous bind.
+func (b *BusinessLogic) GetBinding(request *osb.GetBindingRequest, c *broker.RequestContext) (_ *osb.GetBindingResponse, err error) {
+	ctx, span := startOperation(c, "osb.GetBinding", request.InstanceID)
+	span.SetAttribute("osb.binding_id", request.BindingID)
+	defer func() {
+		span.Finish(err)
+	}()
+
+	err = endpointSince(versionGetEndpoints, c)
+	if err != nil {
+		return nil, err
+	}
+	binding, err := b.db.WithContext(ctx).SelectBinding(request.BindingID)
+	if err != nil {
+		glog.Errorf("select binding by binding id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+	if binding.BindingID == "" || binding.InstanceID != request.InstanceID ||
+		binding.State != LastStateSuccess || binding.Operation == AuditUnbind {
+		description := fmt.Sprintf("binding id %s is not found", request.BindingID)
+		return nil, newError(http.StatusNotFound, "", description, BindingNotFound)
+	}
+
+	cred, err := decodeCredentials(binding.Credentials)
+	if err != nil {
+		glog.Errorf("decode credentials of binding failed, err is %+v", err)
+		return nil, internalError(err, "the stored credentials of the binding are invalid")
+	}
+	if binding.SecretName != "" {
+		cred = withSecretRef(binding, cred)
+	}
+	var params map[string]interface{}
+	if binding.Parameters != "" {
+		err = json.Unmarshal([]byte(binding.Parameters), &params)
+		if err != nil {
+			glog.Errorf("decode parameters of binding failed, err is %+v", err)
+			return nil, internalError(err, "the stored parameters of the binding are invalid")
+		}
+	}
+	return &osb.GetBindingResponse{Credentials: cred, Parameters: params}, nil
+}
+
+// failStaleBindings fails the binding operations which outlived their timeout, the replica running
+// them stopped.
+func (b *BusinessLogic) failStaleBindings() {
+	bindings, err := b.db.SelectBindingsByState(LastStateProcessing)
+	if err != nil {
+		glog.Errorf("select processing bindings failed, err is %+v", err)
+		return
+	}
+
+	stale := time.Now().Add(-2 * bindingOperationTimeout)
+	for _, binding := range bindings {
+		updated, err := time.ParseInLocation(timeFormat, binding.UpdatedAt, time.Local)
+		if err != nil || updated.After(stale) {
+			continue
+		}
+		binding.State = LastStateFailed
+		binding.Description = fmt.Sprintf("the %s did not complete", binding.Operation)
+		_, err = b.db.UpdateBinding(binding)
+		if err != nil {
+			glog.Errorf("update binding failed, err is %+v", err)
+			continue
+		}
+		glog.Warningf("failed the stale %s of binding %s", binding.Operation, binding.BindingID)
+	}
+}
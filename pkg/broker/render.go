@@ -0,0 +1,51 @@
+package broker
+
+import (
+	"context"
+	"os"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// NewTemplateRenderer returns a BusinessLogic which only renders the catalogs and the apply
+// templates under dir, catalog/<service>_generated.json and apply/<service>.yaml, to check them
+// before they are embedded into pkg/asset. It has no store and no cluster.
+func NewTemplateRenderer(dir string) (*BusinessLogic, error) {
+	b := &BusinessLogic{}
+	b.InitServices()
+	err := b.InitServiceCatalog(os.DirFS(dir))
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Catalog returns the services of the catalog.
+func (b *BusinessLogic) Catalog() []osb.Service {
+	return b.catalogs
+}
+
+// RenderPlan renders the template of a service as a provision of the plan with the parameters
+// and the schema defaults would, the values of applySpecial are computed without a cluster. The
+// variables no step gave a value are returned with the manifests.
+func (b *BusinessLogic) RenderPlan(service *osb.Service, plan *osb.Plan, namespace, instanceName, instanceId string, params map[string]interface{}) (string, []string, error) {
+	ctx := context.Background()
+	rendered, _, err := b.renderTemplate(ctx, service.Name, namespace, instanceName, instanceId, params, plan)
+	if err != nil {
+		// the cause tells the template authors what is wrong
+		if statusErr, ok := osb.IsHTTPError(err); ok && statusErr.ResponseError != nil {
+			return "", nil, statusErr.ResponseError
+		}
+		return "", nil, err
+	}
+
+	rendered, manifests, err := b.applySpecial(ctx, nil, service.Name, rendered, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	unresolved, err := rendered.unresolved()
+	if err != nil {
+		return "", nil, err
+	}
+	return manifests, unresolved, nil
+}
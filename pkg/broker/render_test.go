@@ -0,0 +1,95 @@
+package broker
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arugaki/osb-starter-pack/pkg/asset"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden manifests under testdata/render")
+
+// TestRenderGolden renders every plan of the catalog with the fixture parameters of its service,
+// testdata/render/<service>/parameters.json, and compares the manifests with the golden
+// testdata/render/<service>/<plan>.yaml. go test ./pkg/broker -run TestRenderGolden -update
+// rewrites the goldens, the change of the manifests is then reviewed in their diff.
+func TestRenderGolden(t *testing.T) {
+	b := &BusinessLogic{}
+	b.InitServices()
+	err := b.InitServiceCatalog(asset.Templates())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, service := range b.catalogs {
+		service := service
+		dir := filepath.Join("testdata", "render", service.Name)
+
+		var params map[string]interface{}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "parameters.json"))
+		if err != nil {
+			t.Fatalf("read the fixture parameters of %s: %v", service.Name, err)
+		}
+		err = json.Unmarshal(data, &params)
+		if err != nil {
+			t.Fatalf("decode the fixture parameters of %s: %v", service.Name, err)
+		}
+
+		for _, plan := range service.Plans {
+			plan := plan
+			t.Run(service.Name+"/"+plan.Name, func(t *testing.T) {
+				rendered, fields, err := b.RenderPlan(&service, &plan, "golden", "golden", "golden-instance", params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(fields) != 0 {
+					t.Errorf("variables %v are not rendered", fields)
+				}
+
+				golden := filepath.Join(dir, plan.Name+".yaml")
+				if *update {
+					err = ioutil.WriteFile(golden, []byte(rendered), 0644)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				expect, err := ioutil.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("read the golden manifests, run with -update to create them: %v", err)
+				}
+				if diff := firstDifference(string(expect), rendered); diff != "" {
+					t.Errorf("the manifests differ from %s, run with -update and review the diff\n%s", golden, diff)
+				}
+			})
+		}
+	}
+}
+
+// firstDifference describes the first line which differs, empty when the texts are the same.
+func firstDifference(expect, actual string) string {
+	if expect == actual {
+		return ""
+	}
+
+	expectLines := strings.Split(expect, "\n")
+	actualLines := strings.Split(actual, "\n")
+	for i := 0; ; i++ {
+		var e, a string
+		if i < len(expectLines) {
+			e = expectLines[i]
+		}
+		if i < len(actualLines) {
+			a = actualLines[i]
+		}
+		if e != a || i >= len(expectLines) || i >= len(actualLines) {
+			return fmt.Sprintf("line %d\n- %s\n+ %s", i+1, e, a)
+		}
+	}
+}
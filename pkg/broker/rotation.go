@@ -0,0 +1,168 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// A rotation asks the service of a binding for new credentials and writes them to the store and to
+// the Secret of the binding. The previous credentials stay valid for the grace period, then the
+// background work revokes them. Platforms keep the credentials of the bind response, their
+// applications have to bind again before the grace period ends.
+
+const timeFormat = "2006-01-02 15:04:05"
+
+// decodeCredentials returns the stored json credentials of a binding, nil when there are none.
+func decodeCredentials(stored string) (map[string]interface{}, error) {
+	if stored == "" {
+		return nil, nil
+	}
+	var credentials map[string]interface{}
+	err := json.Unmarshal([]byte(stored), &credentials)
+	return credentials, err
+}
+
+// RotateBinding issues new credentials for the binding of the instance, the previous ones are revoked
+// after grace. A negative grace is the --rotation-grace-period of the broker.
+func (b *BusinessLogic) RotateBinding(ctx context.Context, instance *dao.Instance, bindingId string, grace time.Duration, identity *osb.OriginatingIdentity) (_ *dao.Binding, err error) {
+	if grace < 0 {
+		grace = b.rotationGrace
+	}
+	defer func() {
+		b.audit(&auditEntry{
+			operation:  AuditRotate,
+			instanceId: instance.InstanceID,
+			bindingId:  bindingId,
+			serviceId:  instance.ServiceID,
+			planId:     instance.PlanID,
+			identity:   identity,
+			parameters: map[string]interface{}{"grace_period": grace.String()},
+		}, err)
+	}()
+	db := b.db.WithContext(ctx)
+
+	s, ok := b.services[instance.ServiceName]
+	if !ok {
+		return nil, internalError(ServiceNotFound, "")
+	}
+
+	unlock, err := b.lockInstance(instance.InstanceID, AuditRotate)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	binding, err := db.SelectBinding(bindingId)
+	if err != nil {
+		glog.Errorf("select binding by binding id failed, err is %+v", err)
+		return nil, storeUnavailable(err)
+	}
+	if binding.BindingID == "" || binding.InstanceID != instance.InstanceID {
+		return nil, newError(http.StatusNotFound, "", fmt.Sprintf("binding %s of instance %s is not found", bindingId, instance.InstanceID), BindingNotFound)
+	}
+	if binding.State != LastStateSuccess {
+		return nil, unprocessable(BindingNotReady, "")
+	}
+	if binding.PreviousCredentials != "" {
+		return nil, unprocessable(RotationPending, "")
+	}
+	previous, err := decodeCredentials(binding.Credentials)
+	if err != nil {
+		glog.Errorf("decode credentials of binding %s failed, err is %+v", bindingId, err)
+		return nil, internalError(err, "the stored credentials of the binding are invalid")
+	}
+
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return nil, internalError(err, "the cluster of the instance is not configured")
+	}
+	kcl = kcl.WithContext(ctx)
+
+	current, err := s.RotateBinding(instance, binding, previous, kcl)
+	if err != nil {
+		glog.Errorf("rotate binding %s failed, err is %+v", bindingId, err)
+		return nil, internalError(err, "failed to issue new credentials for the binding")
+	}
+	credentials, err := json.Marshal(current)
+	if err != nil {
+		glog.Errorf("marshal credentials of binding %s failed, err is %+v", bindingId, err)
+		return nil, internalError(err, "the new credentials of the binding are invalid")
+	}
+
+	// the new credentials are revoked again when they can not be handed out, the previous ones stay
+	rollback := func() {
+		if binding.SecretName != "" {
+			_, err := b.writeBindingSecret(ctx, instance, binding, previous)
+			if err != nil {
+				glog.Errorf("write the previous credentials of binding %s back failed, err is %+v", bindingId, err)
+			}
+		}
+		err := s.RevokeBindingCredentials(instance, binding, previous, current, kcl)
+		if err != nil {
+			glog.Errorf("revoke the new credentials of binding %s failed, err is %+v", bindingId, err)
+		}
+	}
+	if binding.SecretName != "" {
+		_, err = b.writeBindingSecret(ctx, instance, binding, current)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+
+	binding.PreviousCredentials = binding.Credentials
+	binding.Credentials = string(credentials)
+	binding.RevokeAt = time.Now().Add(grace).Format(timeFormat)
+	_, err = db.UpdateBinding(binding)
+	if err != nil {
+		glog.Errorf("update binding failed, err is %+v", err)
+		rollback()
+		return nil, storeUnavailable(err)
+	}
+
+	if grace == 0 {
+		err = b.revokePreviousCredentials(ctx, instance, binding)
+		if err != nil {
+			glog.Warningf("revoke previous credentials of binding %s failed, the background work retries, err is %+v", bindingId, err)
+		}
+	}
+	return binding, nil
+}
+
+// revokePreviousCredentials revokes the credentials replaced by the rotation of the binding and
+// records it.
+func (b *BusinessLogic) revokePreviousCredentials(ctx context.Context, instance *dao.Instance, binding *dao.Binding) error {
+	s, ok := b.services[instance.ServiceName]
+	if !ok {
+		return ServiceNotFound
+	}
+	current, err := decodeCredentials(binding.Credentials)
+	if err != nil {
+		return err
+	}
+	previous, err := decodeCredentials(binding.PreviousCredentials)
+	if err != nil {
+		return err
+	}
+	kcl, err := b.getCluster(instance.Cluster)
+	if err != nil {
+		return err
+	}
+
+	err = s.RevokeBindingCredentials(instance, binding, current, previous, kcl.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	binding.PreviousCredentials = ""
+	binding.RevokeAt = ""
+	_, err = b.db.WithContext(ctx).UpdateBinding(binding)
+	return err
+}
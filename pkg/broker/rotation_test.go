@@ -0,0 +1,130 @@
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes/fake"
+	"github.com/arugaki/osb-starter-pack/pkg/service"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// rotatingService issues the passwords 1, 2, ... and records the ones revoked.
+type rotatingService struct {
+	*service.ZookeeperService
+	issued  int
+	revoked []interface{}
+}
+
+func (s *rotatingService) issue() map[string]interface{} {
+	s.issued++
+	return map[string]interface{}{"username": "app", "password": fmt.Sprint(s.issued)}
+}
+
+// AsyncBindings is false, the stub issues the credentials at once.
+func (s *rotatingService) AsyncBindings() bool {
+	return false
+}
+
+func (s *rotatingService) BindInstance(instance *dao.Instance, request *osb.BindRequest, cluster kubernetes.Cluster) (map[string]interface{}, error) {
+	return s.issue(), nil
+}
+
+func (s *rotatingService) RotateBinding(instance *dao.Instance, binding *dao.Binding, credentials map[string]interface{}, cluster kubernetes.Cluster) (map[string]interface{}, error) {
+	return s.issue(), nil
+}
+
+func (s *rotatingService) RevokeBindingCredentials(instance *dao.Instance, binding *dao.Binding, current, previous map[string]interface{}, cluster kubernetes.Cluster) error {
+	s.revoked = append(s.revoked, previous["password"])
+	return nil
+}
+
+func secretPassword(t *testing.T, cluster *fake.Cluster) string {
+	secret := cluster.Get("Secret", "app", bindingSecretName("rotated"))
+	if secret == nil {
+		t.Fatal("expect the secret of the binding")
+	}
+	encoded, _, _ := unstructured.NestedString(secret.Object, "data", "password")
+	password, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(password)
+}
+
+func TestRotateBinding(t *testing.T) {
+	b, cluster := newTestLogic(t)
+	b.bindingSecrets = true
+	s := &rotatingService{ZookeeperService: &service.ZookeeperService{}}
+	b.services["zookeeper"] = s
+	c := &broker.RequestContext{}
+
+	catalog := b.catalogs[0]
+	instance := provisionTestInstance(t, b, "rotated")
+
+	request := &osb.BindRequest{
+		InstanceID: "rotated",
+		BindingID:  "rotated",
+		ServiceID:  catalog.ID,
+		PlanID:     catalog.Plans[0].ID,
+		Context: map[string]interface{}{
+			contextPlatform:  osb.PlatformKubernetes,
+			contextNamespace: "app",
+		},
+	}
+	_, err := b.Bind(request, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password := secretPassword(t, cluster); password != "1" {
+		t.Fatalf("expect the secret to hold the issued password, got %q", password)
+	}
+
+	binding, err := b.RotateBinding(context.Background(), instance, "rotated", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password := secretPassword(t, cluster); password != "2" {
+		t.Fatalf("expect the secret to hold the rotated password, got %q", password)
+	}
+	response, err := b.Bind(request, c)
+	if err != nil || response.Credentials["password"] != "2" {
+		t.Fatalf("expect a retry of the bind to get the rotated credentials, got %v, %v", response, err)
+	}
+
+	_, err = b.RotateBinding(context.Background(), instance, "rotated", time.Hour, nil)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expect a rotation to wait for the revocation of the previous one, got %v", err)
+	}
+	_, err = b.RotateBinding(context.Background(), instance, "unknown", time.Hour, nil)
+	if statusErr, ok := osb.IsHTTPError(err); !ok || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expect an unknown binding to be not found, got %v", err)
+	}
+
+	b.revokeRotatedCredentials()
+	if len(s.revoked) != 0 {
+		t.Fatalf("expect the previous credentials valid during the grace period, revoked %v", s.revoked)
+	}
+	binding.RevokeAt = time.Now().Add(-time.Minute).Format(timeFormat)
+	b.db.UpdateBinding(binding)
+	b.revokeRotatedCredentials()
+	if len(s.revoked) != 1 || s.revoked[0] != "1" {
+		t.Fatalf("expect the previous password revoked after the grace period, revoked %v", s.revoked)
+	}
+
+	binding, err = b.RotateBinding(context.Background(), instance, "rotated", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binding.PreviousCredentials != "" || len(s.revoked) != 2 || s.revoked[1] != "2" {
+		t.Fatalf("expect a rotation without grace period to revoke the previous credentials, revoked %v", s.revoked)
+	}
+}
@@ -0,0 +1,21 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/arugaki/osb-starter-pack/pkg/trace"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// startOperation starts the root span of an OSB request, it joins the trace of the platform when
+// the request carries a traceparent header.
+func startOperation(c *broker.RequestContext, name, instanceId string) (context.Context, *trace.Span) {
+	ctx := context.Background()
+	if c != nil && c.Request != nil {
+		ctx = trace.Extract(c.Request.Context(), c.Request.Header)
+	}
+
+	ctx, span := trace.Start(ctx, name)
+	span.SetAttribute("osb.instance_id", instanceId)
+	return ctx, span
+}
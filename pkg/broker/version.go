@@ -0,0 +1,100 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// APIVersion is a version of the OSB api as sent in the X-Broker-API-Version header.
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// AtLeast reports whether v is the same as or newer than other.
+func (v APIVersion) AtLeast(other APIVersion) bool {
+	return v.Major > other.Major || (v.Major == other.Major && v.Minor >= other.Minor)
+}
+
+// ParseAPIVersion parses a header value like 2.14.
+func ParseAPIVersion(value string) (APIVersion, error) {
+	parts := strings.Split(strings.TrimSpace(value), ".")
+	if len(parts) != 2 {
+		return APIVersion{}, fmt.Errorf("malformed broker api version %q", value)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return APIVersion{}, fmt.Errorf("malformed broker api version %q", value)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return APIVersion{}, fmt.Errorf("malformed broker api version %q", value)
+	}
+	return APIVersion{Major: major, Minor: minor}, nil
+}
+
+// the range of OSB api versions the broker supports
+var (
+	MinAPIVersion = APIVersion{Major: 2, Minor: 11}
+	MaxAPIVersion = APIVersion{Major: 2, Minor: 17}
+)
+
+// versions introducing the behaviour the broker only shows to the platforms which understand it
+var (
+	versionAsyncBindings = APIVersion{Major: 2, Minor: 14}
+	versionGetEndpoints  = APIVersion{Major: 2, Minor: 14}
+)
+
+// ValidateBrokerAPIVersion rejects the versions outside of the supported range, osb-broker-lib
+// answers 412 Precondition Failed with the returned error.
+func (b *BusinessLogic) ValidateBrokerAPIVersion(version string) error {
+	v, err := ParseAPIVersion(version)
+	if err == nil && v.AtLeast(MinAPIVersion) && MaxAPIVersion.AtLeast(v) {
+		return nil
+	}
+
+	description := fmt.Sprintf("broker api version %q is not supported, supported versions are %s to %s",
+		version, MinAPIVersion, MaxAPIVersion)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusPreconditionFailed,
+		Description: &description,
+	}
+}
+
+// requestAPIVersion returns the version negotiated for the request, validated beforehand by
+// ValidateBrokerAPIVersion. The oldest supported version is assumed without a request.
+func requestAPIVersion(c *broker.RequestContext) APIVersion {
+	if c == nil || c.Request == nil {
+		return MinAPIVersion
+	}
+
+	v, err := ParseAPIVersion(c.Request.Header.Get(osb.APIVersionHeader))
+	if err != nil {
+		return MinAPIVersion
+	}
+	return v
+}
+
+// catalogForVersion hides the catalog fields introduced after the negotiated version.
+func catalogForVersion(services []osb.Service, version APIVersion) []osb.Service {
+	if version.AtLeast(versionGetEndpoints) {
+		return services
+	}
+
+	older := make([]osb.Service, len(services))
+	for i, service := range services {
+		service.BindingsRetrievable = false
+		older[i] = service
+	}
+	return older
+}
@@ -0,0 +1,17 @@
+package broker
+
+import "testing"
+
+func TestValidateBrokerAPIVersion(t *testing.T) {
+	b := &BusinessLogic{}
+	for _, version := range []string{"2.11", "2.14", "2.17"} {
+		if err := b.ValidateBrokerAPIVersion(version); err != nil {
+			t.Fatalf("expect %s supported, got %v", version, err)
+		}
+	}
+	for _, version := range []string{"", "2", "2.x", "2.10", "2.18", "3.0"} {
+		if err := b.ValidateBrokerAPIVersion(version); err == nil {
+			t.Fatalf("expect %q rejected", version)
+		}
+	}
+}
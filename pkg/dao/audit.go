@@ -0,0 +1,112 @@
+package dao
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// AuditLog is one entry of the append-only trail of broker operations.
+type AuditLog struct {
+	ID          int64  `json:"id"`
+	Timestamp   string `json:"timestamp"`
+	Identity    string `json:"identity"`
+	Platform    string `json:"platform"`
+	Operation   string `json:"operation"`
+	InstanceID  string `json:"instance_id"`
+	BindingID   string `json:"binding_id,omitempty"`
+	ServiceID   string `json:"service_id,omitempty"`
+	PlanID      string `json:"plan_id,omitempty"`
+	Parameters  string `json:"parameters,omitempty"`
+	Result      string `json:"result"`
+	Description string `json:"description,omitempty"`
+}
+
+// MarshalJSON keeps the stored parameters as a json object instead of a string.
+func (a *AuditLog) MarshalJSON() ([]byte, error) {
+	type auditLog AuditLog
+	var parameters json.RawMessage
+	if a.Parameters != "" {
+		parameters = json.RawMessage(a.Parameters)
+	}
+
+	return json.Marshal(&struct {
+		*auditLog
+		Parameters json.RawMessage `json:"parameters,omitempty"`
+	}{
+		auditLog:   (*auditLog)(a),
+		Parameters: parameters,
+	})
+}
+
+const (
+	_insertAuditSQL = `INSERT INTO audit_logs (
+			timestamp,
+			identity,
+			platform,
+			operation,
+			instance_id,
+			binding_id,
+			service_id,
+			plan_id,
+			parameters,
+			result,
+			description
+	) VALUES (?,?,?,?,?,?,?,?,?,?,?)`
+
+	_selectAuditSQL = `SELECT id, timestamp, identity, platform, operation, instance_id, binding_id, service_id, plan_id,
+			parameters, result, description FROM audit_logs WHERE timestamp >= ? AND (? = '' OR instance_id = ?) ORDER BY id`
+)
+
+func (d *Dao) InsertAuditLog(a *AuditLog) (int64, error) {
+	defer d.observe("insert_audit_log", time.Now())
+	if a.Timestamp == "" {
+		a.Timestamp = time.Now().Format("2006-01-02 15:04:05")
+	}
+
+	res, err := d.DB.Exec(_insertAuditSQL, a.Timestamp, a.Identity, a.Platform, a.Operation, a.InstanceID,
+		a.BindingID, a.ServiceID, a.PlanID, a.Parameters, a.Result, a.Description)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// SelectAuditLogs returns the audit logs since the given time, of one instance when instanceId is not empty.
+func (d *Dao) SelectAuditLogs(instanceId string, since time.Time) ([]*AuditLog, error) {
+	defer d.observe("select_audit_logs", time.Now())
+	res, err := d.DB.Query(_selectAuditSQL, since.Format("2006-01-02 15:04:05"), instanceId, instanceId)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var logs []*AuditLog
+	for res.Next() {
+		var a AuditLog
+		err := res.Scan(&a.ID, &a.Timestamp, &a.Identity, &a.Platform, &a.Operation, &a.InstanceID, &a.BindingID,
+			&a.ServiceID, &a.PlanID, &a.Parameters, &a.Result, &a.Description)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, &a)
+	}
+
+	err = res.Err()
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// WriteAuditLogs exports the audit logs as json lines.
+func WriteAuditLogs(w io.Writer, logs []*AuditLog) error {
+	encoder := json.NewEncoder(w)
+	for _, a := range logs {
+		err := encoder.Encode(a)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package dao
+
+import (
+	"time"
+)
+
+// Backup is a copy of the data of an instance in the backup target, and the state of its last
+// restore. StartedAt is when the backup or the last restore started.
+type Backup struct {
+	BackupID    string `json:"backup_id"`
+	InstanceID  string `json:"instance_id"`
+	ServiceName string `json:"service_name"`
+	// object key of the backup in the bucket of the target
+	Location     string `json:"location"`
+	State        string `json:"state"`
+	RestoreState string `json:"restore_state,omitempty"`
+	Description  string `json:"description,omitempty"`
+	StartedAt    string `json:"started_at"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+const (
+	_insertBackupSQL = `INSERT INTO backups (
+			backup_id,
+			instance_id,
+			service_name,
+			location,
+			state,
+			restore_state,
+			description,
+			started_at,
+			created_at,
+			updated_at
+	) VALUES (?,?,?,?,?,?,?,?,?,?)`
+
+	_updateBackupSQL = `UPDATE backups SET state = ?, restore_state = ?, description = ?, started_at = ?, updated_at = ?
+			WHERE backup_id = ?`
+	_selectBackupSQL = `SELECT backup_id, instance_id, service_name, location, state, restore_state, description,
+			started_at, created_at, updated_at FROM backups WHERE backup_id = ?`
+	_selectBackupsSQL = `SELECT backup_id, instance_id, service_name, location, state, restore_state, description,
+			started_at, created_at, updated_at FROM backups WHERE instance_id = ? ORDER BY created_at, backup_id`
+	_selectProcessingBackupsSQL = `SELECT backup_id, instance_id, service_name, location, state, restore_state, description,
+			started_at, created_at, updated_at FROM backups WHERE state = ? OR restore_state = ?`
+)
+
+func (d *Dao) InsertBackup(b *Backup) (int64, error) {
+	defer d.observe("insert_backup", time.Now())
+	now := time.Now().Format("2006-01-02 15:04:05")
+	res, err := d.DB.Exec(_insertBackupSQL, b.BackupID, b.InstanceID, b.ServiceName, b.Location, b.State,
+		b.RestoreState, b.Description, b.StartedAt, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// UpdateBackup records the state of the backup and of its restore.
+func (d *Dao) UpdateBackup(b *Backup) (int64, error) {
+	defer d.observe("update_backup", time.Now())
+	res, err := d.DB.Exec(_updateBackupSQL, b.State, b.RestoreState, b.Description, b.StartedAt,
+		time.Now().Format("2006-01-02 15:04:05"), b.BackupID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SelectBackup returns an empty backup when the backup does not exist.
+func (d *Dao) SelectBackup(backupId string) (*Backup, error) {
+	defer d.observe("select_backup", time.Now())
+	backups, err := d.selectBackups(_selectBackupSQL, backupId)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) == 0 {
+		return &Backup{}, nil
+	}
+	return backups[0], nil
+}
+
+// SelectBackups returns the backups of an instance, the oldest first.
+func (d *Dao) SelectBackups(instanceId string) ([]*Backup, error) {
+	defer d.observe("select_backups", time.Now())
+	return d.selectBackups(_selectBackupsSQL, instanceId)
+}
+
+// SelectProcessingBackups returns the backups being taken or restored.
+func (d *Dao) SelectProcessingBackups(state string) ([]*Backup, error) {
+	defer d.observe("select_processing_backups", time.Now())
+	return d.selectBackups(_selectProcessingBackupsSQL, state, state)
+}
+
+func (d *Dao) selectBackups(query string, args ...interface{}) ([]*Backup, error) {
+	res, err := d.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var backups []*Backup
+	for res.Next() {
+		var b Backup
+		err := res.Scan(&b.BackupID, &b.InstanceID, &b.ServiceName, &b.Location, &b.State, &b.RestoreState,
+			&b.Description, &b.StartedAt, &b.CreatedAt, &b.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, &b)
+	}
+
+	err = res.Err()
+	if err != nil {
+		return nil, err
+	}
+	return backups, nil
+}
@@ -0,0 +1,203 @@
+package dao
+
+import (
+	"time"
+)
+
+// Binding is a binding of an instance and the Secret its credentials are written to, if any. State
+// is the state of its last operation, Operation the bind or unbind it was, Description why it failed.
+type Binding struct {
+	BindingID       string `json:"binding_id"`
+	InstanceID      string `json:"instance_id"`
+	ServiceID       string `json:"service_id"`
+	PlanID          string `json:"plan_id"`
+	Parameters      string `json:"parameters"`
+	SecretNamespace string `json:"secret_namespace,omitempty"`
+	SecretName      string `json:"secret_name,omitempty"`
+	// Credentials are the json credentials the service issued, PreviousCredentials the ones replaced
+	// by a rotation which stay valid until RevokeAt.
+	Credentials         string `json:"-"`
+	PreviousCredentials string `json:"-"`
+	RevokeAt            string `json:"revoke_at,omitempty"`
+	State               string `json:"state"`
+	Operation           string `json:"operation,omitempty"`
+	Description         string `json:"description,omitempty"`
+	CreatedAt           string `json:"created_at"`
+	UpdatedAt           string `json:"updated_at"`
+}
+
+const (
+	_insertBindingSQL = `INSERT INTO bindings (
+			binding_id,
+			instance_id,
+			service_id,
+			plan_id,
+			parameters,
+			secret_namespace,
+			secret_name,
+			credentials,
+			previous_credentials,
+			revoke_at,
+			state,
+			operation,
+			description,
+			created_at,
+			updated_at
+	) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+
+	_updateBindingSQL = `UPDATE bindings SET credentials = ?, previous_credentials = ?, revoke_at = ?, state = ?,
+			operation = ?, description = ?, updated_at = ? WHERE binding_id = ?`
+	_deleteBindingSQL = `DELETE FROM bindings WHERE binding_id = ?`
+	_selectBindingSQL = `SELECT binding_id, instance_id, service_id, plan_id, parameters, secret_namespace, secret_name,
+			credentials, previous_credentials, revoke_at, state, operation, description, created_at, updated_at FROM bindings
+			WHERE binding_id = ?`
+	_selectBindingsSQL = `SELECT binding_id, instance_id, service_id, plan_id, parameters, secret_namespace, secret_name,
+			credentials, previous_credentials, revoke_at, state, operation, description, created_at, updated_at FROM bindings
+			WHERE instance_id = ? ORDER BY created_at, binding_id`
+	_selectBindingsToRevokeSQL = `SELECT binding_id, instance_id, service_id, plan_id, parameters, secret_namespace,
+			secret_name, credentials, previous_credentials, revoke_at, state, operation, description, created_at, updated_at
+			FROM bindings WHERE revoke_at != '' AND revoke_at <= ? ORDER BY revoke_at, binding_id`
+	_selectBindingsByStateSQL = `SELECT binding_id, instance_id, service_id, plan_id, parameters, secret_namespace,
+			secret_name, credentials, previous_credentials, revoke_at, state, operation, description, created_at, updated_at
+			FROM bindings WHERE state = ? ORDER BY updated_at, binding_id`
+	_countBindingsSQL = `SELECT i.service_name, b.plan_id, b.state, COUNT(*) FROM bindings b
+			JOIN instances i ON i.instance_id = b.instance_id GROUP BY i.service_name, b.plan_id, b.state`
+)
+
+// BindingCount is the number of bindings of a plan in a state.
+type BindingCount struct {
+	ServiceName string
+	PlanID      string
+	State       string
+	Count       int64
+}
+
+func (d *Dao) InsertBinding(b *Binding) (int64, error) {
+	defer d.observe("insert_binding", time.Now())
+	now := time.Now().Format("2006-01-02 15:04:05")
+	credentials, previous, err := d.encryptCredentials(b)
+	if err != nil {
+		return 0, err
+	}
+	res, err := d.DB.Exec(_insertBindingSQL, b.BindingID, b.InstanceID, b.ServiceID, b.PlanID, b.Parameters,
+		b.SecretNamespace, b.SecretName, credentials, previous, b.RevokeAt, b.State, b.Operation, b.Description,
+		now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// UpdateBinding updates the credentials of a binding, the revocation of its previous ones and the
+// state of its last operation.
+func (d *Dao) UpdateBinding(b *Binding) (int64, error) {
+	defer d.observe("update_binding", time.Now())
+	now := time.Now().Format("2006-01-02 15:04:05")
+	credentials, previous, err := d.encryptCredentials(b)
+	if err != nil {
+		return 0, err
+	}
+	res, err := d.DB.Exec(_updateBindingSQL, credentials, previous, b.RevokeAt, b.State, b.Operation,
+		b.Description, now, b.BindingID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (d *Dao) DeleteBinding(bindingId string) (int64, error) {
+	defer d.observe("delete_binding", time.Now())
+	res, err := d.DB.Exec(_deleteBindingSQL, bindingId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SelectBinding returns an empty binding when the binding does not exist.
+func (d *Dao) SelectBinding(bindingId string) (*Binding, error) {
+	defer d.observe("select_binding", time.Now())
+	bindings, err := d.selectBindings(_selectBindingSQL, bindingId)
+	if err != nil {
+		return nil, err
+	}
+	if len(bindings) == 0 {
+		return &Binding{}, nil
+	}
+	return bindings[0], nil
+}
+
+// SelectBindings returns the bindings of an instance, the oldest first.
+func (d *Dao) SelectBindings(instanceId string) ([]*Binding, error) {
+	defer d.observe("select_bindings", time.Now())
+	return d.selectBindings(_selectBindingsSQL, instanceId)
+}
+
+// SelectBindingsToRevoke returns the bindings whose previous credentials are due to be revoked at now.
+func (d *Dao) SelectBindingsToRevoke(now time.Time) ([]*Binding, error) {
+	defer d.observe("select_bindings_to_revoke", time.Now())
+	return d.selectBindings(_selectBindingsToRevokeSQL, now.Format("2006-01-02 15:04:05"))
+}
+
+// SelectBindingsByState returns the bindings whose last operation is in the state, the least recently
+// updated first.
+func (d *Dao) SelectBindingsByState(state string) ([]*Binding, error) {
+	defer d.observe("select_bindings_by_state", time.Now())
+	return d.selectBindings(_selectBindingsByStateSQL, state)
+}
+
+// CountBindings returns the number of bindings grouped by the service of their instance, plan and state.
+func (d *Dao) CountBindings() ([]*BindingCount, error) {
+	defer d.observe("count_bindings", time.Now())
+	res, err := d.DB.Query(_countBindingsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var counts []*BindingCount
+	for res.Next() {
+		var count BindingCount
+		err := res.Scan(&count.ServiceName, &count.PlanID, &count.State, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, &count)
+	}
+
+	err = res.Err()
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (d *Dao) selectBindings(query string, args ...interface{}) ([]*Binding, error) {
+	res, err := d.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var bindings []*Binding
+	for res.Next() {
+		var b Binding
+		err := res.Scan(&b.BindingID, &b.InstanceID, &b.ServiceID, &b.PlanID, &b.Parameters, &b.SecretNamespace,
+			&b.SecretName, &b.Credentials, &b.PreviousCredentials, &b.RevokeAt, &b.State, &b.Operation, &b.Description,
+			&b.CreatedAt, &b.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		err = d.decryptCredentials(&b)
+		if err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, &b)
+	}
+
+	err = res.Err()
+	if err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
@@ -1,14 +1,22 @@
 package dao
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"github.com/arugaki/osb-starter-pack/pkg/trace"
 	_ "github.com/go-sql-driver/mysql"
 	"time"
 )
 
 type Dao struct {
 	DB *sql.DB
+	// Observer is called with the duration of every query when set
+	Observer func(query string, start time.Time)
+	// Keyring encrypts the sensitive columns when set
+	Keyring *Keyring
+	// spans of the queries are children of the span in ctx
+	ctx context.Context
 }
 
 func New(c *Config) (*Dao, error) {
@@ -24,9 +32,26 @@ func New(c *Config) (*Dao, error) {
 	return d, nil
 }
 
+// WithContext returns a shallow copy of d tracing its queries under ctx.
+func (d *Dao) WithContext(ctx context.Context) Store {
+	d2 := *d
+	d2.ctx = ctx
+	return &d2
+}
+
+func (d *Dao) observe(query string, start time.Time) {
+	if d.Observer != nil {
+		d.Observer(query, start)
+	}
+	if d.ctx != nil {
+		_, span := trace.StartAt(d.ctx, "db."+query, start)
+		span.Finish(nil)
+	}
+}
+
 // Ping check mysql health.
 func (d *Dao) Ping() (err error) {
-	return  d.DB.Ping()
+	return d.DB.Ping()
 }
 
 // Close release all mysql resource .
@@ -35,14 +60,14 @@ func (d *Dao) Close() {
 }
 
 type Config struct {
-	Addr		string
-	Port		string
-	UserName	string
-	Password	string
-	DB			string
+	Addr     string
+	Port     string
+	UserName string
+	Password string
+	DB       string
 
-	Active      int
-	Idle        int
+	Active int
+	Idle   int
 }
 
 const DSN = "%s:%s@tcp(%s:%s)/%s?charset=utf8&parseTime=True&loc=Local"
@@ -59,4 +84,4 @@ func NewMySQL(c *Config) *sql.DB {
 	db.SetMaxIdleConns(c.Idle)
 	db.SetConnMaxLifetime(time.Hour)
 	return db
-}
\ No newline at end of file
+}
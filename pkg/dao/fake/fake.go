@@ -0,0 +1,648 @@
+// Package fake is an in-memory dao.Store for the tests of the broker.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+type lock struct {
+	operation string
+	holder    string
+	expiresAt time.Time
+}
+
+// Store keeps the instances, the instance locks, the audit logs, the backups and the bindings as the
+// MySQL tables do.
+type Store struct {
+	mu sync.Mutex
+
+	instances map[string]*dao.Instance
+	locks     map[string]*lock
+	auditLogs []*dao.AuditLog
+	backups   map[string]*dao.Backup
+	bindings  map[string]*dao.Binding
+	// errors returned by the methods by name
+	errors map[string]error
+}
+
+var _ dao.Store = &Store{}
+
+func NewStore() *Store {
+	return &Store{
+		instances: make(map[string]*dao.Instance),
+		locks:     make(map[string]*lock),
+		backups:   make(map[string]*dao.Backup),
+		bindings:  make(map[string]*dao.Binding),
+		errors:    make(map[string]error),
+	}
+}
+
+// SetError makes the method of the given name return err until it is set to nil.
+func (s *Store) SetError(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		delete(s.errors, method)
+		return
+	}
+	s.errors[method] = err
+}
+
+// AuditLogs returns copies of the audit logs in the order they were inserted.
+func (s *Store) AuditLogs() []*dao.AuditLog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logs := make([]*dao.AuditLog, 0, len(s.auditLogs))
+	for _, a := range s.auditLogs {
+		copied := *a
+		logs = append(logs, &copied)
+	}
+	return logs
+}
+
+func (s *Store) WithContext(ctx context.Context) dao.Store {
+	return s
+}
+
+func (s *Store) InsertInstance(i *dao.Instance) (int64, error) {
+	now := time.Now().Format(timeFormat)
+	return s.insert("InsertInstance", i, now, now)
+}
+
+func (s *Store) ImportInstance(i *dao.Instance) (int64, error) {
+	return s.insert("ImportInstance", i, i.CreatedAt, i.UpdatedAt)
+}
+
+func (s *Store) insert(method string, i *dao.Instance, createdAt, updatedAt string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors[method]; err != nil {
+		return 0, err
+	}
+	if _, ok := s.instances[i.InstanceID]; ok {
+		return 0, fmt.Errorf("duplicate entry %q for key PRIMARY", i.InstanceID)
+	}
+
+	stored := *i
+	stored.CreatedAt = createdAt
+	stored.UpdatedAt = updatedAt
+	s.instances[i.InstanceID] = &stored
+	return 1, nil
+}
+
+func (s *Store) UpdateInstance(i *dao.Instance) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["UpdateInstance"]; err != nil {
+		return 0, err
+	}
+	stored, ok := s.instances[i.InstanceID]
+	if !ok {
+		return 0, nil
+	}
+	stored.PlanID = i.PlanID
+	stored.Context = i.Context
+	stored.State = i.State
+	stored.Parameters = i.Parameters
+	stored.Yaml = i.Yaml
+	stored.UpdatedAt = time.Now().Format(timeFormat)
+	return 1, nil
+}
+
+func (s *Store) UpdateInstanceState(instanceId, state string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["UpdateInstanceState"]; err != nil {
+		return 0, err
+	}
+	stored, ok := s.instances[instanceId]
+	if !ok {
+		return 0, nil
+	}
+	stored.State = state
+	return 1, nil
+}
+
+func (s *Store) DeleteInstance(instanceId string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["DeleteInstance"]; err != nil {
+		return 0, err
+	}
+	if _, ok := s.instances[instanceId]; !ok {
+		return 0, nil
+	}
+	delete(s.instances, instanceId)
+	return 1, nil
+}
+
+func (s *Store) SelectInstance(instanceId string) (*dao.Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["SelectInstance"]; err != nil {
+		return nil, err
+	}
+	instance := &dao.Instance{}
+	if stored, ok := s.instances[instanceId]; ok {
+		*instance = *stored
+	}
+	return instance, nil
+}
+
+func (s *Store) SelectInstancesByState(state string) ([]*dao.Instance, error) {
+	return s.ListInstances(&dao.InstanceFilter{State: state})
+}
+
+func (s *Store) CountInstances() ([]*dao.InstanceCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["CountInstances"]; err != nil {
+		return nil, err
+	}
+	counts := make(map[dao.InstanceCount]int64)
+	for _, i := range s.instances {
+		counts[dao.InstanceCount{ServiceName: i.ServiceName, PlanID: i.PlanID, State: i.State}]++
+	}
+
+	var result []*dao.InstanceCount
+	for group, count := range counts {
+		group.Count = count
+		copied := group
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+func (s *Store) ListInstances(f *dao.InstanceFilter) ([]*dao.Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["ListInstances"]; err != nil {
+		return nil, err
+	}
+	instances, err := s.matching(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Limit > 0 {
+		if f.Offset >= len(instances) {
+			return nil, nil
+		}
+		end := f.Offset + f.Limit
+		if end > len(instances) {
+			end = len(instances)
+		}
+		instances = instances[f.Offset:end]
+	}
+	return instances, nil
+}
+
+func (s *Store) CountMatchingInstances(f *dao.InstanceFilter) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["CountMatchingInstances"]; err != nil {
+		return 0, err
+	}
+	instances, err := s.matching(f)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(instances)), nil
+}
+
+// matching returns copies of the instances matching the filter in its order, the instance id
+// breaks the ties as in MySQL.
+func (s *Store) matching(f *dao.InstanceFilter) ([]*dao.Instance, error) {
+	column := "instance_id"
+	if f.SortBy != "" {
+		column = f.SortBy
+	}
+	if sortValue(&dao.Instance{}, column) == nil {
+		return nil, fmt.Errorf("instances can not be sorted by %q", f.SortBy)
+	}
+
+	var instances []*dao.Instance
+	for _, i := range s.instances {
+		if matches(f, i) {
+			copied := *i
+			instances = append(instances, &copied)
+		}
+	}
+
+	sort.Slice(instances, func(a, b int) bool {
+		x, y := *sortValue(instances[a], column), *sortValue(instances[b], column)
+		if x == y {
+			x, y = instances[a].InstanceID, instances[b].InstanceID
+		}
+		if f.Descending {
+			return x > y
+		}
+		return x < y
+	})
+	return instances, nil
+}
+
+func matches(f *dao.InstanceFilter, i *dao.Instance) bool {
+	for _, c := range []struct {
+		filter string
+		value  string
+	}{
+		{f.ServiceName, i.ServiceName},
+		{f.PlanID, i.PlanID},
+		{f.Namespace, i.Namespace},
+		{f.Cluster, i.Cluster},
+		{f.State, i.State},
+		{f.OrganizationGUID, i.OrganizationGUID},
+		{f.SpaceGUID, i.SpaceGUID},
+	} {
+		if c.filter != "" && c.filter != c.value {
+			return false
+		}
+	}
+	return f.Search == "" || strings.Contains(i.InstanceID, f.Search) || strings.Contains(i.InstanceName, f.Search)
+}
+
+// sortValue returns the field of one of dao.InstanceSortColumns, nil for any other column.
+func sortValue(i *dao.Instance, column string) *string {
+	switch column {
+	case "instance_id":
+		return &i.InstanceID
+	case "instance_name":
+		return &i.InstanceName
+	case "service_name":
+		return &i.ServiceName
+	case "plan_id":
+		return &i.PlanID
+	case "namespace":
+		return &i.Namespace
+	case "cluster":
+		return &i.Cluster
+	case "state":
+		return &i.State
+	case "created_at":
+		return &i.CreatedAt
+	case "updated_at":
+		return &i.UpdatedAt
+	}
+	return nil
+}
+
+func (s *Store) AcquireInstanceLock(instanceId, operation, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["AcquireInstanceLock"]; err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if l, ok := s.locks[instanceId]; ok && !l.expiresAt.Before(now) {
+		return false, nil
+	}
+	s.locks[instanceId] = &lock{
+		operation: operation,
+		holder:    holder,
+		expiresAt: now.Add(ttl),
+	}
+	return true, nil
+}
+
+func (s *Store) ReleaseInstanceLock(instanceId, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["ReleaseInstanceLock"]; err != nil {
+		return err
+	}
+	if l, ok := s.locks[instanceId]; ok && l.holder == holder {
+		delete(s.locks, instanceId)
+	}
+	return nil
+}
+
+func (s *Store) DeleteExpiredInstanceLocks() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["DeleteExpiredInstanceLocks"]; err != nil {
+		return 0, err
+	}
+	var count int64
+	now := time.Now()
+	for instanceId, l := range s.locks {
+		if l.expiresAt.Before(now) {
+			delete(s.locks, instanceId)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *Store) DeleteInstanceLock(instanceId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["DeleteInstanceLock"]; err != nil {
+		return err
+	}
+	delete(s.locks, instanceId)
+	return nil
+}
+
+func (s *Store) InsertAuditLog(a *dao.AuditLog) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["InsertAuditLog"]; err != nil {
+		return 0, err
+	}
+	if a.Timestamp == "" {
+		a.Timestamp = time.Now().Format(timeFormat)
+	}
+	stored := *a
+	stored.ID = int64(len(s.auditLogs) + 1)
+	s.auditLogs = append(s.auditLogs, &stored)
+	return stored.ID, nil
+}
+
+func (s *Store) SelectAuditLogs(instanceId string, since time.Time) ([]*dao.AuditLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["SelectAuditLogs"]; err != nil {
+		return nil, err
+	}
+	from := since.Format(timeFormat)
+	var logs []*dao.AuditLog
+	for _, a := range s.auditLogs {
+		if a.Timestamp >= from && (instanceId == "" || a.InstanceID == instanceId) {
+			copied := *a
+			logs = append(logs, &copied)
+		}
+	}
+	return logs, nil
+}
+
+func (s *Store) InsertBackup(b *dao.Backup) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["InsertBackup"]; err != nil {
+		return 0, err
+	}
+	if _, ok := s.backups[b.BackupID]; ok {
+		return 0, fmt.Errorf("duplicate entry %q for key PRIMARY", b.BackupID)
+	}
+
+	stored := *b
+	stored.CreatedAt = time.Now().Format(timeFormat)
+	stored.UpdatedAt = stored.CreatedAt
+	s.backups[b.BackupID] = &stored
+	return 1, nil
+}
+
+func (s *Store) UpdateBackup(b *dao.Backup) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["UpdateBackup"]; err != nil {
+		return 0, err
+	}
+	stored, ok := s.backups[b.BackupID]
+	if !ok {
+		return 0, nil
+	}
+	stored.State = b.State
+	stored.RestoreState = b.RestoreState
+	stored.Description = b.Description
+	stored.StartedAt = b.StartedAt
+	stored.UpdatedAt = time.Now().Format(timeFormat)
+	return 1, nil
+}
+
+func (s *Store) SelectBackup(backupId string) (*dao.Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["SelectBackup"]; err != nil {
+		return nil, err
+	}
+	backup := &dao.Backup{}
+	if stored, ok := s.backups[backupId]; ok {
+		*backup = *stored
+	}
+	return backup, nil
+}
+
+func (s *Store) SelectBackups(instanceId string) ([]*dao.Backup, error) {
+	return s.selectBackups("SelectBackups", func(b *dao.Backup) bool {
+		return b.InstanceID == instanceId
+	})
+}
+
+func (s *Store) SelectProcessingBackups(state string) ([]*dao.Backup, error) {
+	return s.selectBackups("SelectProcessingBackups", func(b *dao.Backup) bool {
+		return b.State == state || b.RestoreState == state
+	})
+}
+
+// selectBackups returns copies of the backups accepted by filter, the oldest first.
+func (s *Store) selectBackups(method string, filter func(b *dao.Backup) bool) ([]*dao.Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors[method]; err != nil {
+		return nil, err
+	}
+	var backups []*dao.Backup
+	for _, b := range s.backups {
+		if filter(b) {
+			copied := *b
+			backups = append(backups, &copied)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].CreatedAt != backups[j].CreatedAt {
+			return backups[i].CreatedAt < backups[j].CreatedAt
+		}
+		return backups[i].BackupID < backups[j].BackupID
+	})
+	return backups, nil
+}
+
+func (s *Store) InsertBinding(b *dao.Binding) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["InsertBinding"]; err != nil {
+		return 0, err
+	}
+	if _, ok := s.bindings[b.BindingID]; ok {
+		return 0, fmt.Errorf("duplicate entry %q for key PRIMARY", b.BindingID)
+	}
+
+	stored := *b
+	stored.CreatedAt = time.Now().Format(timeFormat)
+	stored.UpdatedAt = stored.CreatedAt
+	s.bindings[b.BindingID] = &stored
+	return 1, nil
+}
+
+func (s *Store) UpdateBinding(b *dao.Binding) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["UpdateBinding"]; err != nil {
+		return 0, err
+	}
+	stored, ok := s.bindings[b.BindingID]
+	if !ok {
+		return 0, nil
+	}
+	stored.Credentials = b.Credentials
+	stored.PreviousCredentials = b.PreviousCredentials
+	stored.RevokeAt = b.RevokeAt
+	stored.State = b.State
+	stored.Operation = b.Operation
+	stored.Description = b.Description
+	stored.UpdatedAt = time.Now().Format(timeFormat)
+	return 1, nil
+}
+
+func (s *Store) DeleteBinding(bindingId string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["DeleteBinding"]; err != nil {
+		return 0, err
+	}
+	if _, ok := s.bindings[bindingId]; !ok {
+		return 0, nil
+	}
+	delete(s.bindings, bindingId)
+	return 1, nil
+}
+
+func (s *Store) SelectBinding(bindingId string) (*dao.Binding, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["SelectBinding"]; err != nil {
+		return nil, err
+	}
+	binding := &dao.Binding{}
+	if stored, ok := s.bindings[bindingId]; ok {
+		*binding = *stored
+	}
+	return binding, nil
+}
+
+func (s *Store) SelectBindings(instanceId string) ([]*dao.Binding, error) {
+	bindings, err := s.selectBindings("SelectBindings", func(b *dao.Binding) bool {
+		return b.InstanceID == instanceId
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		if bindings[i].CreatedAt != bindings[j].CreatedAt {
+			return bindings[i].CreatedAt < bindings[j].CreatedAt
+		}
+		return bindings[i].BindingID < bindings[j].BindingID
+	})
+	return bindings, nil
+}
+
+func (s *Store) SelectBindingsToRevoke(now time.Time) ([]*dao.Binding, error) {
+	due := now.Format(timeFormat)
+	bindings, err := s.selectBindings("SelectBindingsToRevoke", func(b *dao.Binding) bool {
+		return b.RevokeAt != "" && b.RevokeAt <= due
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		if bindings[i].RevokeAt != bindings[j].RevokeAt {
+			return bindings[i].RevokeAt < bindings[j].RevokeAt
+		}
+		return bindings[i].BindingID < bindings[j].BindingID
+	})
+	return bindings, nil
+}
+
+func (s *Store) SelectBindingsByState(state string) ([]*dao.Binding, error) {
+	bindings, err := s.selectBindings("SelectBindingsByState", func(b *dao.Binding) bool {
+		return b.State == state
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		if bindings[i].UpdatedAt != bindings[j].UpdatedAt {
+			return bindings[i].UpdatedAt < bindings[j].UpdatedAt
+		}
+		return bindings[i].BindingID < bindings[j].BindingID
+	})
+	return bindings, nil
+}
+
+// selectBindings returns copies of the bindings accepted by filter.
+func (s *Store) selectBindings(method string, filter func(b *dao.Binding) bool) ([]*dao.Binding, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors[method]; err != nil {
+		return nil, err
+	}
+	var bindings []*dao.Binding
+	for _, b := range s.bindings {
+		if filter(b) {
+			copied := *b
+			bindings = append(bindings, &copied)
+		}
+	}
+	return bindings, nil
+}
+
+func (s *Store) CountBindings() ([]*dao.BindingCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.errors["CountBindings"]; err != nil {
+		return nil, err
+	}
+	counts := make(map[dao.BindingCount]int64)
+	for _, b := range s.bindings {
+		instance, ok := s.instances[b.InstanceID]
+		if !ok {
+			continue
+		}
+		counts[dao.BindingCount{ServiceName: instance.ServiceName, PlanID: b.PlanID, State: b.State}]++
+	}
+
+	var result []*dao.BindingCount
+	for group, count := range counts {
+		group.Count = count
+		copied := group
+		result = append(result, &copied)
+	}
+	return result, nil
+}
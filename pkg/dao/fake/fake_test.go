@@ -0,0 +1,104 @@
+package fake
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+)
+
+func TestListInstances(t *testing.T) {
+	s := NewStore()
+	for _, i := range []*dao.Instance{
+		{InstanceID: "a", InstanceName: "zk-b", ServiceName: "zookeeper", State: "succeed"},
+		{InstanceID: "b", InstanceName: "zk-a", ServiceName: "zookeeper", State: "failed"},
+		{InstanceID: "c", InstanceName: "zk-a", ServiceName: "zookeeper", State: "succeed"},
+		{InstanceID: "d", InstanceName: "kafka", ServiceName: "kafka", State: "succeed"},
+	} {
+		_, err := s.InsertInstance(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := &dao.InstanceFilter{Search: "zk", SortBy: "instance_name", Descending: true, Limit: 2, Offset: 1}
+	instances, err := s.ListInstances(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, i := range instances {
+		ids = append(ids, i.InstanceID)
+	}
+	if len(ids) != 2 || ids[0] != "c" || ids[1] != "b" {
+		t.Fatalf("expect the page [c b], got %v", ids)
+	}
+
+	count, err := s.CountMatchingInstances(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expect 3 instances matching whatever the page, got %d", count)
+	}
+
+	_, err = s.ListInstances(&dao.InstanceFilter{SortBy: "yaml"})
+	if err == nil {
+		t.Fatal("expect an error for a column which is not sortable")
+	}
+}
+
+func TestInstanceLock(t *testing.T) {
+	s := NewStore()
+
+	acquired, _ := s.AcquireInstanceLock("a", "provision", "one", time.Minute)
+	if !acquired {
+		t.Fatal("expect the free lock acquired")
+	}
+	acquired, _ = s.AcquireInstanceLock("a", "update", "two", time.Minute)
+	if acquired {
+		t.Fatal("expect the held lock refused")
+	}
+
+	s.ReleaseInstanceLock("a", "two")
+	acquired, _ = s.AcquireInstanceLock("a", "update", "two", time.Minute)
+	if acquired {
+		t.Fatal("expect the lock kept when another holder releases it")
+	}
+
+	s.ReleaseInstanceLock("a", "one")
+	acquired, _ = s.AcquireInstanceLock("a", "update", "two", time.Minute)
+	if !acquired {
+		t.Fatal("expect the released lock acquired")
+	}
+}
+
+func TestCountBindings(t *testing.T) {
+	s := NewStore()
+	s.InsertInstance(&dao.Instance{InstanceID: "zk", ServiceName: "zookeeper", PlanID: "small"})
+	for _, b := range []*dao.Binding{
+		{BindingID: "a", InstanceID: "zk", PlanID: "small", State: "succeed"},
+		{BindingID: "b", InstanceID: "zk", PlanID: "small", State: "succeed"},
+		{BindingID: "c", InstanceID: "zk", PlanID: "small", State: "failed"},
+	} {
+		_, err := s.InsertBinding(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts, err := s.CountBindings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byState := make(map[string]int64)
+	for _, count := range counts {
+		if count.ServiceName != "zookeeper" || count.PlanID != "small" {
+			t.Fatalf("expect the bindings counted by the service of their instance, got %+v", count)
+		}
+		byState[count.State] = count.Count
+	}
+	if len(byState) != 2 || byState["succeed"] != 2 || byState["failed"] != 1 {
+		t.Fatalf("expect 2 succeeded and 1 failed binding, got %v", byState)
+	}
+}
@@ -2,6 +2,10 @@ package dao
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
 	"time"
 )
 
@@ -12,6 +16,9 @@ type Instance struct {
 	ServiceName      string `json:"service_name"`
 	PlanID           string `json:"plan_id"`
 	Namespace        string `json:"namespace"`
+	Cluster          string `json:"cluster"`
+	Context          string `json:"context"`
+	State            string `json:"state"`
 	OrganizationGUID string `json:"organization_guid"`
 	SpaceGUID        string `json:"space_guid"`
 	Parameters       string `json:"parameters"`
@@ -24,27 +31,51 @@ const (
 	_insertSQL = `INSERT INTO instances (
 			instance_id, 
 			service_id, 
+			instance_name,
 			service_name, 
 			plan_id, 
 			namespace,
+			cluster,
+			context,
+			state,
 			organization_guid,
 			space_guid,
 			parameters,
 			yaml,
 			created_at,
 			updated_at
-	) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)`
+	) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
 
-	_updateSQL = `UPDATE instances SET plan_id = ?, parameters = ?, yaml = ?, updated_at = ? WHERE instance_id = ?`
-	_deleteSQL = `DELETE FROM instances WHERE instance_id = ?`
-	_selectSQL = `SELECT * FROM instances WHERE instance_id = ?`
+	_updateSQL      = `UPDATE instances SET plan_id = ?, context = ?, state = ?, parameters = ?, yaml = ?, updated_at = ? WHERE instance_id = ?`
+	_updateStateSQL = `UPDATE instances SET state = ? WHERE instance_id = ?`
+	_deleteSQL      = `DELETE FROM instances WHERE instance_id = ?`
+	_selectSQL      = `SELECT instance_id, service_id, instance_name, service_name, plan_id, namespace, cluster, context, state,
+			organization_guid, space_guid, parameters, yaml, created_at, updated_at FROM instances WHERE instance_id = ?`
+	_selectByStateSQL = `SELECT instance_id, service_id, instance_name, service_name, plan_id, namespace, cluster, context, state,
+			organization_guid, space_guid, parameters, yaml, created_at, updated_at FROM instances WHERE state = ?`
+	_countSQL = `SELECT service_name, plan_id, state, COUNT(*) FROM instances GROUP BY service_name, plan_id, state`
 )
 
+// InstanceCount is the number of instances of a plan in a state.
+type InstanceCount struct {
+	ServiceName string
+	PlanID      string
+	State       string
+	Count       int64
+}
+
 func (d *Dao) InsertInstance(i *Instance) (int64, error) {
+	defer d.observe("insert_instance", time.Now())
+	// the same time for both, an instance never updated is told by created_at = updated_at
+	now := time.Now().Format("2006-01-02 15:04:05")
+	parameters, yaml, err := d.encryptInstance(i)
+	if err != nil {
+		return 0, err
+	}
 	var res sql.Result
-	res, err := d.DB.Exec(_insertSQL, i.InstanceID, i.ServiceID, i.InstanceName,
-		i.ServiceName, i.PlanID, i.Namespace, i.OrganizationGUID, i.SpaceGUID, i.Parameters, i.Yaml,
-		time.Now().Format("2006-01-02 15:04:05"), time.Now().Format("2006-01-02 15:04:05"))
+	res, err = d.DB.Exec(_insertSQL, i.InstanceID, i.ServiceID, i.InstanceName,
+		i.ServiceName, i.PlanID, i.Namespace, i.Cluster, i.Context, i.State, i.OrganizationGUID, i.SpaceGUID, parameters, yaml,
+		now, now)
 	if err != nil {
 		return 0, err
 	}
@@ -52,8 +83,13 @@ func (d *Dao) InsertInstance(i *Instance) (int64, error) {
 }
 
 func (d *Dao) UpdateInstance(i *Instance) (int64, error) {
+	defer d.observe("update_instance", time.Now())
+	parameters, yaml, err := d.encryptInstance(i)
+	if err != nil {
+		return 0, err
+	}
 	var res sql.Result
-	res, err := d.DB.Exec(_updateSQL, i.PlanID, i.Parameters, i.Yaml,
+	res, err = d.DB.Exec(_updateSQL, i.PlanID, i.Context, i.State, parameters, yaml,
 		time.Now().Format("2006-01-02 15:04:05"), i.InstanceID)
 	if err != nil {
 		return 0, err
@@ -61,7 +97,19 @@ func (d *Dao) UpdateInstance(i *Instance) (int64, error) {
 	return res.RowsAffected()
 }
 
+// UpdateInstanceState records the state of the last operation, updated_at is kept as the start of the operation.
+func (d *Dao) UpdateInstanceState(instanceId, state string) (int64, error) {
+	defer d.observe("update_instance_state", time.Now())
+	var res sql.Result
+	res, err := d.DB.Exec(_updateStateSQL, state, instanceId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 func (d *Dao) DeleteInstance(instanceId string) (int64, error) {
+	defer d.observe("delete_instance", time.Now())
 	var res sql.Result
 	res, err := d.DB.Exec(_deleteSQL, instanceId)
 	if err != nil {
@@ -71,19 +119,25 @@ func (d *Dao) DeleteInstance(instanceId string) (int64, error) {
 }
 
 func (d *Dao) SelectInstance(instanceId string) (*Instance, error) {
+	defer d.observe("select_instance", time.Now())
 	res, err := d.DB.Query(_selectSQL, instanceId)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Close()
 
 	var instance Instance
 	for res.Next() {
 		err := res.Scan(&instance.InstanceID, &instance.ServiceID, &instance.InstanceName, &instance.ServiceName,
-			&instance.PlanID, &instance.Namespace, &instance.OrganizationGUID, &instance.SpaceGUID,
+			&instance.PlanID, &instance.Namespace, &instance.Cluster, &instance.Context, &instance.State, &instance.OrganizationGUID, &instance.SpaceGUID,
 			&instance.Parameters, &instance.Yaml, &instance.CreatedAt, &instance.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
+		err = d.decryptInstance(&instance)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	err = res.Err()
@@ -91,4 +145,252 @@ func (d *Dao) SelectInstance(instanceId string) (*Instance, error) {
 		return nil, err
 	}
 	return &instance, nil
-}
\ No newline at end of file
+}
+
+// SelectInstancesByState returns the instances whose last operation is in the state.
+func (d *Dao) SelectInstancesByState(state string) ([]*Instance, error) {
+	defer d.observe("select_instances_by_state", time.Now())
+	res, err := d.DB.Query(_selectByStateSQL, state)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var instances []*Instance
+	for res.Next() {
+		var instance Instance
+		err := res.Scan(&instance.InstanceID, &instance.ServiceID, &instance.InstanceName, &instance.ServiceName, &instance.PlanID,
+			&instance.Namespace, &instance.Cluster, &instance.Context, &instance.State, &instance.OrganizationGUID,
+			&instance.SpaceGUID, &instance.Parameters, &instance.Yaml, &instance.CreatedAt, &instance.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		err = d.decryptInstance(&instance)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, &instance)
+	}
+
+	err = res.Err()
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+// CountInstances returns the number of instances grouped by service, plan and state.
+func (d *Dao) CountInstances() ([]*InstanceCount, error) {
+	defer d.observe("count_instances", time.Now())
+	res, err := d.DB.Query(_countSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var counts []*InstanceCount
+	for res.Next() {
+		var count InstanceCount
+		err := res.Scan(&count.ServiceName, &count.PlanID, &count.State, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, &count)
+	}
+
+	err = res.Err()
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// InstanceFilter selects the instances of ListInstances, the empty fields match every instance.
+type InstanceFilter struct {
+	ServiceName      string
+	PlanID           string
+	Namespace        string
+	Cluster          string
+	State            string
+	OrganizationGUID string
+	SpaceGUID        string
+	// Search matches a part of the instance id or of the instance name
+	Search string
+
+	// SortBy is one of InstanceSortColumns, the instance id when empty
+	SortBy     string
+	Descending bool
+	// Limit of the instances returned from Offset, all the instances when 0
+	Limit  int
+	Offset int
+}
+
+// InstanceSortColumns are the columns the instances can be sorted by.
+var InstanceSortColumns = []string{"instance_id", "instance_name", "service_name", "plan_id", "namespace", "cluster",
+	"state", "created_at", "updated_at"}
+
+const _countMatchingSQL = `SELECT COUNT(*) FROM instances`
+
+const _listSQL = `SELECT instance_id, service_id, instance_name, service_name, plan_id, namespace, cluster, context, state,
+			organization_guid, space_guid, parameters, yaml, created_at, updated_at FROM instances`
+
+// where returns the condition and the arguments of the filter.
+func (f *InstanceFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, c := range []struct {
+		column string
+		value  string
+	}{
+		{"service_name", f.ServiceName},
+		{"plan_id", f.PlanID},
+		{"namespace", f.Namespace},
+		{"cluster", f.Cluster},
+		{"state", f.State},
+		{"organization_guid", f.OrganizationGUID},
+		{"space_guid", f.SpaceGUID},
+	} {
+		if c.value != "" {
+			conditions = append(conditions, c.column+" = ?")
+			args = append(args, c.value)
+		}
+	}
+	if f.Search != "" {
+		conditions = append(conditions, "(instance_id LIKE ? OR instance_name LIKE ?)")
+		pattern := "%" + escapeLike(f.Search) + "%"
+		args = append(args, pattern, pattern)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// orderBy returns the order of the filter, the instance id breaks the ties so the pages are stable.
+func (f *InstanceFilter) orderBy() (string, error) {
+	column := "instance_id"
+	if f.SortBy != "" {
+		column = ""
+		for _, c := range InstanceSortColumns {
+			if c == f.SortBy {
+				column = c
+			}
+		}
+		if column == "" {
+			return "", fmt.Errorf("instances can not be sorted by %q", f.SortBy)
+		}
+	}
+
+	direction := " ASC"
+	if f.Descending {
+		direction = " DESC"
+	}
+	order := " ORDER BY " + column + direction
+	if column != "instance_id" {
+		order += ", instance_id" + direction
+	}
+	if f.Limit > 0 {
+		order += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	return order, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ListInstances returns the page of the instances matching the filter in its order.
+func (d *Dao) ListInstances(f *InstanceFilter) ([]*Instance, error) {
+	defer d.observe("list_instances", time.Now())
+	where, args := f.where()
+	order, err := f.orderBy()
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.DB.Query(_listSQL+where+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var instances []*Instance
+	for res.Next() {
+		var instance Instance
+		err := res.Scan(&instance.InstanceID, &instance.ServiceID, &instance.InstanceName, &instance.ServiceName, &instance.PlanID,
+			&instance.Namespace, &instance.Cluster, &instance.Context, &instance.State, &instance.OrganizationGUID,
+			&instance.SpaceGUID, &instance.Parameters, &instance.Yaml, &instance.CreatedAt, &instance.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		err = d.decryptInstance(&instance)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, &instance)
+	}
+
+	err = res.Err()
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+// CountMatchingInstances returns the number of instances matching the filter, whatever the page.
+func (d *Dao) CountMatchingInstances(f *InstanceFilter) (int64, error) {
+	defer d.observe("count_matching_instances", time.Now())
+	where, args := f.where()
+	var count int64
+	err := d.DB.QueryRow(_countMatchingSQL+where, args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ImportInstance inserts an exported instance keeping its created_at and updated_at.
+func (d *Dao) ImportInstance(i *Instance) (int64, error) {
+	defer d.observe("import_instance", time.Now())
+	parameters, yaml, err := d.encryptInstance(i)
+	if err != nil {
+		return 0, err
+	}
+	var res sql.Result
+	res, err = d.DB.Exec(_insertSQL, i.InstanceID, i.ServiceID, i.InstanceName,
+		i.ServiceName, i.PlanID, i.Namespace, i.Cluster, i.Context, i.State, i.OrganizationGUID, i.SpaceGUID, parameters, yaml,
+		i.CreatedAt, i.UpdatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// WriteInstances exports the instances as json lines.
+func WriteInstances(w io.Writer, instances []*Instance) error {
+	encoder := json.NewEncoder(w)
+	for _, i := range instances {
+		err := encoder.Encode(i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadInstances reads the instances exported by WriteInstances.
+func ReadInstances(r io.Reader) ([]*Instance, error) {
+	decoder := json.NewDecoder(r)
+	var instances []*Instance
+	for {
+		var i Instance
+		err := decoder.Decode(&i)
+		if err == io.EOF {
+			return instances, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, &i)
+	}
+}
@@ -0,0 +1,38 @@
+package dao
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInstanceFilterWhere(t *testing.T) {
+	where, args := (&InstanceFilter{}).where()
+	if where != "" || args != nil {
+		t.Fatalf("expect no condition, got %q %v", where, args)
+	}
+
+	where, args = (&InstanceFilter{Namespace: "ns", State: "failed", Search: "zk_1%"}).where()
+	if where != " WHERE namespace = ? AND state = ? AND (instance_id LIKE ? OR instance_name LIKE ?)" {
+		t.Fatalf("unexpected condition %q", where)
+	}
+	expect := []interface{}{"ns", "failed", `%zk\_1\%%`, `%zk\_1\%%`}
+	if !reflect.DeepEqual(args, expect) {
+		t.Fatalf("expect %v, got %v", expect, args)
+	}
+}
+
+func TestInstanceFilterOrderBy(t *testing.T) {
+	order, err := (&InstanceFilter{}).orderBy()
+	if err != nil || order != " ORDER BY instance_id ASC" {
+		t.Fatalf("unexpected order %q, err %v", order, err)
+	}
+
+	order, err = (&InstanceFilter{SortBy: "updated_at", Descending: true, Limit: 20, Offset: 40}).orderBy()
+	if err != nil || order != " ORDER BY updated_at DESC, instance_id DESC LIMIT 20 OFFSET 40" {
+		t.Fatalf("unexpected order %q, err %v", order, err)
+	}
+
+	if _, err := (&InstanceFilter{SortBy: "parameters; DROP TABLE instances"}).orderBy(); err == nil {
+		t.Fatal("expect an unknown sort column rejected")
+	}
+}
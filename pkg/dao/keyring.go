@@ -0,0 +1,300 @@
+package dao
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parameters and the yaml of the instances and the credentials of the bindings are stored with
+// envelope encryption: every value is sealed with AES-256-GCM under its own data key, which is
+// sealed under a key encryption key of the Keyring. A stored value reads
+// enc:<key version>:<sealed data key>:<sealed value> in base64, the nonces prepended. The values
+// without the prefix were written before the encryption was enabled and are read as they are.
+
+const encryptedPrefix = "enc:"
+
+// Keyring holds the key encryption keys by version, the highest version encrypts and every version
+// decrypts. A nil Keyring stores the values in plaintext.
+type Keyring struct {
+	keys    map[int][]byte
+	primary int
+}
+
+// ParseKeyring parses the lines <version> <base64 of a 32 bytes key>, the empty lines and the
+// lines starting with # are skipped.
+func ParseKeyring(data []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[int][]byte)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d of the keyring is not <version> <key>", line)
+		}
+		version, err := strconv.Atoi(fields[0])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("line %d of the keyring: version %q is not a positive number", line, fields[0])
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("line %d of the keyring: the key is not 32 bytes in base64", line)
+		}
+		if _, ok := k.keys[version]; ok {
+			return nil, fmt.Errorf("line %d of the keyring: version %d is repeated", line, version)
+		}
+		k.keys[version] = key
+		if version > k.primary {
+			k.primary = version
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("the keyring has no keys")
+	}
+	return k, nil
+}
+
+// Encrypt seals the value with the highest key version, the empty value stays empty.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if k == nil || value == "" {
+		return value, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	sealedKey, err := seal(k.keys[k.primary], dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d:%s:%s", encryptedPrefix, k.primary,
+		base64.StdEncoding.EncodeToString(sealedKey), base64.StdEncoding.EncodeToString(sealedValue)), nil
+}
+
+// Decrypt opens a stored value, the values without the prefix are returned as they are.
+func (k *Keyring) Decrypt(stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		return stored, nil
+	}
+	if k == nil {
+		return "", fmt.Errorf("the value is encrypted and no keyring is configured")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(stored, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("the encrypted value is malformed")
+	}
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("the encrypted value is malformed")
+	}
+	key, ok := k.keys[version]
+	if !ok {
+		return "", fmt.Errorf("key version %d of the encrypted value is not in the keyring", version)
+	}
+	sealedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("the encrypted value is malformed")
+	}
+	sealedValue, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("the encrypted value is malformed")
+	}
+
+	dataKey, err := open(key, sealedKey)
+	if err != nil {
+		return "", fmt.Errorf("open the data key with key version %d: %v", version, err)
+	}
+	value, err := open(dataKey, sealedValue)
+	if err != nil {
+		return "", fmt.Errorf("open the value: %v", err)
+	}
+	return string(value), nil
+}
+
+// current tells whether the stored value is encrypted as Encrypt would now.
+func (k *Keyring) current(stored string) bool {
+	if k == nil || stored == "" {
+		return true
+	}
+	return strings.HasPrefix(stored, fmt.Sprintf("%s%d:", encryptedPrefix, k.primary))
+}
+
+// seal encrypts with AES-GCM and prepends the nonce.
+func seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts what seal returned.
+func open(key, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("the sealed data is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+const (
+	_selectInstanceSecretsSQL = `SELECT instance_id, parameters, yaml FROM instances`
+	_reencryptInstanceSQL     = `UPDATE instances SET parameters = ?, yaml = ? WHERE instance_id = ? AND parameters = ? AND yaml = ?`
+	_selectBindingSecretsSQL  = `SELECT binding_id, credentials, previous_credentials FROM bindings`
+	_reencryptBindingSQL      = `UPDATE bindings SET credentials = ?, previous_credentials = ? WHERE binding_id = ?
+			AND credentials = ? AND previous_credentials = ?`
+)
+
+// encryptInstance returns the parameters and the yaml of the instance as they are stored.
+func (d *Dao) encryptInstance(i *Instance) (string, string, error) {
+	parameters, err := d.Keyring.Encrypt(i.Parameters)
+	if err != nil {
+		return "", "", err
+	}
+	yaml, err := d.Keyring.Encrypt(i.Yaml)
+	if err != nil {
+		return "", "", err
+	}
+	return parameters, yaml, nil
+}
+
+func (d *Dao) decryptInstance(i *Instance) (err error) {
+	i.Parameters, err = d.Keyring.Decrypt(i.Parameters)
+	if err != nil {
+		return fmt.Errorf("parameters of instance %s: %v", i.InstanceID, err)
+	}
+	i.Yaml, err = d.Keyring.Decrypt(i.Yaml)
+	if err != nil {
+		return fmt.Errorf("yaml of instance %s: %v", i.InstanceID, err)
+	}
+	return nil
+}
+
+// encryptCredentials returns the credentials and the previous credentials of the binding as they are stored.
+func (d *Dao) encryptCredentials(b *Binding) (string, string, error) {
+	credentials, err := d.Keyring.Encrypt(b.Credentials)
+	if err != nil {
+		return "", "", err
+	}
+	previous, err := d.Keyring.Encrypt(b.PreviousCredentials)
+	if err != nil {
+		return "", "", err
+	}
+	return credentials, previous, nil
+}
+
+func (d *Dao) decryptCredentials(b *Binding) (err error) {
+	b.Credentials, err = d.Keyring.Decrypt(b.Credentials)
+	if err != nil {
+		return fmt.Errorf("credentials of binding %s: %v", b.BindingID, err)
+	}
+	b.PreviousCredentials, err = d.Keyring.Decrypt(b.PreviousCredentials)
+	if err != nil {
+		return fmt.Errorf("previous credentials of binding %s: %v", b.BindingID, err)
+	}
+	return nil
+}
+
+// Reencrypt rewrites the sensitive columns which are in plaintext or encrypted by an older key
+// version with the highest one and returns the number of rows rewritten, after it the older
+// versions can be removed from the keyring. A row written meanwhile is skipped, its writer
+// encrypted it already.
+func (d *Dao) Reencrypt() (int64, error) {
+	if d.Keyring == nil {
+		return 0, fmt.Errorf("no keyring is configured")
+	}
+	instances, err := d.reencrypt("reencrypt_instances", _selectInstanceSecretsSQL, _reencryptInstanceSQL)
+	if err != nil {
+		return instances, err
+	}
+	bindings, err := d.reencrypt("reencrypt_bindings", _selectBindingSecretsSQL, _reencryptBindingSQL)
+	return instances + bindings, err
+}
+
+// reencrypt rewrites the rows of the query, an id and two encrypted columns, by the update taking
+// the new columns, the id and the old columns.
+func (d *Dao) reencrypt(name, query, update string) (int64, error) {
+	defer d.observe(name, time.Now())
+	res, err := d.DB.Query(query)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+
+	var stale [][3]string
+	for res.Next() {
+		var row [3]string
+		err := res.Scan(&row[0], &row[1], &row[2])
+		if err != nil {
+			return 0, err
+		}
+		if !d.Keyring.current(row[1]) || !d.Keyring.current(row[2]) {
+			stale = append(stale, row)
+		}
+	}
+	err = res.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for _, row := range stale {
+		var columns [2]string
+		for i := range columns {
+			value, err := d.Keyring.Decrypt(row[i+1])
+			if err != nil {
+				return count, fmt.Errorf("%s of %s: %v", name, row[0], err)
+			}
+			columns[i], err = d.Keyring.Encrypt(value)
+			if err != nil {
+				return count, err
+			}
+		}
+		result, err := d.DB.Exec(update, columns[0], columns[1], row[0], row[1], row[2])
+		if err != nil {
+			return count, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return count, err
+		}
+		count += n
+	}
+	return count, nil
+}
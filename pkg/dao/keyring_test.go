@@ -0,0 +1,83 @@
+package dao
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE="
+	testKey2 = "YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXphYmNkZWY="
+)
+
+func TestKeyring(t *testing.T) {
+	old, err := ParseKeyring([]byte("# the first key\n1 " + testKey1 + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := old.Encrypt(`{"PASSWORD":"secret"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, "enc:1:") || strings.Contains(stored, "secret") {
+		t.Fatalf("expect the value encrypted with key version 1, got %q", stored)
+	}
+	again, _ := old.Encrypt(`{"PASSWORD":"secret"}`)
+	if again == stored {
+		t.Fatal("expect every value encrypted under its own data key")
+	}
+
+	rotated, err := ParseKeyring([]byte("2 " + testKey2 + "\n\n1 " + testKey1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := rotated.Decrypt(stored)
+	if err != nil || value != `{"PASSWORD":"secret"}` {
+		t.Fatalf("expect the older version decrypted, got %q, err %v", value, err)
+	}
+	if rotated.current(stored) || !old.current(stored) {
+		t.Fatal("expect the value current only for the keyring of version 1")
+	}
+	stored, _ = rotated.Encrypt("yaml")
+	if !strings.HasPrefix(stored, "enc:2:") {
+		t.Fatalf("expect the highest version encrypting, got %q", stored)
+	}
+	if _, err := old.Decrypt(stored); err == nil {
+		t.Fatal("expect an unknown key version rejected")
+	}
+
+	flipped := byte('A')
+	if stored[len(stored)-10] == flipped {
+		flipped = 'B'
+	}
+	tampered := stored[:len(stored)-10] + string(flipped) + stored[len(stored)-9:]
+	if _, err := rotated.Decrypt(tampered); err == nil {
+		t.Fatal("expect a tampered value rejected")
+	}
+
+	var none *Keyring
+	if value, err := none.Encrypt("plain"); err != nil || value != "plain" {
+		t.Fatalf("expect no keyring storing plaintext, got %q, err %v", value, err)
+	}
+	if value, err := rotated.Decrypt("plain"); err != nil || value != "plain" {
+		t.Fatalf("expect plaintext read as it is, got %q, err %v", value, err)
+	}
+	if _, err := none.Decrypt(stored); err == nil {
+		t.Fatal("expect an encrypted value rejected without a keyring")
+	}
+}
+
+func TestParseKeyringErrors(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"# no keys",
+		"1",
+		"0 " + testKey1,
+		"1 c2hvcnQ=",
+		"1 " + testKey1 + "\n1 " + testKey2,
+	} {
+		if _, err := ParseKeyring([]byte(data)); err == nil {
+			t.Errorf("expect keyring %q rejected", data)
+		}
+	}
+}
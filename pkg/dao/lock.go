@@ -0,0 +1,65 @@
+package dao
+
+import (
+	"time"
+)
+
+const (
+	// the lock is taken over by the new holder when it has expired, the expiry is the last column
+	// assigned so the conditions see the previous value
+	_acquireLockSQL = `INSERT INTO instance_locks (instance_id, operation, holder, expires_at)
+		VALUES (?, ?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND))
+		ON DUPLICATE KEY UPDATE
+			operation = IF(expires_at < NOW(), VALUES(operation), operation),
+			holder = IF(expires_at < NOW(), VALUES(holder), holder),
+			expires_at = IF(expires_at < NOW(), VALUES(expires_at), expires_at)`
+
+	_releaseLockSQL = `DELETE FROM instance_locks WHERE instance_id = ? AND holder = ?`
+
+	_deleteLockSQL = `DELETE FROM instance_locks WHERE instance_id = ?`
+
+	_deleteExpiredLocksSQL = `DELETE FROM instance_locks WHERE expires_at < NOW()`
+)
+
+// AcquireInstanceLock takes the lock of an instance for an operation, it returns false when
+// another holder has a lock which has not expired. The database clock is used so the broker
+// replicas agree on the expiry.
+func (d *Dao) AcquireInstanceLock(instanceId, operation, holder string, ttl time.Duration) (bool, error) {
+	defer d.observe("acquire_instance_lock", time.Now())
+	res, err := d.DB.Exec(_acquireLockSQL, instanceId, operation, holder, int64(ttl/time.Second))
+	if err != nil {
+		return false, err
+	}
+
+	// 1 for a new lock, 2 for an expired lock taken over, 0 when the lock is held
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected != 0, nil
+}
+
+// ReleaseInstanceLock releases the lock of an instance, a lock taken over by another holder is kept.
+func (d *Dao) ReleaseInstanceLock(instanceId, holder string) error {
+	defer d.observe("release_instance_lock", time.Now())
+	_, err := d.DB.Exec(_releaseLockSQL, instanceId, holder)
+	return err
+}
+
+// DeleteExpiredInstanceLocks removes the locks left behind by crashed replicas.
+func (d *Dao) DeleteExpiredInstanceLocks() (int64, error) {
+	defer d.observe("delete_expired_instance_locks", time.Now())
+	res, err := d.DB.Exec(_deleteExpiredLocksSQL)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteInstanceLock removes the lock of an instance whoever holds it, for the administrators
+// clearing an instance stuck in an operation.
+func (d *Dao) DeleteInstanceLock(instanceId string) error {
+	defer d.observe("delete_instance_lock", time.Now())
+	_, err := d.DB.Exec(_deleteLockSQL, instanceId)
+	return err
+}
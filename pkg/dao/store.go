@@ -0,0 +1,56 @@
+package dao
+
+import (
+	"context"
+	"time"
+)
+
+// Store is the persistence of the broker. Dao keeps it in MySQL, the fake package in memory for
+// the tests.
+type Store interface {
+	// WithContext returns a Store tracing its queries under ctx.
+	WithContext(ctx context.Context) Store
+
+	InsertInstance(i *Instance) (int64, error)
+	UpdateInstance(i *Instance) (int64, error)
+	UpdateInstanceState(instanceId, state string) (int64, error)
+	DeleteInstance(instanceId string) (int64, error)
+	// SelectInstance returns an empty instance when the instance does not exist.
+	SelectInstance(instanceId string) (*Instance, error)
+	SelectInstancesByState(state string) ([]*Instance, error)
+	CountInstances() ([]*InstanceCount, error)
+	ListInstances(f *InstanceFilter) ([]*Instance, error)
+	CountMatchingInstances(f *InstanceFilter) (int64, error)
+	ImportInstance(i *Instance) (int64, error)
+
+	AcquireInstanceLock(instanceId, operation, holder string, ttl time.Duration) (bool, error)
+	ReleaseInstanceLock(instanceId, holder string) error
+	DeleteExpiredInstanceLocks() (int64, error)
+	DeleteInstanceLock(instanceId string) error
+
+	InsertAuditLog(a *AuditLog) (int64, error)
+	SelectAuditLogs(instanceId string, since time.Time) ([]*AuditLog, error)
+
+	InsertBackup(b *Backup) (int64, error)
+	UpdateBackup(b *Backup) (int64, error)
+	// SelectBackup returns an empty backup when the backup does not exist.
+	SelectBackup(backupId string) (*Backup, error)
+	SelectBackups(instanceId string) ([]*Backup, error)
+	// SelectProcessingBackups returns the backups whose backup or restore is in the state.
+	SelectProcessingBackups(state string) ([]*Backup, error)
+
+	InsertBinding(b *Binding) (int64, error)
+	UpdateBinding(b *Binding) (int64, error)
+	DeleteBinding(bindingId string) (int64, error)
+	// SelectBinding returns an empty binding when the binding does not exist.
+	SelectBinding(bindingId string) (*Binding, error)
+	SelectBindings(instanceId string) ([]*Binding, error)
+	// SelectBindingsToRevoke returns the bindings whose previous credentials are due to be revoked.
+	SelectBindingsToRevoke(now time.Time) ([]*Binding, error)
+	// SelectBindingsByState returns the bindings whose last operation is in the state.
+	SelectBindingsByState(state string) ([]*Binding, error)
+	// CountBindings returns the number of bindings grouped by the service of their instance, plan and state.
+	CountBindings() ([]*BindingCount, error)
+}
+
+var _ Store = &Dao{}
@@ -0,0 +1,207 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// LocalCluster is the name of the cluster the broker itself runs in,
+	// reached through --kube-config or the in-cluster config.
+	LocalCluster = "local"
+
+	// ClusterLabel marks the Secrets that hold the kubeconfig of a target cluster,
+	// the label value is used as the cluster name.
+	ClusterLabel = "ruyiyun.servicebroker/cluster"
+	// ClusterSecretKey is the key of the kubeconfig in a cluster Secret.
+	ClusterSecretKey = "kubeconfig"
+)
+
+// Cluster is what the broker does on a kubernetes cluster. KubeCli does it through the api server,
+// the fake package in memory for the tests.
+type Cluster interface {
+	// WithContext returns a Cluster tracing its api calls under ctx.
+	WithContext(ctx context.Context) Cluster
+
+	// CreateService creates the Services of the manifests and returns their namespace-name mapping.
+	CreateService(yaml string) (map[string]string, error)
+	// CreateInstance creates the objects of the manifests other than the Services and Ingresses.
+	CreateInstance(yaml string) (map[string]string, error)
+	// UpdateService creates or updates the Services of the manifests.
+	UpdateService(instanceId, yaml string) (map[string]string, error)
+	// UpdateInstance creates or updates the objects of the manifests other than the Services,
+	// Ingresses and Routers.
+	UpdateInstance(instanceId, yaml string) (map[string]string, error)
+	// DeleteInstance deletes every object of the manifests, missing ones are skipped.
+	DeleteInstance(yaml string) error
+	// CheckInstance reports whether the pods of an instance are being created, all ready or failed.
+	CheckInstance(id, namespace string) (creating, ready, failed bool, err error)
+
+	// EnsureInstanceNamespace creates or updates the dedicated namespace of an instance and its quota.
+	EnsureInstanceNamespace(name, instanceId string, quota *Quota) error
+	// DeleteInstanceNamespace deletes the dedicated namespace of an instance and what is left in it.
+	DeleteInstanceNamespace(name, instanceId string) (bool, error)
+
+	// CheckJobs reports whether the Jobs matching the label selector are running, all complete or failed.
+	CheckJobs(namespace, selector string) (running, succeeded, failed bool, err error)
+	// ScaleInstance sets the replicas of the Deployments and the StatefulSets of the manifests.
+	ScaleInstance(manifests string, replicas int32) error
+
+	// ApplySecret creates the Secret or replaces the one with the same instance and binding labels.
+	ApplySecret(secret *v1.Secret) error
+	// DeleteSecret deletes the Secret if it has the instance and binding labels of owner, a missing
+	// one is not an error.
+	DeleteSecret(namespace, name string, owner map[string]string) error
+
+	// DryRunApply submits the manifests with dryRun=All and reports what each object would do.
+	DryRunApply(manifests string) ([]*DryRunResult, error)
+}
+
+var _ Cluster = &KubeCli{}
+
+// Clusters is the registry of the kubernetes clusters instances can be provisioned to.
+type Clusters struct {
+	defaultCluster string
+	clients        map[string]Cluster
+}
+
+func NewClusters(local Cluster, defaultCluster string) *Clusters {
+	if defaultCluster == "" {
+		defaultCluster = LocalCluster
+	}
+
+	return &Clusters{
+		defaultCluster: defaultCluster,
+		clients: map[string]Cluster{
+			LocalCluster: local,
+		},
+	}
+}
+
+// Add registers the client of a named cluster, replacing any existing one.
+func (c *Clusters) Add(name string, kcl Cluster) {
+	c.clients[name] = kcl
+}
+
+// Get returns the client of the named cluster. An empty name means the local cluster,
+// which is where instances recorded before multi-cluster support live.
+func (c *Clusters) Get(name string) (Cluster, error) {
+	if name == "" {
+		name = LocalCluster
+	}
+
+	if kcl, ok := c.clients[name]; ok {
+		return kcl, nil
+	}
+	return nil, fmt.Errorf("cluster %s is not registered", name)
+}
+
+// Default returns the name of the cluster used when no cluster is requested.
+func (c *Clusters) Default() string {
+	return c.defaultCluster
+}
+
+// Names returns the sorted names of all registered clusters.
+func (c *Clusters) Names() []string {
+	names := make([]string, 0, len(c.clients))
+	for name := range c.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks that the default cluster is registered.
+func (c *Clusters) Validate() error {
+	if _, ok := c.clients[c.defaultCluster]; !ok {
+		return fmt.Errorf("default cluster %s is not registered, known clusters are %v", c.defaultCluster, c.Names())
+	}
+	return nil
+}
+
+// LoadFromDir registers every kubeconfig file in dir, named by the file name without extension.
+func (c *Clusters) LoadFromDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		kcl, err := NewFromKubeConfig(data)
+		if err != nil {
+			return fmt.Errorf("load cluster %s from %s failed: %v", name, f.Name(), err)
+		}
+
+		glog.Infof("registered cluster %s from %s", name, f.Name())
+		c.Add(name, kcl)
+	}
+	return nil
+}
+
+// LoadFromSecrets registers the kubeconfig of every Secret labelled with ClusterLabel in the namespace.
+func (c *Clusters) LoadFromSecrets(client Interface, namespace string) error {
+	secrets, err := client.CoreV1().Secrets(namespace).List(metav1.ListOptions{
+		LabelSelector: ClusterLabel,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range secrets.Items {
+		name := secret.Labels[ClusterLabel]
+		if name == "" {
+			name = secret.Name
+		}
+
+		data, ok := secret.Data[ClusterSecretKey]
+		if !ok {
+			return fmt.Errorf("secret %s/%s has no %s key", namespace, secret.Name, ClusterSecretKey)
+		}
+
+		kcl, err := NewFromKubeConfig(data)
+		if err != nil {
+			return fmt.Errorf("load cluster %s from secret %s/%s failed: %v", name, namespace, secret.Name, err)
+		}
+
+		glog.Infof("registered cluster %s from secret %s/%s", name, namespace, secret.Name)
+		c.Add(name, kcl)
+	}
+	return nil
+}
+
+// NewFromKubeConfig creates a KubeCli from the content of a kubeconfig file.
+func NewFromKubeConfig(data []byte) (*KubeCli, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KubeCli{
+		Client:  client,
+		Dynamic: client,
+	}, nil
+}
@@ -0,0 +1,117 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	kapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// DryRunResult is the answer of the api server to one object of a template submitted with dryRun=All.
+type DryRunResult struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Operation is create for a new object, update for an existing one
+	Operation string `json:"operation"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DryRunApply submits every object of the manifests as CreateInstance or UpdateInstance would, with
+// dryRun=All so the api server validates and admits them without persisting anything. The
+// failures of the objects are in the results, the error is for a yaml which can not be decoded.
+func (k *KubeCli) DryRunApply(manifests string) (_ []*DryRunResult, err error) {
+	k, span := k.startOperation("kubernetes.DryRunApply")
+	defer func() {
+		span.Finish(err)
+	}()
+
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifests), 4096)
+	var results []*DryRunResult
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(obj); err != nil {
+			if err == io.EOF {
+				break
+			}
+			glog.Errorf("failed to decode the next object from the underlying stream into an unstructured object: %v", err)
+			return nil, err
+		}
+
+		result := &DryRunResult{
+			Kind:      obj.GetKind(),
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			Operation: "create",
+		}
+		err := k.dryRunObject(obj, result)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (k *KubeCli) dryRunObject(obj *unstructured.Unstructured, result *DryRunResult) error {
+	gvk := obj.GroupVersionKind()
+	span := k.startSpan("kubernetes.discovery", obj)
+	gvr, err := k.resourceFor(gvk)
+	span.Finish(err)
+	if err != nil {
+		return err
+	}
+
+	span = k.startSpan("kubernetes.get", obj)
+	oldObj, err := k.Dynamic.Resource(gvr).Namespace(obj.GetNamespace()).Get(obj.GetName(), metav1.GetOptions{})
+	if kapierrors.IsNotFound(err) {
+		span.Finish(nil)
+	} else {
+		span.Finish(err)
+	}
+	if err != nil && !kapierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	if exists {
+		result.Operation = "update"
+		obj.SetResourceVersion(oldObj.GetResourceVersion())
+	}
+
+	body, err := json.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+
+	// the vendored dynamic client has no options, the request is made on the rest client
+	client := k.Client.Discovery().RESTClient()
+	span = k.startSpan("kubernetes.dryrun", obj)
+	if exists {
+		err = client.Put().AbsPath(resourcePath(gvr, obj.GetNamespace(), obj.GetName())).
+			Param("dryRun", "All").Body(body).Do().Error()
+	} else {
+		err = client.Post().AbsPath(resourcePath(gvr, obj.GetNamespace(), "")).
+			Param("dryRun", "All").Body(body).Do().Error()
+	}
+	span.Finish(err)
+	return err
+}
+
+// resourcePath is the api path of the resource, of the named object when name is set.
+func resourcePath(gvr schema.GroupVersionResource, namespace, name string) string {
+	p := "/apis/" + gvr.Group + "/" + gvr.Version
+	if gvr.Group == "" {
+		p = "/api/" + gvr.Version
+	}
+	if namespace != "" {
+		p = path.Join(p, "namespaces", namespace)
+	}
+	return path.Join(p, gvr.Resource, name)
+}
@@ -0,0 +1,377 @@
+package fake
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// mapper maps the kinds the api server of the fake serves to their resources.
+var mapper = func() meta.RESTMapper {
+	m := meta.NewDefaultRESTMapper(nil)
+	m.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	for _, gvk := range []schema.GroupVersionKind{
+		{Version: "v1", Kind: "Pod"},
+		{Version: "v1", Kind: "Service"},
+		{Version: "v1", Kind: "Secret"},
+		{Version: "v1", Kind: "ConfigMap"},
+		{Version: "v1", Kind: "PersistentVolumeClaim"},
+		{Version: "v1", Kind: "ResourceQuota"},
+		{Version: "v1", Kind: "LimitRange"},
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+		{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+		{Group: "apps", Version: "v1beta1", Kind: "Deployment"},
+		{Group: "apps", Version: "v1beta1", Kind: "StatefulSet"},
+		{Group: "extensions", Version: "v1beta1", Kind: "Deployment"},
+		{Group: "extensions", Version: "v1beta1", Kind: "Ingress"},
+		{Group: "batch", Version: "v1", Kind: "Job"},
+		{Group: "route.openshift.io", Version: "v1", Kind: "Route"},
+	} {
+		m.Add(gvk, meta.RESTScopeNamespace)
+	}
+	return m
+}()
+
+// dynamicClient is the in-memory api server of the cluster. The objects are stored by kind, the
+// group and the version are not told apart.
+type dynamicClient struct {
+	cluster *Cluster
+}
+
+func (d *dynamicClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	r := &resourceClient{cluster: d.cluster, gvr: gvr}
+	gvk, err := mapper.KindFor(gvr)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.gvk = gvk
+	r.namespaced = mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	return r
+}
+
+type resourceClient struct {
+	cluster    *Cluster
+	gvr        schema.GroupVersionResource
+	gvk        schema.GroupVersionKind
+	namespaced bool
+	namespace  string
+	// the resource is not served
+	err error
+}
+
+func (r *resourceClient) Namespace(namespace string) dynamic.ResourceInterface {
+	r2 := *r
+	r2.namespace = namespace
+	return &r2
+}
+
+func (r *resourceClient) key(name string) objectKey {
+	if !r.namespaced {
+		return objectKey{r.gvk.Kind, "", name}
+	}
+	return objectKey{r.gvk.Kind, r.namespace, name}
+}
+
+func (r *resourceClient) notFound(name string) error {
+	return errors.NewNotFound(r.gvr.GroupResource(), name)
+}
+
+func (r *resourceClient) check(obj *unstructured.Unstructured, subresources []string) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(subresources) != 0 {
+		return fmt.Errorf("the fake does not serve the subresources %v", subresources)
+	}
+	if obj == nil {
+		return nil
+	}
+	if obj.GetName() == "" {
+		return errors.NewBadRequest("the name of the object is required")
+	}
+	if r.namespaced && obj.GetNamespace() != "" && obj.GetNamespace() != r.namespace {
+		return errors.NewBadRequest(fmt.Sprintf("the namespace of the object %s does not match %s", obj.GetNamespace(), r.namespace))
+	}
+	return nil
+}
+
+func (r *resourceClient) Create(obj *unstructured.Unstructured, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.check(obj, subresources); err != nil {
+		return nil, err
+	}
+	c := r.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := r.key(obj.GetName())
+	if _, ok := c.objects[key]; ok {
+		return nil, errors.NewAlreadyExists(r.gvr.GroupResource(), key.name)
+	}
+	if r.gvk.Kind == "Pod" {
+		return nil, errors.NewMethodNotSupported(r.gvr.GroupResource(), "create")
+	}
+
+	// the uids sort in the order the objects were created
+	stored := r.prepare(obj)
+	stored.SetUID(types.UID(fmt.Sprintf("%08d", c.resourceVersion)))
+	c.objects[key] = stored
+	return c.withStatus(stored), nil
+}
+
+func (r *resourceClient) Update(obj *unstructured.Unstructured, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.check(obj, subresources); err != nil {
+		return nil, err
+	}
+	c := r.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := r.key(obj.GetName())
+	old, ok := c.objects[key]
+	if !ok {
+		return nil, r.notFound(key.name)
+	}
+	if version := obj.GetResourceVersion(); version != "" && version != old.GetResourceVersion() {
+		return nil, errors.NewConflict(r.gvr.GroupResource(), key.name, fmt.Errorf("the object has been modified"))
+	}
+
+	stored := r.prepare(obj)
+	stored.SetUID(old.GetUID())
+	c.objects[key] = stored
+	return c.withStatus(stored), nil
+}
+
+// prepare copies the object to store it with a new resource version, a Secret gets its stringData
+// merged into its data.
+func (r *resourceClient) prepare(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	c := r.cluster
+	stored := obj.DeepCopy()
+	stored.SetGroupVersionKind(r.gvk)
+	if r.namespaced {
+		stored.SetNamespace(r.namespace)
+	}
+	c.resourceVersion++
+	stored.SetResourceVersion(strconv.Itoa(c.resourceVersion))
+
+	if r.gvk.Kind == "Secret" {
+		stringData, _, _ := unstructured.NestedStringMap(stored.Object, "stringData")
+		for key, value := range stringData {
+			unstructured.SetNestedField(stored.Object, base64.StdEncoding.EncodeToString([]byte(value)), "data", key)
+		}
+		unstructured.RemoveNestedField(stored.Object, "stringData")
+	}
+	return stored
+}
+
+func (r *resourceClient) UpdateStatus(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return nil, errors.NewMethodNotSupported(r.gvr.GroupResource(), "update status")
+}
+
+func (r *resourceClient) Delete(name string, options *metav1.DeleteOptions, subresources ...string) error {
+	if err := r.check(nil, subresources); err != nil {
+		return err
+	}
+	c := r.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := r.key(name)
+	old, ok := c.objects[key]
+	if !ok {
+		return r.notFound(name)
+	}
+	if options != nil && options.Preconditions != nil && options.Preconditions.UID != nil && *options.Preconditions.UID != old.GetUID() {
+		return errors.NewConflict(r.gvr.GroupResource(), name, fmt.Errorf("the uid in the precondition does not match"))
+	}
+
+	delete(c.objects, key)
+	if key.kind == "Namespace" {
+		for k := range c.objects {
+			if k.namespace == name {
+				delete(c.objects, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *resourceClient) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	return errors.NewMethodNotSupported(r.gvr.GroupResource(), "delete collection")
+}
+
+func (r *resourceClient) Get(name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.check(nil, subresources); err != nil {
+		return nil, err
+	}
+	c := r.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r.gvk.Kind == "Pod" {
+		for _, pod := range c.pods(r.namespace) {
+			if pod.GetName() == name {
+				return pod, nil
+			}
+		}
+		return nil, r.notFound(name)
+	}
+	obj, ok := c.objects[r.key(name)]
+	if !ok {
+		return nil, r.notFound(name)
+	}
+	return c.withStatus(obj), nil
+}
+
+func (r *resourceClient) List(opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	if err := r.check(nil, nil); err != nil {
+		return nil, err
+	}
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, errors.NewBadRequest(err.Error())
+	}
+	c := r.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var objects []*unstructured.Unstructured
+	if r.gvk.Kind == "Pod" {
+		objects = c.pods(r.namespace)
+	} else {
+		for key, obj := range c.objects {
+			if key.kind == r.gvk.Kind && (r.namespace == "" || key.namespace == r.namespace) {
+				objects = append(objects, c.withStatus(obj))
+			}
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].GetNamespace() != objects[j].GetNamespace() {
+			return objects[i].GetNamespace() < objects[j].GetNamespace()
+		}
+		return objects[i].GetName() < objects[j].GetName()
+	})
+
+	list := &unstructured.UnstructuredList{}
+	list.SetAPIVersion(r.gvk.GroupVersion().String())
+	list.SetKind(r.gvk.Kind + "List")
+	for _, obj := range objects {
+		if selector.Matches(labels.Set(obj.GetLabels())) {
+			list.Items = append(list.Items, *obj)
+		}
+	}
+	return list, nil
+}
+
+func (r *resourceClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return nil, errors.NewMethodNotSupported(r.gvr.GroupResource(), "watch")
+}
+
+func (r *resourceClient) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.check(nil, subresources); err != nil {
+		return nil, err
+	}
+	if pt != types.MergePatchType {
+		return nil, errors.NewBadRequest(fmt.Sprintf("the fake does not serve %s patches", pt))
+	}
+	var patch map[string]interface{}
+	err := json.Unmarshal(data, &patch)
+	if err != nil {
+		return nil, errors.NewBadRequest(err.Error())
+	}
+	c := r.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := r.key(name)
+	old, ok := c.objects[key]
+	if !ok {
+		return nil, r.notFound(name)
+	}
+	patched := old.DeepCopy()
+	mergePatch(patched.Object, patch)
+	stored := r.prepare(patched)
+	c.objects[key] = stored
+	return c.withStatus(stored), nil
+}
+
+// mergePatch applies the json merge patch of RFC 7386 to the object.
+func mergePatch(obj, patch map[string]interface{}) {
+	for key, value := range patch {
+		if value == nil {
+			delete(obj, key)
+			continue
+		}
+		patchValue, ok := value.(map[string]interface{})
+		if !ok {
+			obj[key] = normalize(value)
+			continue
+		}
+		objValue, ok := obj[key].(map[string]interface{})
+		if !ok {
+			objValue = make(map[string]interface{})
+			obj[key] = objValue
+		}
+		mergePatch(objValue, patchValue)
+	}
+}
+
+// normalize turns the whole numbers json decoded as float64 into int64, as the decoder of the
+// manifests does.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = normalize(v[key])
+		}
+	}
+	return value
+}
+
+func keyOf(obj *unstructured.Unstructured) objectKey {
+	return objectKey{obj.GetKind(), obj.GetNamespace(), obj.GetName()}
+}
+
+func decode(manifests string) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifests), 4096)
+	var objects []*unstructured.Unstructured
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(obj)
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+}
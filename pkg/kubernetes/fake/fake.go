@@ -0,0 +1,277 @@
+// Package fake is a kubernetes.Cluster for the tests of the broker. It runs the KubeCli of the
+// kubernetes package on an in-memory api server, its Dynamic and Mapper, so Provision through
+// Deprovision runs the production code without a cluster.
+//
+// The vendored client-go has no fake dynamic client, so the api server is written here and differs
+// from a real one:
+//   - only the kinds of the templates, the namespaces, the quotas and the Secrets are served, the
+//     namespace of an object need not exist, and deleting a namespace deletes its objects at once
+//   - no controllers run: the pods of the Deployments, StatefulSets and Jobs are derived from them
+//     when they are read, in the order the objects were created, and only the pods which fit in the
+//     ResourceQuota and the LimitRange of the namespace exist
+//   - the pods are ready and the Jobs complete unless the test marks them otherwise, a Job whose pod
+//     does not fit keeps running
+//   - DryRunApply only reports whether the objects exist, nothing is validated
+//   - Patch supports merge patches only, Watch and DeleteCollection are not supported
+package fake
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type objectKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// Cluster is the KubeCli on the in-memory api server. The operations can be made to fail by the
+// name of their method.
+type Cluster struct {
+	*kubernetes.KubeCli
+
+	mu sync.Mutex
+
+	objects map[objectKey]*unstructured.Unstructured
+	// pod states of the instances by instance id
+	creating map[string]bool
+	failed   map[string]bool
+	// Jobs still running or failed, the others are complete
+	runningJobs map[objectKey]bool
+	failedJobs  map[objectKey]bool
+	// errors returned by the operations by method name
+	errors map[string]error
+
+	resourceVersion int
+}
+
+var _ kubernetes.Cluster = &Cluster{}
+
+func NewCluster() *Cluster {
+	c := &Cluster{
+		objects:     make(map[objectKey]*unstructured.Unstructured),
+		creating:    make(map[string]bool),
+		failed:      make(map[string]bool),
+		runningJobs: make(map[objectKey]bool),
+		failedJobs:  make(map[objectKey]bool),
+		errors:      make(map[string]error),
+	}
+	c.KubeCli = &kubernetes.KubeCli{
+		Dynamic: &dynamicClient{cluster: c},
+		Mapper:  mapper,
+	}
+	return c
+}
+
+// SetError makes the operation, named as the method, return err until it is set to nil.
+func (c *Cluster) SetError(operation string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		delete(c.errors, operation)
+		return
+	}
+	c.errors[operation] = err
+}
+
+// SetCreating reports the pods of the instance as still being created.
+func (c *Cluster) SetCreating(instanceId string, creating bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.creating[instanceId] = creating
+}
+
+// SetFailed reports the pods of the instance as failed.
+func (c *Cluster) SetFailed(instanceId string, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failed[instanceId] = failed
+}
+
+// SetJobRunning reports the Job as still running.
+func (c *Cluster) SetJobRunning(namespace, name string, running bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runningJobs[objectKey{"Job", namespace, name}] = running
+}
+
+// SetJobFailed reports the Job as failed.
+func (c *Cluster) SetJobFailed(namespace, name string, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failedJobs[objectKey{"Job", namespace, name}] = failed
+}
+
+// Get returns a copy of the object as the api server returns it, nil when it does not exist. The
+// namespaces are cluster scoped, their namespace is empty.
+func (c *Cluster) Get(kind, namespace, name string) *unstructured.Unstructured {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, ok := c.objects[objectKey{kind, namespace, name}]
+	if !ok {
+		return nil
+	}
+	return c.withStatus(obj)
+}
+
+// Objects returns copies of the objects in the namespaces sorted by kind, namespace and name. The
+// namespaces themselves and the pods are left out.
+func (c *Cluster) Objects() []*unstructured.Unstructured {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]objectKey, 0, len(c.objects))
+	for key := range c.objects {
+		if key.namespace != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind < keys[j].kind
+		}
+		if keys[i].namespace != keys[j].namespace {
+			return keys[i].namespace < keys[j].namespace
+		}
+		return keys[i].name < keys[j].name
+	})
+
+	objects := make([]*unstructured.Unstructured, 0, len(keys))
+	for _, key := range keys {
+		objects = append(objects, c.withStatus(c.objects[key]))
+	}
+	return objects
+}
+
+func (c *Cluster) failure(operation string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.errors[operation]
+}
+
+// WithContext returns the Cluster itself, the fake does not trace.
+func (c *Cluster) WithContext(ctx context.Context) kubernetes.Cluster {
+	return c
+}
+
+func (c *Cluster) CreateService(yaml string) (map[string]string, error) {
+	if err := c.failure("CreateService"); err != nil {
+		return nil, err
+	}
+	return c.KubeCli.CreateService(yaml)
+}
+
+func (c *Cluster) CreateInstance(yaml string) (map[string]string, error) {
+	if err := c.failure("CreateInstance"); err != nil {
+		return nil, err
+	}
+	return c.KubeCli.CreateInstance(yaml)
+}
+
+func (c *Cluster) UpdateService(instanceId, yaml string) (map[string]string, error) {
+	if err := c.failure("UpdateService"); err != nil {
+		return nil, err
+	}
+	return c.KubeCli.UpdateService(instanceId, yaml)
+}
+
+func (c *Cluster) UpdateInstance(instanceId, yaml string) (map[string]string, error) {
+	if err := c.failure("UpdateInstance"); err != nil {
+		return nil, err
+	}
+	return c.KubeCli.UpdateInstance(instanceId, yaml)
+}
+
+func (c *Cluster) DeleteInstance(yaml string) error {
+	if err := c.failure("DeleteInstance"); err != nil {
+		return err
+	}
+	return c.KubeCli.DeleteInstance(yaml)
+}
+
+func (c *Cluster) CheckInstance(id, namespace string) (creating, ready, failed bool, err error) {
+	if err := c.failure("CheckInstance"); err != nil {
+		return false, false, false, err
+	}
+	return c.KubeCli.CheckInstance(id, namespace)
+}
+
+func (c *Cluster) EnsureInstanceNamespace(name, instanceId string, quota *kubernetes.Quota) error {
+	if err := c.failure("EnsureInstanceNamespace"); err != nil {
+		return err
+	}
+	return c.KubeCli.EnsureInstanceNamespace(name, instanceId, quota)
+}
+
+func (c *Cluster) DeleteInstanceNamespace(name, instanceId string) (bool, error) {
+	if err := c.failure("DeleteInstanceNamespace"); err != nil {
+		return false, err
+	}
+	return c.KubeCli.DeleteInstanceNamespace(name, instanceId)
+}
+
+func (c *Cluster) CheckJobs(namespace, selector string) (running, succeeded, failed bool, err error) {
+	if err := c.failure("CheckJobs"); err != nil {
+		return false, false, false, err
+	}
+	return c.KubeCli.CheckJobs(namespace, selector)
+}
+
+func (c *Cluster) ScaleInstance(manifests string, replicas int32) error {
+	if err := c.failure("ScaleInstance"); err != nil {
+		return err
+	}
+	return c.KubeCli.ScaleInstance(manifests, replicas)
+}
+
+func (c *Cluster) ApplySecret(secret *v1.Secret) error {
+	if err := c.failure("ApplySecret"); err != nil {
+		return err
+	}
+	return c.KubeCli.ApplySecret(secret)
+}
+
+func (c *Cluster) DeleteSecret(namespace, name string, owner map[string]string) error {
+	if err := c.failure("DeleteSecret"); err != nil {
+		return err
+	}
+	return c.KubeCli.DeleteSecret(namespace, name, owner)
+}
+
+// DryRunApply reports the objects of the manifests as created or updated, the api server of the fake
+// has no dry run.
+func (c *Cluster) DryRunApply(manifests string) ([]*kubernetes.DryRunResult, error) {
+	if err := c.failure("DryRunApply"); err != nil {
+		return nil, err
+	}
+	objects, err := decode(manifests)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := make([]*kubernetes.DryRunResult, 0, len(objects))
+	for _, obj := range objects {
+		result := &kubernetes.DryRunResult{
+			Kind:      obj.GetKind(),
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			Operation: "create",
+		}
+		if _, ok := c.objects[keyOf(obj)]; ok {
+			result.Operation = "update"
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
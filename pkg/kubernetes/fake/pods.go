@@ -0,0 +1,317 @@
+package fake
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// The fake runs no controllers. The pods of the Deployments, StatefulSets and Jobs of a namespace are
+// derived when they are read: the workloads are taken in the order they were created and each of
+// their pods exists if it fits in what the ResourceQuotas of the namespace leave, its containers
+// defaulted and bounded by the LimitRanges. A complete or failed Job does not hold its pod.
+
+// withStatus returns a copy of the object, a Job with the conditions of its pod.
+func (c *Cluster) withStatus(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	obj = obj.DeepCopy()
+	if obj.GetKind() != "Job" {
+		return obj
+	}
+
+	key := keyOf(obj)
+	_, admitted := c.schedule(obj.GetNamespace())
+	var conditions []interface{}
+	switch {
+	case c.failedJobs[key]:
+		conditions = append(conditions, map[string]interface{}{"type": "Failed", "status": "True"})
+	case c.runningJobs[key] || !admitted[key]:
+		unstructured.SetNestedField(obj.Object, int64(1), "status", "active")
+	default:
+		conditions = append(conditions, map[string]interface{}{"type": "Complete", "status": "True"})
+	}
+	if conditions != nil {
+		unstructured.SetNestedSlice(obj.Object, conditions, "status", "conditions")
+	}
+	return obj
+}
+
+// pods returns the pods of the namespace, of all namespaces when it is empty.
+func (c *Cluster) pods(namespace string) []*unstructured.Unstructured {
+	namespaces := map[string]bool{namespace: true}
+	if namespace == "" {
+		namespaces = make(map[string]bool)
+		for key := range c.objects {
+			namespaces[key.namespace] = true
+		}
+	}
+
+	var pods []*unstructured.Unstructured
+	for ns := range namespaces {
+		admitted, _ := c.schedule(ns)
+		pods = append(pods, admitted...)
+	}
+	return pods
+}
+
+// schedule returns the pods of the namespace and the Jobs whose pod was admitted.
+func (c *Cluster) schedule(namespace string) ([]*unstructured.Unstructured, map[objectKey]bool) {
+	var workloads, quotas, limitRanges []*unstructured.Unstructured
+	for key, obj := range c.objects {
+		if key.namespace != namespace {
+			continue
+		}
+		switch key.kind {
+		case "Deployment", "StatefulSet", "Job":
+			workloads = append(workloads, obj)
+		case "ResourceQuota":
+			quotas = append(quotas, obj)
+		case "LimitRange":
+			limitRanges = append(limitRanges, obj)
+		}
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		return workloads[i].GetUID() < workloads[j].GetUID()
+	})
+	a := newAdmission(quotas, limitRanges)
+
+	var pods []*unstructured.Unstructured
+	jobs := make(map[objectKey]bool)
+	for _, workload := range workloads {
+		key := keyOf(workload)
+		template, _, _ := unstructured.NestedMap(workload.Object, "spec", "template")
+		if key.kind == "Job" {
+			if c.failedJobs[key] {
+				continue
+			}
+			pod := newPod(workload, template, workload.GetName())
+			if !a.admit(pod) {
+				continue
+			}
+			jobs[key] = true
+			if c.runningJobs[key] {
+				pods = append(pods, c.withPodStatus(pod))
+			} else {
+				a.release(pod)
+			}
+			continue
+		}
+
+		replicas, found, _ := unstructured.NestedInt64(workload.Object, "spec", "replicas")
+		if !found {
+			replicas = 1
+		}
+		for i := int64(0); i < replicas; i++ {
+			pod := newPod(workload, template, fmt.Sprintf("%s-%d", workload.GetName(), i))
+			if a.admit(pod) {
+				pods = append(pods, c.withPodStatus(pod))
+			}
+		}
+	}
+	return pods, jobs
+}
+
+func newPod(owner *unstructured.Unstructured, template map[string]interface{}, name string) *unstructured.Unstructured {
+	pod := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	pod.SetAPIVersion("v1")
+	pod.SetKind("Pod")
+	pod.SetNamespace(owner.GetNamespace())
+	pod.SetName(name)
+	labels, _, _ := unstructured.NestedStringMap(template, "metadata", "labels")
+	pod.SetLabels(labels)
+	if spec, ok := template["spec"]; ok {
+		pod.Object["spec"] = runtime.DeepCopyJSONValue(spec)
+	}
+	return pod
+}
+
+// withPodStatus reports the containers ready unless the instance of the pod is marked creating or
+// failed.
+func (c *Cluster) withPodStatus(pod *unstructured.Unstructured) *unstructured.Unstructured {
+	id := pod.GetLabels()[kubernetes.InstanceLabel]
+	containers, _, _ := unstructured.NestedSlice(pod.Object, "spec", "containers")
+	statuses := make([]interface{}, 0, len(containers))
+	for _, container := range containers {
+		name, _, _ := unstructured.NestedString(container.(map[string]interface{}), "name")
+		status := map[string]interface{}{"name": name, "ready": true}
+		switch {
+		case c.failed[id]:
+			status["ready"] = false
+			status["state"] = map[string]interface{}{"waiting": map[string]interface{}{"reason": "CrashLoopBackOff"}}
+		case c.creating[id]:
+			status["ready"] = false
+			status["state"] = map[string]interface{}{"waiting": map[string]interface{}{"reason": "ContainerCreating"}}
+		default:
+			status["state"] = map[string]interface{}{"running": map[string]interface{}{}}
+		}
+		statuses = append(statuses, status)
+	}
+	unstructured.SetNestedSlice(pod.Object, statuses, "status", "containerStatuses")
+	return pod
+}
+
+// admission counts what the admitted pods use of the quotas of a namespace.
+type admission struct {
+	quotas      []v1.ResourceQuota
+	limitRanges []v1.LimitRange
+	used        v1.ResourceList
+}
+
+func newAdmission(quotas, limitRanges []*unstructured.Unstructured) *admission {
+	a := &admission{used: v1.ResourceList{}}
+	for _, obj := range quotas {
+		quota := v1.ResourceQuota{}
+		if runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &quota) == nil {
+			a.quotas = append(a.quotas, quota)
+		}
+	}
+	for _, obj := range limitRanges {
+		limitRange := v1.LimitRange{}
+		if runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &limitRange) == nil {
+			a.limitRanges = append(a.limitRanges, limitRange)
+		}
+	}
+	return a
+}
+
+// admit adds the usage of the pod when it fits in the limit ranges and the quotas.
+func (a *admission) admit(pod *unstructured.Unstructured) bool {
+	usage, ok := a.usage(pod)
+	if !ok {
+		return false
+	}
+	for _, quota := range a.quotas {
+		for name, hard := range quota.Spec.Hard {
+			if name == v1.ResourceCPU || name == v1.ResourceMemory {
+				name = v1.ResourceName("requests." + string(name))
+			}
+			add, ok := usage[name]
+			if !ok {
+				continue
+			}
+			total := a.used[name]
+			total.Add(add)
+			if total.Cmp(hard) > 0 {
+				return false
+			}
+		}
+	}
+	for name, add := range usage {
+		total := a.used[name]
+		total.Add(add)
+		a.used[name] = total
+	}
+	return true
+}
+
+// release takes the usage of a pod which is done off again.
+func (a *admission) release(pod *unstructured.Unstructured) {
+	usage, _ := a.usage(pod)
+	for name, sub := range usage {
+		total := a.used[name]
+		total.Sub(sub)
+		a.used[name] = total
+	}
+}
+
+// usage returns the pods, cpu and memory the pod counts against a quota, false when a container
+// exceeds the maximum of a limit range.
+func (a *admission) usage(pod *unstructured.Unstructured) (v1.ResourceList, bool) {
+	spec := v1.PodSpec{}
+	content, _, _ := unstructured.NestedMap(pod.Object, "spec")
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &spec)
+	if err != nil {
+		return nil, false
+	}
+
+	containers := v1.ResourceList{}
+	for i := range spec.Containers {
+		resources, ok := a.defaults(&spec.Containers[i])
+		if !ok {
+			return nil, false
+		}
+		for name, q := range resources {
+			total := containers[name]
+			total.Add(q)
+			containers[name] = total
+		}
+	}
+	// the init containers run one after the other before the containers
+	for i := range spec.InitContainers {
+		resources, ok := a.defaults(&spec.InitContainers[i])
+		if !ok {
+			return nil, false
+		}
+		for name, q := range resources {
+			if total := containers[name]; q.Cmp(total) > 0 {
+				containers[name] = q
+			}
+		}
+	}
+	containers[v1.ResourcePods] = resource.MustParse("1")
+	return containers, true
+}
+
+// defaults returns the requests and the limits of the container with the defaults of the limit
+// ranges, false when they exceed a maximum.
+func (a *admission) defaults(container *v1.Container) (v1.ResourceList, bool) {
+	requests, limits := v1.ResourceList{}, v1.ResourceList{}
+	for name, q := range container.Resources.Requests {
+		requests[name] = q
+	}
+	for name, q := range container.Resources.Limits {
+		limits[name] = q
+	}
+	for _, limitRange := range a.limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != v1.LimitTypeContainer {
+				continue
+			}
+			for name, q := range item.Default {
+				if _, ok := limits[name]; !ok {
+					limits[name] = q
+				}
+			}
+			for name, q := range item.DefaultRequest {
+				if _, ok := requests[name]; !ok {
+					requests[name] = q
+				}
+			}
+		}
+	}
+	for name, q := range limits {
+		if _, ok := requests[name]; !ok {
+			requests[name] = q
+		}
+	}
+	for _, limitRange := range a.limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != v1.LimitTypeContainer {
+				continue
+			}
+			for name, max := range item.Max {
+				if limit, ok := limits[name]; ok && limit.Cmp(max) > 0 {
+					return nil, false
+				}
+				if request, ok := requests[name]; ok && request.Cmp(max) > 0 {
+					return nil, false
+				}
+			}
+		}
+	}
+
+	resources := v1.ResourceList{}
+	for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		if q, ok := requests[name]; ok {
+			resources[v1.ResourceName("requests."+string(name))] = q
+		}
+		if q, ok := limits[name]; ok {
+			resources[v1.ResourceName("limits."+string(name))] = q
+		}
+	}
+	return resources, true
+}
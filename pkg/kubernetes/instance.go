@@ -6,10 +6,16 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"strings"
 
+	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func (k *KubeCli) CreateService(yaml string) (map[string]string, error) {
+func (k *KubeCli) CreateService(yaml string) (_ map[string]string, err error) {
+	k, span := k.startOperation("kubernetes.CreateService")
+	defer func() {
+		span.Finish(err)
+	}()
+
 	filter := func(obj *unstructured.Unstructured) bool {
 		kind := obj.GetKind()
 		if kind == "Service" {
@@ -25,7 +31,12 @@ func (k *KubeCli) CreateService(yaml string) (map[string]string, error) {
 	return kubeServices, nil
 }
 
-func (k *KubeCli) CreateInstance(yaml string) (map[string]string, error) {
+func (k *KubeCli) CreateInstance(yaml string) (_ map[string]string, err error) {
+	k, span := k.startOperation("kubernetes.CreateInstance")
+	defer func() {
+		span.Finish(err)
+	}()
+
 	filter := func(obj *unstructured.Unstructured) bool {
 		kind := obj.GetKind()
 		if kind != "Service" && kind != "Ingress" {
@@ -41,49 +52,69 @@ func (k *KubeCli) CreateInstance(yaml string) (map[string]string, error) {
 	return kubeDeployments, nil
 }
 
-func (k *KubeCli) DeleteInstance(yaml string) error {
-	err := k.deleteFromReader(strings.NewReader(yaml))
+func (k *KubeCli) DeleteInstance(yaml string) (err error) {
+	k, span := k.startOperation("kubernetes.DeleteInstance")
+	defer func() {
+		span.Finish(err)
+	}()
+
+	err = k.deleteFromReader(strings.NewReader(yaml))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (k *KubeCli) CheckInstance(id, namespace string) (bool, bool, bool, error) {
+// CheckInstance reports whether the pods of an instance are still being created, all ready or
+// failed, e.g. crashing or unable to pull their image.
+func (k *KubeCli) CheckInstance(id, namespace string) (creating, ready, failed bool, err error) {
+	k, span := k.startOperation("kubernetes.CheckInstance")
+	defer func() {
+		span.Finish(err)
+	}()
+
 	listOptions := metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("ruyiyun.servicebroker/instance=%s", id),
+		LabelSelector: fmt.Sprintf("%s=%s", InstanceLabel, id),
 	}
 
-	pods, err := k.Client.CoreV1().Pods(namespace).List(listOptions)
+	pods := &v1.PodList{}
+	err = k.listObjects(podKind, namespace, listOptions, pods)
 	if err != nil {
 		glog.Errorf("failed to retrieve related Deployment with instance id %q: %v", id, err)
 		return false, false, false, err
 	}
 
-	ready, failed, sum := 0, 0, 0
+	readyCount, failedCount, sum := 0, 0, 0
 	for _, pod := range pods.Items {
 		for _, containerStatus := range pod.Status.ContainerStatuses {
 			sum++
 			if containerStatus.Ready {
-				ready++
+				readyCount++
 				continue
 			}
-			if containerStatus.State.Waiting.Reason != "ContainerCreating" {
-				failed++
+			waiting := containerStatus.State.Waiting
+			if waiting != nil && waiting.Reason != "ContainerCreating" && waiting.Reason != "PodInitializing" {
+				failedCount++
 			}
 		}
 	}
 
-	if failed != 0 {
+	if failedCount != 0 {
 		return false, false, true, nil
 	}
-	if ready != sum {
-		return false, true, false, nil
+	// the pods are not scheduled or not even created yet
+	if sum == 0 || readyCount != sum {
+		return true, false, false, nil
 	}
-	return true, false, false, nil
+	return false, true, false, nil
 }
 
-func (k *KubeCli) UpdateService(instanceId, yaml string) (map[string]string, error) {
+func (k *KubeCli) UpdateService(instanceId, yaml string) (_ map[string]string, err error) {
+	k, span := k.startOperation("kubernetes.UpdateService")
+	defer func() {
+		span.Finish(err)
+	}()
+
 	filter := func(obj *unstructured.Unstructured) bool {
 		kind := obj.GetKind()
 		if kind == "Service" {
@@ -99,10 +130,15 @@ func (k *KubeCli) UpdateService(instanceId, yaml string) (map[string]string, err
 	return kubeServices, nil
 }
 
-func (k *KubeCli) UpdateInstance(instanceId, yaml string) (map[string]string, error) {
+func (k *KubeCli) UpdateInstance(instanceId, yaml string) (_ map[string]string, err error) {
+	k, span := k.startOperation("kubernetes.UpdateInstance")
+	defer func() {
+		span.Finish(err)
+	}()
+
 	filter := func(obj *unstructured.Unstructured) bool {
 		kind := obj.GetKind()
-		if kind != "Service" && kind != "Ingress" && kind != "Router"{
+		if kind != "Service" && kind != "Ingress" && kind != "Router" {
 			return true
 		}
 		return false
@@ -113,4 +149,4 @@ func (k *KubeCli) UpdateInstance(instanceId, yaml string) (map[string]string, er
 		return nil, err
 	}
 	return kubeDeployments, nil
-}
\ No newline at end of file
+}
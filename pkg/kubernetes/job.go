@@ -0,0 +1,114 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/golang/glog"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// BackupLabel marks the Jobs and the Secrets of a backup, the label value is the backup id.
+	BackupLabel = "ruyiyun.servicebroker/backup"
+	// RestoreLabel marks the Jobs and the Secrets restoring a backup, the label value is the backup id.
+	RestoreLabel = "ruyiyun.servicebroker/restore"
+	// JobLabel marks the Jobs and the Secrets a service runs to completion within a request, the
+	// label value is the id of the run.
+	JobLabel = "ruyiyun.servicebroker/job"
+)
+
+// JobCPU and JobMemory are the requests and limits of every container of the Jobs run beside an
+// instance, the quota of its dedicated namespace leaves room for one such pod.
+const (
+	JobCPU    = "100m"
+	JobMemory = "128Mi"
+)
+
+// CheckJobs reports whether the Jobs matching the label selector are still running, all complete or
+// any of them failed. Jobs which do not exist any more are failed.
+func (k *KubeCli) CheckJobs(namespace, selector string) (running, succeeded, failed bool, err error) {
+	k, span := k.startOperation("kubernetes.CheckJobs")
+	defer func() {
+		span.Finish(err)
+	}()
+
+	jobs := &batchv1.JobList{}
+	err = k.listObjects(jobKind, namespace, metav1.ListOptions{LabelSelector: selector}, jobs)
+	if err != nil {
+		glog.Errorf("failed to list the jobs %s in %s: %v", selector, namespace, err)
+		return false, false, false, err
+	}
+	if len(jobs.Items) == 0 {
+		return false, false, true, nil
+	}
+
+	complete := 0
+	for _, job := range jobs.Items {
+		for _, condition := range job.Status.Conditions {
+			if condition.Status != v1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case batchv1.JobFailed:
+				return false, false, true, nil
+			case batchv1.JobComplete:
+				complete++
+			}
+		}
+	}
+
+	if complete != len(jobs.Items) {
+		return true, false, false, nil
+	}
+	return false, true, false, nil
+}
+
+// ScaleInstance sets the replicas of the Deployments and the StatefulSets of the manifests, the
+// other objects are left as they are.
+func (k *KubeCli) ScaleInstance(manifests string, replicas int32) (err error) {
+	k, span := k.startOperation("kubernetes.ScaleInstance")
+	defer func() {
+		span.Finish(err)
+	}()
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas))
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifests), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(obj); err != nil {
+			if err == io.EOF {
+				break
+			}
+			glog.Errorf("failed to decode the next object from the underlying stream into an unstructured object: %v", err)
+			return err
+		}
+		if kind := obj.GetKind(); kind != "Deployment" && kind != "StatefulSet" {
+			continue
+		}
+
+		gvk := obj.GroupVersionKind()
+		span := k.startSpan("kubernetes.discovery", obj)
+		gvr, err := k.resourceFor(gvk)
+		span.Finish(err)
+		if err != nil {
+			glog.Errorf("failed to discovery GVR for the resource %v: %v", gvk, err)
+			return objectError(obj, err)
+		}
+
+		span = k.startSpan("kubernetes.scale", obj)
+		_, err = k.Dynamic.Resource(gvr).Namespace(obj.GetNamespace()).Patch(obj.GetName(), types.MergePatchType, patch)
+		span.Finish(err)
+		if err != nil {
+			glog.Errorf("failed to scale the %s resource %s/%s: %v", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+			return objectError(obj, err)
+		}
+	}
+	return nil
+}
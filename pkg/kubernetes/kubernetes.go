@@ -1,7 +1,9 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
+	"github.com/arugaki/osb-starter-pack/pkg/trace"
 	"github.com/golang/glog"
 	"io"
 	kapierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -9,9 +11,10 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/yaml"
 
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
 	clientrest "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	discoveryutil "kmodules.xyz/client-go/discovery"
 )
 
 func GetKubernetesClient(kubeConfigPath string) (Interface, error) {
@@ -36,8 +39,73 @@ func GetKubernetesClient(kubeConfigPath string) (Interface, error) {
 	return NewForConfig(clientConfig)
 }
 
+// ObjectError is the error of the api server or the discovery for one object of a template.
+type ObjectError struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Err       error
+}
+
+func (e *ObjectError) Error() string {
+	return fmt.Sprintf("%s %s/%s: %v", e.Kind, e.Namespace, e.Name, e.Err)
+}
+
+// Reason returns the reason of the api server, StatusReasonUnknown for any other error.
+func (e *ObjectError) Reason() metav1.StatusReason {
+	return kapierrors.ReasonForError(e.Err)
+}
+
+func objectError(obj *unstructured.Unstructured, err error) error {
+	return &ObjectError{
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Err:       err,
+	}
+}
+
 type KubeCli struct {
 	Client Interface
+	// Dynamic serves the api calls, Mapper finds the resources of the kinds when it is set
+	Dynamic dynamic.Interface
+	Mapper  meta.RESTMapper
+	// spans of the api calls are children of the span in ctx
+	ctx context.Context
+}
+
+// WithContext returns a shallow copy of k tracing its api calls under ctx.
+func (k *KubeCli) WithContext(ctx context.Context) Cluster {
+	return k.withContext(ctx)
+}
+
+func (k *KubeCli) withContext(ctx context.Context) *KubeCli {
+	k2 := *k
+	k2.ctx = ctx
+	return &k2
+}
+
+func (k *KubeCli) traceContext() context.Context {
+	if k.ctx == nil {
+		return context.Background()
+	}
+	return k.ctx
+}
+
+// startOperation starts the span of a method of k, the api calls made through the returned
+// KubeCli are its children.
+func (k *KubeCli) startOperation(name string) (*KubeCli, *trace.Span) {
+	ctx, span := trace.Start(k.traceContext(), name)
+	return k.withContext(ctx), span
+}
+
+// startSpan starts the span of an api call on obj.
+func (k *KubeCli) startSpan(name string, obj *unstructured.Unstructured) *trace.Span {
+	_, span := trace.Start(k.traceContext(), name)
+	span.SetAttribute("k8s.kind", obj.GetKind())
+	span.SetAttribute("k8s.namespace", obj.GetNamespace())
+	span.SetAttribute("k8s.name", obj.GetName())
+	return span
 }
 
 func New(kubeConfig string) (*KubeCli, error) {
@@ -47,11 +115,12 @@ func New(kubeConfig string) (*KubeCli, error) {
 	}
 
 	return &KubeCli{
-		Client: client,
+		Client:  client,
+		Dynamic: client,
 	}, nil
 }
 
-func (k *KubeCli) createFromReader(filter func(obj *unstructured.Unstructured) bool, reader io.Reader)(map[string]string, error) {
+func (k *KubeCli) createFromReader(filter func(obj *unstructured.Unstructured) bool, reader io.Reader) (map[string]string, error) {
 	decoder := yaml.NewYAMLOrJSONDecoder(reader, 4096)
 	needCreateResources := make(map[string]string)
 	for {
@@ -67,14 +136,16 @@ func (k *KubeCli) createFromReader(filter func(obj *unstructured.Unstructured) b
 
 		// find the object's resource interface
 		gvk := obj.GroupVersionKind()
-		gvr, err := discoveryutil.ResourceForGVK(k.Client.Discovery(), gvk)
+		span := k.startSpan("kubernetes.discovery", obj)
+		gvr, err := k.resourceFor(gvk)
+		span.Finish(err)
 		if err != nil {
 			glog.Errorf("failed to discovery GVR for the resource %v: %v", gvk, err)
-			return nil, err
+			return nil, objectError(obj, err)
 		}
 		namespace := obj.GetNamespace()
 		name := obj.GetName()
-		ri := k.Client.Resource(gvr).Namespace(namespace)
+		ri := k.Dynamic.Resource(gvr).Namespace(namespace)
 
 		if needCreate := filter(obj.DeepCopy()); !needCreate {
 			continue
@@ -82,10 +153,12 @@ func (k *KubeCli) createFromReader(filter func(obj *unstructured.Unstructured) b
 
 		// create the object using its resource interface
 		obj.SetResourceVersion("")
+		span = k.startSpan("kubernetes.create", obj)
 		_, err = ri.Create(obj)
+		span.Finish(err)
 		if err != nil {
 			glog.Errorf("failed to create the resource %s/%s: %v", namespace, name, err)
-			return nil, err
+			return nil, objectError(obj, err)
 		}
 
 		needCreateResources[obj.GetNamespace()] = obj.GetName()
@@ -109,13 +182,15 @@ func (k *KubeCli) createOrUpdateFromReader(filter func(obj *unstructured.Unstruc
 
 		// find the object's resource interface
 		gvk := obj.GroupVersionKind()
-		gvr, err := discoveryutil.ResourceForGVK(k.Client.Discovery(), gvk)
+		span := k.startSpan("kubernetes.discovery", obj)
+		gvr, err := k.resourceFor(gvk)
+		span.Finish(err)
 		if err != nil {
 			glog.Errorf("failed to discovery GVR for the resource %v: %v", gvk, err)
-			return nil, err
+			return nil, objectError(obj, err)
 		}
 		namespace := obj.GetNamespace()
-		ri := k.Client.Resource(gvr).Namespace(namespace)
+		ri := k.Dynamic.Resource(gvr).Namespace(namespace)
 
 		// handle the object using its resource interface
 		name := obj.GetName()
@@ -125,29 +200,38 @@ func (k *KubeCli) createOrUpdateFromReader(filter func(obj *unstructured.Unstruc
 			continue
 		}
 
+		span = k.startSpan("kubernetes.get", obj)
 		oldObj, err := ri.Get(name, metav1.GetOptions{})
+		if kapierrors.IsNotFound(err) {
+			span.Finish(nil)
+		} else {
+			span.Finish(err)
+		}
 		if err != nil {
 			if !kapierrors.IsNotFound(err) {
-				msg := fmt.Sprintf("failed to retrieve current configuration of the %s %s/%s: %v", kind, namespace, name, err)
-				glog.Errorln(msg)
-				return nil, fmt.Errorf(msg)
+				glog.Errorf("failed to retrieve current configuration of the %s %s/%s: %v", kind, namespace, name, err)
+				return nil, objectError(obj, err)
 			}
 
 			// create it because the resource is not existed
 			obj.SetResourceVersion("")
+			span = k.startSpan("kubernetes.create", obj)
 			_, err := ri.Create(obj)
+			span.Finish(err)
 			if err != nil {
 				glog.Infof("failed to create the %s resource %s/%s: %v", kind, namespace, name, err)
-				return nil, err
+				return nil, objectError(obj, err)
 			}
 			continue
 		}
 		// found the old resource, so we update it
 		obj.SetResourceVersion(oldObj.GetResourceVersion())
+		span = k.startSpan("kubernetes.update", obj)
 		_, err = ri.Update(obj)
+		span.Finish(err)
 		if err != nil {
 			glog.Errorf("failed to update the existed %s resource %s/%s, %v", kind, namespace, name, err)
-			return nil, err
+			return nil, objectError(obj, err)
 		}
 
 		needCreateResources[obj.GetNamespace()] = obj.GetName()
@@ -155,7 +239,7 @@ func (k *KubeCli) createOrUpdateFromReader(filter func(obj *unstructured.Unstruc
 	return needCreateResources, nil
 }
 
-func  (k *KubeCli) deleteFromReader(reader io.Reader) error {
+func (k *KubeCli) deleteFromReader(reader io.Reader) error {
 	decoder := yaml.NewYAMLOrJSONDecoder(reader, 4096)
 	for {
 		obj := &unstructured.Unstructured{}
@@ -169,22 +253,32 @@ func  (k *KubeCli) deleteFromReader(reader io.Reader) error {
 
 		// find the object's resource interface
 		gvk := obj.GroupVersionKind()
-		gvr, err := discoveryutil.ResourceForGVK(k.Client.Discovery(), gvk)
+		span := k.startSpan("kubernetes.discovery", obj)
+		gvr, err := k.resourceFor(gvk)
+		span.Finish(err)
 		if err != nil {
 			glog.Errorf("failed to discovery GVR for the resource %v: %v", gvk, err)
-			return err
+			return objectError(obj, err)
 		}
 
 		kind := obj.GetKind()
 		namespace := obj.GetNamespace()
 		name := obj.GetName()
 
-		ri := k.Client.Resource(gvr).Namespace(namespace)
-		err = ri.Delete(name, &metav1.DeleteOptions{})
+		// the dependents, e.g. the pods of a Job, are orphaned unless the propagation is set
+		propagation := metav1.DeletePropagationBackground
+		ri := k.Dynamic.Resource(gvr).Namespace(namespace)
+		span = k.startSpan("kubernetes.delete", obj)
+		err = ri.Delete(name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if kapierrors.IsNotFound(err) {
+			span.Finish(nil)
+		} else {
+			span.Finish(err)
+		}
 		if err != nil && !kapierrors.IsNotFound(err) {
 			glog.Infof("failed to delete the %s resource %s/%s: %v", kind, namespace, name, err)
-			return err
+			return objectError(obj, err)
 		}
 	}
 	return nil
-}
\ No newline at end of file
+}
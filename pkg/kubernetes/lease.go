@@ -0,0 +1,239 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	kapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var leaseResource = schema.GroupVersionResource{Group: "coordination.k8s.io", Version: "v1", Resource: "leases"}
+
+// LeaderElectionConfig configures the election of the replica running the background work.
+type LeaderElectionConfig struct {
+	// Namespace and Name of the coordination.k8s.io Lease
+	Namespace string
+	Name      string
+	// Identity of this replica in the lease, the pod name
+	Identity string
+	// LeaseDuration is how long the other replicas wait before taking over a lease not renewed
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader retries to renew before it gives up the leadership
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval of the attempts to acquire or renew the lease
+	RetryPeriod time.Duration
+}
+
+// LeaderElector elects a leader among the broker replicas with a Lease, only the leader runs the
+// background work while every replica serves the OSB api.
+type LeaderElector struct {
+	client Interface
+	config LeaderElectionConfig
+
+	// the lease as last observed and the local time it was observed at, the expiry is judged on
+	// the local clock so the replicas do not need synchronized clocks
+	observedHolder    string
+	observedRenewTime string
+	observedTime      time.Time
+}
+
+func NewLeaderElector(client Interface, config LeaderElectionConfig) (*LeaderElector, error) {
+	if config.Namespace == "" || config.Name == "" || config.Identity == "" {
+		return nil, fmt.Errorf("leader election requires the namespace, the name and the identity")
+	}
+	if config.RenewDeadline >= config.LeaseDuration || config.RetryPeriod >= config.RenewDeadline {
+		return nil, fmt.Errorf("leader election requires retry period < renew deadline < lease duration")
+	}
+
+	return &LeaderElector{
+		client: client,
+		config: config,
+	}, nil
+}
+
+// Run campaigns until ctx is done. The leader runs onStartedLeading with a context cancelled
+// when the leadership is lost, the lease is released on shutdown so another replica takes over
+// without waiting for it to expire.
+func (le *LeaderElector) Run(ctx context.Context, onStartedLeading func(ctx context.Context)) {
+	for {
+		if !le.acquire(ctx) {
+			return
+		}
+
+		glog.Infof("%s became the leader of lease %s/%s", le.config.Identity, le.config.Namespace, le.config.Name)
+		leaderCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			onStartedLeading(leaderCtx)
+		}()
+
+		le.renew(ctx)
+		cancel()
+		<-done
+
+		if ctx.Err() != nil {
+			le.release()
+			return
+		}
+		glog.Warningf("%s lost the leadership of lease %s/%s", le.config.Identity, le.config.Namespace, le.config.Name)
+	}
+}
+
+// acquire retries until the lease is held, false when ctx is done first.
+func (le *LeaderElector) acquire(ctx context.Context) bool {
+	ticker := time.NewTicker(le.config.RetryPeriod)
+	defer ticker.Stop()
+
+	for {
+		ok, err := le.tryAcquireOrRenew()
+		if err != nil {
+			glog.Errorf("failed to acquire lease %s/%s: %v", le.config.Namespace, le.config.Name, err)
+		}
+		if ok {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew keeps the lease until a renewal does not succeed within the renew deadline or ctx is done.
+func (le *LeaderElector) renew(ctx context.Context) {
+	ticker := time.NewTicker(le.config.RetryPeriod)
+	defer ticker.Stop()
+
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ok, err := le.tryAcquireOrRenew()
+		if err != nil {
+			glog.Errorf("failed to renew lease %s/%s: %v", le.config.Namespace, le.config.Name, err)
+		}
+		if ok {
+			lastRenew = time.Now()
+			continue
+		}
+		if err == nil || time.Since(lastRenew) > le.config.RenewDeadline {
+			return
+		}
+	}
+}
+
+func (le *LeaderElector) leases() dynamic.ResourceInterface {
+	return le.client.Resource(leaseResource).Namespace(le.config.Namespace)
+}
+
+// tryAcquireOrRenew takes the lease when it is free or expired and renews it when it is held
+// by this replica.
+func (le *LeaderElector) tryAcquireOrRenew() (bool, error) {
+	now := time.Now()
+	lease, err := le.leases().Get(le.config.Name, metav1.GetOptions{})
+	if err != nil {
+		if !kapierrors.IsNotFound(err) {
+			return false, err
+		}
+
+		lease = &unstructured.Unstructured{}
+		lease.SetAPIVersion("coordination.k8s.io/v1")
+		lease.SetKind("Lease")
+		lease.SetNamespace(le.config.Namespace)
+		lease.SetName(le.config.Name)
+		le.setHolder(lease, now, 0)
+
+		_, err = le.leases().Create(lease)
+		if err != nil {
+			return false, err
+		}
+		le.observe(le.config.Identity, formatMicroTime(now), now)
+		return true, nil
+	}
+
+	holder, _, _ := unstructured.NestedString(lease.Object, "spec", "holderIdentity")
+	renewTime, _, _ := unstructured.NestedString(lease.Object, "spec", "renewTime")
+	if holder != le.observedHolder || renewTime != le.observedRenewTime {
+		le.observe(holder, renewTime, now)
+	}
+
+	durationSeconds, _, _ := unstructured.NestedInt64(lease.Object, "spec", "leaseDurationSeconds")
+	expired := le.observedTime.Add(time.Duration(durationSeconds) * time.Second).Before(now)
+	if holder != "" && holder != le.config.Identity && !expired {
+		return false, nil
+	}
+
+	transitions, _, _ := unstructured.NestedInt64(lease.Object, "spec", "leaseTransitions")
+	if holder != le.config.Identity {
+		transitions++
+		le.setHolder(lease, now, transitions)
+	} else {
+		unstructured.SetNestedField(lease.Object, formatMicroTime(now), "spec", "renewTime")
+		unstructured.SetNestedField(lease.Object, int64(le.config.LeaseDuration/time.Second), "spec", "leaseDurationSeconds")
+	}
+
+	// the resource version of the get makes a concurrent update of another replica fail
+	_, err = le.leases().Update(lease)
+	if err != nil {
+		if kapierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	le.observe(le.config.Identity, formatMicroTime(now), now)
+	return true, nil
+}
+
+// release gives up the lease so the next leader does not wait for the lease duration.
+func (le *LeaderElector) release() {
+	lease, err := le.leases().Get(le.config.Name, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("failed to release lease %s/%s: %v", le.config.Namespace, le.config.Name, err)
+		return
+	}
+
+	holder, _, _ := unstructured.NestedString(lease.Object, "spec", "holderIdentity")
+	if holder != le.config.Identity {
+		return
+	}
+
+	unstructured.SetNestedField(lease.Object, "", "spec", "holderIdentity")
+	unstructured.SetNestedField(lease.Object, int64(1), "spec", "leaseDurationSeconds")
+	_, err = le.leases().Update(lease)
+	if err != nil {
+		glog.Errorf("failed to release lease %s/%s: %v", le.config.Namespace, le.config.Name, err)
+		return
+	}
+	glog.Infof("%s released lease %s/%s", le.config.Identity, le.config.Namespace, le.config.Name)
+}
+
+func (le *LeaderElector) setHolder(lease *unstructured.Unstructured, now time.Time, transitions int64) {
+	unstructured.SetNestedField(lease.Object, le.config.Identity, "spec", "holderIdentity")
+	unstructured.SetNestedField(lease.Object, int64(le.config.LeaseDuration/time.Second), "spec", "leaseDurationSeconds")
+	unstructured.SetNestedField(lease.Object, formatMicroTime(now), "spec", "acquireTime")
+	unstructured.SetNestedField(lease.Object, formatMicroTime(now), "spec", "renewTime")
+	unstructured.SetNestedField(lease.Object, transitions, "spec", "leaseTransitions")
+}
+
+func (le *LeaderElector) observe(holder, renewTime string, now time.Time) {
+	le.observedHolder = holder
+	le.observedRenewTime = renewTime
+	le.observedTime = now
+}
+
+func formatMicroTime(t time.Time) string {
+	return t.UTC().Format(metav1.RFC3339Micro)
+}
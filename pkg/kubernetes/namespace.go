@@ -0,0 +1,208 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	kapierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InstanceLabel is the label every kubernetes object of an instance carries.
+const InstanceLabel = "ruyiyun.servicebroker/instance"
+
+// Quota is the amount of resources a dedicated instance namespace may use.
+type Quota struct {
+	// cpu and memory of one container
+	CPU    resource.Quantity
+	Memory resource.Quantity
+	// storage of one persistent volume claim
+	Storage resource.Quantity
+	// number of pods and persistent volume claims in the namespace
+	Pods    int64
+	Volumes int64
+}
+
+// EnsureInstanceNamespace creates the dedicated namespace of an instance and applies the quota to it.
+// It is safe to call on an existing namespace, the quota and limit range are updated in place.
+func (k *KubeCli) EnsureInstanceNamespace(name, instanceId string, quota *Quota) (err error) {
+	k, span := k.startOperation("kubernetes.EnsureInstanceNamespace")
+	defer func() {
+		span.Finish(err)
+	}()
+
+	ns := &v1.Namespace{}
+	err = k.getObject(namespaceKind, "", name, ns)
+	if err != nil {
+		if !kapierrors.IsNotFound(err) {
+			return err
+		}
+
+		ns = &v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{InstanceLabel: instanceId},
+			},
+		}
+		err = k.createObject(namespaceKind, "", ns)
+		if err != nil {
+			glog.Errorf("failed to create the namespace %s: %v", name, err)
+			return err
+		}
+	} else if ns.Labels[InstanceLabel] != instanceId {
+		return fmt.Errorf("namespace %s exists and does not belong to instance %s", name, instanceId)
+	}
+
+	err = k.applyResourceQuota(name, instanceId, quota)
+	if err != nil {
+		glog.Errorf("failed to apply the resource quota of namespace %s: %v", name, err)
+		return err
+	}
+
+	err = k.applyLimitRange(name, instanceId, quota)
+	if err != nil {
+		glog.Errorf("failed to apply the limit range of namespace %s: %v", name, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteInstanceNamespace deletes the dedicated namespace of an instance together with everything left
+// in it, e.g. the persistent volume claims of its StatefulSets. It returns false when the namespace is
+// kept because it does not belong to the instance.
+func (k *KubeCli) DeleteInstanceNamespace(name, instanceId string) (_ bool, err error) {
+	k, span := k.startOperation("kubernetes.DeleteInstanceNamespace")
+	defer func() {
+		span.Finish(err)
+	}()
+
+	ns := &v1.Namespace{}
+	err = k.getObject(namespaceKind, "", name, ns)
+	if err != nil {
+		if kapierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	if ns.Labels[InstanceLabel] != instanceId {
+		glog.Warningf("namespace %s does not belong to instance %s, keep it", name, instanceId)
+		return false, nil
+	}
+
+	// the uid makes sure the namespace checked is the one deleted
+	err = k.deleteObject(namespaceKind, "", name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &ns.UID}})
+	if err != nil && !kapierrors.IsNotFound(err) {
+		glog.Errorf("failed to delete the namespace %s: %v", name, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// newResourceQuota limits the namespace to the pods of the instance and one pod of a Job, the broker
+// runs the Jobs of an instance one at a time.
+func newResourceQuota(namespace, instanceId string, quota *Quota) *v1.ResourceQuota {
+	multiply := func(q resource.Quantity, n int64) resource.Quantity {
+		return *resource.NewMilliQuantity(q.MilliValue()*n, q.Format)
+	}
+
+	cpu := multiply(quota.CPU, quota.Pods)
+	cpu.Add(resource.MustParse(JobCPU))
+	memory := multiply(quota.Memory, quota.Pods)
+	memory.Add(resource.MustParse(JobMemory))
+	storage := multiply(quota.Storage, quota.Volumes)
+
+	return &v1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespace,
+			Namespace: namespace,
+			Labels:    map[string]string{InstanceLabel: instanceId},
+		},
+		Spec: v1.ResourceQuotaSpec{
+			Hard: v1.ResourceList{
+				v1.ResourceRequestsCPU:            cpu,
+				v1.ResourceLimitsCPU:              cpu,
+				v1.ResourceRequestsMemory:         memory,
+				v1.ResourceLimitsMemory:           memory,
+				v1.ResourceRequestsStorage:        storage,
+				v1.ResourcePods:                   *resource.NewQuantity(quota.Pods+1, resource.DecimalSI),
+				v1.ResourcePersistentVolumeClaims: *resource.NewQuantity(quota.Volumes, resource.DecimalSI),
+			},
+		},
+	}
+}
+
+func (k *KubeCli) applyResourceQuota(namespace, instanceId string, quota *Quota) error {
+	rq := newResourceQuota(namespace, instanceId, quota)
+	old := &v1.ResourceQuota{}
+	err := k.getObject(resourceQuotaKind, namespace, rq.Name, old)
+	if err != nil {
+		if !kapierrors.IsNotFound(err) {
+			return err
+		}
+		return k.createObject(resourceQuotaKind, namespace, rq)
+	}
+
+	rq.ResourceVersion = old.ResourceVersion
+	return k.updateObject(resourceQuotaKind, namespace, rq)
+}
+
+// newLimitRange defaults the containers to the cpu and memory of the plan, the maximum admits the
+// containers of the Jobs too.
+func newLimitRange(namespace, instanceId string, quota *Quota) *v1.LimitRange {
+	container := v1.ResourceList{
+		v1.ResourceCPU:    quota.CPU,
+		v1.ResourceMemory: quota.Memory,
+	}
+	max := v1.ResourceList{
+		v1.ResourceCPU:    quota.CPU,
+		v1.ResourceMemory: quota.Memory,
+	}
+	if job := resource.MustParse(JobCPU); job.Cmp(quota.CPU) > 0 {
+		max[v1.ResourceCPU] = job
+	}
+	if job := resource.MustParse(JobMemory); job.Cmp(quota.Memory) > 0 {
+		max[v1.ResourceMemory] = job
+	}
+
+	return &v1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespace,
+			Namespace: namespace,
+			Labels:    map[string]string{InstanceLabel: instanceId},
+		},
+		Spec: v1.LimitRangeSpec{
+			Limits: []v1.LimitRangeItem{
+				{
+					Type:           v1.LimitTypeContainer,
+					Max:            max,
+					Default:        container,
+					DefaultRequest: container,
+				},
+				{
+					Type: v1.LimitTypePersistentVolumeClaim,
+					Max: v1.ResourceList{
+						v1.ResourceStorage: quota.Storage,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (k *KubeCli) applyLimitRange(namespace, instanceId string, quota *Quota) error {
+	lr := newLimitRange(namespace, instanceId, quota)
+	old := &v1.LimitRange{}
+	err := k.getObject(limitRangeKind, namespace, lr.Name, old)
+	if err != nil {
+		if !kapierrors.IsNotFound(err) {
+			return err
+		}
+		return k.createObject(limitRangeKind, namespace, lr)
+	}
+
+	lr.ResourceVersion = old.ResourceVersion
+	return k.updateObject(limitRangeKind, namespace, lr)
+}
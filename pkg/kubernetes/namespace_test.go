@@ -0,0 +1,49 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestQuotaLeavesRoomForJob(t *testing.T) {
+	for _, quota := range []*Quota{
+		{CPU: resource.MustParse("1"), Memory: resource.MustParse("1Gi"), Storage: resource.MustParse("10Gi"), Pods: 3, Volumes: 6},
+		{CPU: resource.MustParse("50m"), Memory: resource.MustParse("64Mi"), Storage: resource.MustParse("1Gi"), Pods: 1, Volumes: 2},
+	} {
+		hard := newResourceQuota("ns", "instance", quota).Spec.Hard
+
+		// the pods of the instance at the size of the plan and a pod of a Job
+		used := map[v1.ResourceName]resource.Quantity{
+			v1.ResourcePods:           *resource.NewQuantity(quota.Pods+1, resource.DecimalSI),
+			v1.ResourceRequestsCPU:    resource.MustParse(JobCPU),
+			v1.ResourceLimitsCPU:      resource.MustParse(JobCPU),
+			v1.ResourceRequestsMemory: resource.MustParse(JobMemory),
+			v1.ResourceLimitsMemory:   resource.MustParse(JobMemory),
+		}
+		for i := int64(0); i < quota.Pods; i++ {
+			for _, name := range []v1.ResourceName{v1.ResourceRequestsCPU, v1.ResourceLimitsCPU} {
+				q := used[name]
+				q.Add(quota.CPU)
+				used[name] = q
+			}
+			for _, name := range []v1.ResourceName{v1.ResourceRequestsMemory, v1.ResourceLimitsMemory} {
+				q := used[name]
+				q.Add(quota.Memory)
+				used[name] = q
+			}
+		}
+		for name, q := range used {
+			limit := hard[name]
+			if q.Cmp(limit) > 0 {
+				t.Errorf("expect room for a job beside %d pods of %s cpu, %s is %s of %s", quota.Pods, quota.CPU.String(), name, q.String(), limit.String())
+			}
+		}
+
+		max := newLimitRange("ns", "instance", quota).Spec.Limits[0].Max
+		if cpu, memory := max[v1.ResourceCPU], max[v1.ResourceMemory]; cpu.Cmp(resource.MustParse(JobCPU)) < 0 || memory.Cmp(resource.MustParse(JobMemory)) < 0 {
+			t.Errorf("expect the limit range to admit the containers of a job, its maximum is %v", max)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package kubernetes
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	discoveryutil "kmodules.xyz/client-go/discovery"
+)
+
+// The typed objects are sent through the dynamic client as well, so every api call of KubeCli goes
+// through Dynamic and Mapper, which the fake package replaces for the tests.
+
+var (
+	namespaceKind     = v1.SchemeGroupVersion.WithKind("Namespace")
+	secretKind        = v1.SchemeGroupVersion.WithKind("Secret")
+	podKind           = v1.SchemeGroupVersion.WithKind("Pod")
+	resourceQuotaKind = v1.SchemeGroupVersion.WithKind("ResourceQuota")
+	limitRangeKind    = v1.SchemeGroupVersion.WithKind("LimitRange")
+	jobKind           = batchv1.SchemeGroupVersion.WithKind("Job")
+)
+
+// resourceFor finds the resource of the kind, by the Mapper when it is set and by the discovery of
+// the Client otherwise.
+func (k *KubeCli) resourceFor(gvk schema.GroupVersionKind) (schema.GroupVersionResource, error) {
+	if k.Mapper == nil {
+		return discoveryutil.ResourceForGVK(k.Client.Discovery(), gvk)
+	}
+	mapping, err := k.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	return mapping.Resource, nil
+}
+
+func (k *KubeCli) resourceClient(gvk schema.GroupVersionKind, namespace string) (dynamic.ResourceInterface, error) {
+	gvr, err := k.resourceFor(gvk)
+	if err != nil {
+		return nil, err
+	}
+	return k.Dynamic.Resource(gvr).Namespace(namespace), nil
+}
+
+func (k *KubeCli) getObject(gvk schema.GroupVersionKind, namespace, name string, obj interface{}) error {
+	client, err := k.resourceClient(gvk, namespace)
+	if err != nil {
+		return err
+	}
+	u, err := client.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj)
+}
+
+func (k *KubeCli) listObjects(gvk schema.GroupVersionKind, namespace string, options metav1.ListOptions, list interface{}) error {
+	client, err := k.resourceClient(gvk, namespace)
+	if err != nil {
+		return err
+	}
+	u, err := client.List(options)
+	if err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), list)
+}
+
+func (k *KubeCli) createObject(gvk schema.GroupVersionKind, namespace string, obj interface{}) error {
+	client, u, err := k.unstructuredObject(gvk, namespace, obj)
+	if err != nil {
+		return err
+	}
+	_, err = client.Create(u)
+	return err
+}
+
+func (k *KubeCli) updateObject(gvk schema.GroupVersionKind, namespace string, obj interface{}) error {
+	client, u, err := k.unstructuredObject(gvk, namespace, obj)
+	if err != nil {
+		return err
+	}
+	_, err = client.Update(u)
+	return err
+}
+
+func (k *KubeCli) deleteObject(gvk schema.GroupVersionKind, namespace, name string, options *metav1.DeleteOptions) error {
+	client, err := k.resourceClient(gvk, namespace)
+	if err != nil {
+		return err
+	}
+	return client.Delete(name, options)
+}
+
+// unstructuredObject converts the typed object, whose kind is left empty by the typed clients.
+func (k *KubeCli) unstructuredObject(gvk schema.GroupVersionKind, namespace string, obj interface{}) (dynamic.ResourceInterface, *unstructured.Unstructured, error) {
+	client, err := k.resourceClient(gvk, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	return client, u, nil
+}
@@ -0,0 +1,142 @@
+package kubernetes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	kapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BindingLabel marks the Secrets holding the credentials of a binding, the label value is the
+// LabelValue of the binding id.
+const BindingLabel = "ruyiyun.servicebroker/binding"
+
+// LabelValue returns the id when it is a valid label value. Otherwise its letters, digits, '-', '_'
+// and '.' are kept, at most 46 of them, and the first 16 hex digits of its sha256 are appended.
+func LabelValue(id string) string {
+	if validLabelValue(id) {
+		return id
+	}
+	kept := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return -1
+	}, id)
+	if len(kept) > 46 {
+		kept = kept[:46]
+	}
+	kept = strings.Trim(kept, "-_.")
+	sum := sha256.Sum256([]byte(id))
+	if kept == "" {
+		return hex.EncodeToString(sum[:8])
+	}
+	return kept + "-" + hex.EncodeToString(sum[:8])
+}
+
+func validLabelValue(value string) bool {
+	if len(value) > 63 {
+		return false
+	}
+	for i, r := range value {
+		alphanumeric := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+		if !alphanumeric && ((r != '-' && r != '_' && r != '.') || i == 0 || i == len(value)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// ownedBy tells whether the Secret carries the instance and the binding labels of owner.
+func ownedBy(secret *v1.Secret, owner map[string]string) bool {
+	return secret.Labels[InstanceLabel] == owner[InstanceLabel] && secret.Labels[BindingLabel] == owner[BindingLabel]
+}
+
+// ApplySecret creates the Secret or replaces the labels and the data of the existing one, which must
+// carry the same instance and binding labels.
+func (k *KubeCli) ApplySecret(secret *v1.Secret) (err error) {
+	k, span := k.startOperation("kubernetes.ApplySecret")
+	defer func() {
+		span.Finish(err)
+	}()
+
+	existing := &v1.Secret{}
+	err = k.getObject(secretKind, secret.Namespace, secret.Name, existing)
+	if kapierrors.IsNotFound(err) {
+		err = k.createObject(secretKind, secret.Namespace, secret)
+		if err != nil {
+			glog.Errorf("failed to create the secret %s/%s: %v", secret.Namespace, secret.Name, err)
+		}
+		return err
+	}
+	if err != nil {
+		glog.Errorf("failed to get the secret %s/%s: %v", secret.Namespace, secret.Name, err)
+		return err
+	}
+
+	if !ownedBy(existing, secret.Labels) {
+		err = fmt.Errorf("the secret %s/%s exists and belongs to another binding", secret.Namespace, secret.Name)
+		glog.Errorf("failed to update the secret: %v", err)
+		return err
+	}
+
+	existing.Labels = secret.Labels
+	existing.Type = secret.Type
+	existing.Data = secret.Data
+	err = k.updateObject(secretKind, secret.Namespace, existing)
+	if err != nil {
+		glog.Errorf("failed to update the secret %s/%s: %v", secret.Namespace, secret.Name, err)
+	}
+	return err
+}
+
+// DeleteSecret deletes the Secret when it carries the instance and the binding labels of owner, a
+// missing one is not an error and one of another binding is left alone.
+func (k *KubeCli) DeleteSecret(namespace, name string, owner map[string]string) (err error) {
+	k, span := k.startOperation("kubernetes.DeleteSecret")
+	defer func() {
+		span.Finish(err)
+	}()
+
+	existing := &v1.Secret{}
+	err = k.getObject(secretKind, namespace, name, existing)
+	if kapierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		glog.Errorf("failed to get the secret %s/%s: %v", namespace, name, err)
+		return err
+	}
+	if !ownedBy(existing, owner) {
+		glog.Warningf("the secret %s/%s belongs to another binding, it is not deleted", namespace, name)
+		return nil
+	}
+
+	// the uid makes sure the Secret checked is the one deleted
+	err = k.deleteObject(secretKind, namespace, name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &existing.UID}})
+	if kapierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		glog.Errorf("failed to delete the secret %s/%s: %v", namespace, name, err)
+	}
+	return err
+}
+
+// GetSecretData returns the value of the key of the Secret.
+func GetSecretData(client Interface, namespace, name, key string) ([]byte, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	data, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("the secret %s/%s has no key %s", namespace, name, key)
+	}
+	return data, nil
+}
@@ -0,0 +1,297 @@
+// Package lint checks the service templates and the generated catalogs against the conventions
+// of the broker before they are embedded into pkg/asset.
+package lint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/arugaki/osb-starter-pack/pkg/broker"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/util"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// the values the plans are rendered with
+const (
+	lintNamespace    = "lint-namespace"
+	lintInstanceName = "lint"
+	lintInstanceId   = "lint-instance"
+)
+
+// clusterScopedKinds are the kinds an instance must not create, they outlive its namespace.
+var clusterScopedKinds = map[string]bool{
+	"Namespace":                      true,
+	"Node":                           true,
+	"PersistentVolume":               true,
+	"StorageClass":                   true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"CustomResourceDefinition":       true,
+	"PriorityClass":                  true,
+	"PodSecurityPolicy":              true,
+	"APIService":                     true,
+	"MutatingWebhookConfiguration":   true,
+	"ValidatingWebhookConfiguration": true,
+}
+
+// podSpecPaths locate the pod spec of the workload kinds.
+var podSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// Diagnostic is a violation at a line of a template or a catalog.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// Lint checks the catalogs and the apply templates under dir, catalog/<service>_generated.json
+// and apply/<service>.yaml. Every plan is rendered with its schema defaults and the objects
+// are checked, the error is for a dir which can not be loaded.
+func Lint(dir string) ([]Diagnostic, error) {
+	renderer, err := broker.NewTemplateRenderer(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var diagnostics []Diagnostic
+	for _, service := range renderer.Catalog() {
+		service := service
+		catalogFile := filepath.Join(dir, "catalog", service.Name+"_generated.json")
+		templateFile := filepath.Join(dir, "apply", service.Name+".yaml")
+
+		source, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			diagnostics = append(diagnostics, Diagnostic{catalogFile, 1, fmt.Sprintf("service %s has no apply template: %v", service.Name, err)})
+			continue
+		}
+
+		l := &linter{file: templateFile, source: string(source)}
+		if l.parse() {
+			for _, plan := range service.Plans {
+				plan := plan
+				l.lintPlan(renderer, &service, &plan)
+			}
+		}
+		diagnostics = append(diagnostics, l.diagnostics...)
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].File != diagnostics[j].File {
+			return diagnostics[i].File < diagnostics[j].File
+		}
+		return diagnostics[i].Line < diagnostics[j].Line
+	})
+	return dedup(diagnostics), nil
+}
+
+// the same violation is found in every plan
+func dedup(diagnostics []Diagnostic) []Diagnostic {
+	seen := make(map[Diagnostic]bool)
+	var unique []Diagnostic
+	for _, d := range diagnostics {
+		if !seen[d] {
+			seen[d] = true
+			unique = append(unique, d)
+		}
+	}
+	return unique
+}
+
+type linter struct {
+	file        string
+	source      string
+	diagnostics []Diagnostic
+}
+
+func (l *linter) report(line int, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{l.file, line, fmt.Sprintf(format, args...)})
+}
+
+var templateErrorLine = regexp.MustCompile(`:(\d+):`)
+
+// errorLine returns the line of a text/template error, 1 when it has none.
+func errorLine(err error) int {
+	if m := templateErrorLine.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return line
+	}
+	return 1
+}
+
+func (l *linter) parse() bool {
+	_, err := template.New(l.file).Funcs(util.TemplateFuncs).Parse(l.source)
+	if err != nil {
+		l.report(errorLine(err), "%v", err)
+		return false
+	}
+	return true
+}
+
+// lineOf returns the first line containing text from the line start on, start when there is none.
+func (l *linter) lineOf(start int, text string) int {
+	lines := strings.Split(l.source, "\n")
+	for i := start - 1; i >= 0 && i < len(lines); i++ {
+		if strings.Contains(lines[i], text) {
+			return i + 1
+		}
+	}
+	return start
+}
+
+// documents splits a yaml stream on the --- separators, with the first line of each document.
+func documents(s string) ([]string, []int) {
+	var docs []string
+	var starts []int
+	var current []string
+	start := 1
+	for i, line := range strings.Split(s, "\n") {
+		if strings.TrimRight(line, " \t\r") == "---" {
+			docs = append(docs, strings.Join(current, "\n"))
+			starts = append(starts, start)
+			current = nil
+			start = i + 2
+			continue
+		}
+		current = append(current, line)
+	}
+	docs = append(docs, strings.Join(current, "\n"))
+	starts = append(starts, start)
+	return docs, starts
+}
+
+// lintParameters reports the parameters of the plan put into the yaml as they are, they come from
+// the tenants and only quote keeps them inside a yaml string.
+func (l *linter) lintParameters(plan *osb.Plan) {
+	if plan.Schemas == nil || plan.Schemas.ServiceInstance == nil || plan.Schemas.ServiceInstance.Create == nil {
+		return
+	}
+	properties, _ := plan.Schemas.ServiceInstance.Create.Parameters.(map[string]interface{})
+	lines := strings.Split(l.source, "\n")
+	for name := range properties {
+		unquoted := regexp.MustCompile(`\{\{-?\s*\.` + regexp.QuoteMeta(name) + `\s*-?\}\}`)
+		for i, line := range lines {
+			if unquoted.MatchString(line) {
+				l.report(i+1, "parameter %s is not quoted, write {{ quote .%s }}", name, name)
+			}
+		}
+	}
+}
+
+func (l *linter) lintPlan(renderer *broker.BusinessLogic, service *osb.Service, plan *osb.Plan) {
+	l.lintParameters(plan)
+
+	rendered, unresolved, err := renderer.RenderPlan(service, plan, lintNamespace, lintInstanceName, lintInstanceId, nil)
+	if err != nil {
+		l.report(errorLine(err), "plan %s does not render: %v", plan.Name, err)
+		return
+	}
+
+	for _, field := range unresolved {
+		l.report(l.lineOf(1, "."+field), "variable %s is not covered by the catalog properties of plan %s", field, plan.Name)
+	}
+	if len(unresolved) != 0 {
+		return
+	}
+
+	// the documents of the source and of the rendered template match unless a conditional
+	// block holds a separator, the objects are then reported at the first line
+	renderedDocs, _ := documents(rendered)
+	_, starts := documents(l.source)
+	for i, doc := range renderedDocs {
+		start := 1
+		if len(starts) == len(renderedDocs) {
+			start = starts[i]
+		}
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+
+		data, err := yaml.ToJSON([]byte(doc))
+		if err != nil {
+			l.report(start, "plan %s renders invalid yaml: %v", plan.Name, err)
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		err = obj.UnmarshalJSON(data)
+		if err != nil {
+			l.report(start, "plan %s renders an invalid kubernetes object: %v", plan.Name, err)
+			continue
+		}
+		l.lintObject(obj, start)
+	}
+}
+
+func (l *linter) lintObject(obj *unstructured.Unstructured, start int) {
+	kind := obj.GetKind()
+	name := kind + " " + obj.GetName()
+
+	if clusterScopedKinds[kind] {
+		l.report(l.lineOf(start, "kind:"), "%s is cluster scoped, an instance may only create namespaced objects", name)
+		return
+	}
+
+	if obj.GetNamespace() != lintNamespace {
+		l.report(l.lineOf(start, "namespace:"), "%s must be in the namespace {{ .Namespace }}", name)
+	}
+
+	if obj.GetLabels()[kubernetes.InstanceLabel] != lintInstanceId {
+		l.report(l.lineOf(start, "labels:"), "%s must have the label %s: {{ .InstanceId }}", name, kubernetes.InstanceLabel)
+	}
+
+	if kind == "PersistentVolumeClaim" {
+		storage, _, _ := unstructured.NestedString(obj.Object, "spec", "resources", "requests", "storage")
+		if storage == "" {
+			l.report(l.lineOf(start, "resources:"), "%s must request storage", name)
+		}
+	}
+
+	podSpecPath, ok := podSpecPaths[kind]
+	if !ok {
+		return
+	}
+
+	// the pods are found by the label when the state of the instance is checked
+	if kind != "Pod" {
+		templatePath := append(append([]string{}, podSpecPath[:len(podSpecPath)-1]...), "metadata", "labels")
+		labels, _, _ := unstructured.NestedStringMap(obj.Object, templatePath...)
+		if labels[kubernetes.InstanceLabel] != lintInstanceId {
+			l.report(l.lineOf(start, "template:"), "the pods of %s must have the label %s: {{ .InstanceId }}", name, kubernetes.InstanceLabel)
+		}
+	}
+
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _, _ := unstructured.NestedSlice(obj.Object, append(append([]string{}, podSpecPath...), field)...)
+		for _, c := range containers {
+			container, _ := c.(map[string]interface{})
+			containerName, _, _ := unstructured.NestedString(container, "name")
+			requests, _, _ := unstructured.NestedMap(container, "resources", "requests")
+			for _, resource := range []string{"cpu", "memory"} {
+				if _, ok := requests[resource]; !ok {
+					l.report(l.lineOf(l.lineOf(start, "name: "+containerName), "resources:"),
+						"container %s of %s must request %s", containerName, name, resource)
+				}
+			}
+		}
+	}
+}
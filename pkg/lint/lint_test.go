@@ -0,0 +1,34 @@
+package lint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLintAssetTemplates(t *testing.T) {
+	diagnostics, err := Lint("../asset/template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diagnostics {
+		t.Error(d)
+	}
+}
+
+func TestLintReportsLines(t *testing.T) {
+	diagnostics, err := Lint("testdata/bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []int
+	for _, d := range diagnostics {
+		lines = append(lines, d.Line)
+	}
+	// the cluster scoped namespace, the namespace, the pod labels, the cpu and memory requests and
+	// the unquoted parameter
+	expect := []int{2, 10, 14, 20, 20, 24}
+	if !reflect.DeepEqual(lines, expect) {
+		t.Fatalf("expect diagnostics at lines %v, got %v", expect, diagnostics)
+	}
+}
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/golang/glog"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "osb_broker"
+
+// stages of an operation
+const (
+	StageRender    = "render"
+	StageApply     = "apply"
+	StageReadiness = "readiness"
+	StageTotal     = "total"
+)
+
+// BrokerMetricsCollector collects the metrics of the broker domain, next to the
+// request counters of osb-broker-lib.
+type BrokerMetricsCollector struct {
+	OperationDuration  *prom.HistogramVec
+	KubernetesErrors   *prom.CounterVec
+	DBDuration         *prom.HistogramVec
+	BindingOperations  *prom.CounterVec
+	instances          *prom.Desc
+	instanceCountsFunc func() ([]*dao.InstanceCount, error)
+	bindings           *prom.Desc
+	bindingCountsFunc  func() ([]*dao.BindingCount, error)
+}
+
+func New() *BrokerMetricsCollector {
+	return &BrokerMetricsCollector{
+		OperationDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of the stages of provision, update and deprovision operations.",
+			Buckets:   prom.ExponentialBuckets(0.01, 2, 16),
+		}, []string{"operation", "stage"}),
+		KubernetesErrors: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "kubernetes_errors_total",
+			Help:      "Total amount of errors applying objects to kubernetes.",
+		}, []string{"kind", "reason"}),
+		DBDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_duration_seconds",
+			Help:      "Duration of the queries to the broker database.",
+			Buckets:   prom.DefBuckets,
+		}, []string{"query"}),
+		BindingOperations: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "binding_operations_total",
+			Help:      "Total amount of bind and unbind operations.",
+		}, []string{"service", "operation", "result"}),
+		instances: prom.NewDesc(
+			prom.BuildFQName(namespace, "", "instances"),
+			"Number of service instances.",
+			[]string{"service", "plan", "state"}, nil),
+		bindings: prom.NewDesc(
+			prom.BuildFQName(namespace, "", "bindings"),
+			"Number of service bindings.",
+			[]string{"service", "plan", "state"}, nil),
+	}
+}
+
+// SetInstanceCountsFunc sets the function queried for the instance counts on every collection.
+func (c *BrokerMetricsCollector) SetInstanceCountsFunc(f func() ([]*dao.InstanceCount, error)) {
+	c.instanceCountsFunc = f
+}
+
+// SetBindingCountsFunc sets the function queried for the binding counts on every collection.
+func (c *BrokerMetricsCollector) SetBindingCountsFunc(f func() ([]*dao.BindingCount, error)) {
+	c.bindingCountsFunc = f
+}
+
+// ObserveStage records the duration of a stage of an operation since start.
+func (c *BrokerMetricsCollector) ObserveStage(operation, stage string, start time.Time) {
+	c.OperationDuration.WithLabelValues(operation, stage).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDB records the duration of a database query since start.
+func (c *BrokerMetricsCollector) ObserveDB(query string, start time.Time) {
+	c.DBDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
+
+func (c *BrokerMetricsCollector) Describe(ch chan<- *prom.Desc) {
+	c.OperationDuration.Describe(ch)
+	c.KubernetesErrors.Describe(ch)
+	c.DBDuration.Describe(ch)
+	c.BindingOperations.Describe(ch)
+	ch <- c.instances
+	ch <- c.bindings
+}
+
+func (c *BrokerMetricsCollector) Collect(ch chan<- prom.Metric) {
+	c.OperationDuration.Collect(ch)
+	c.KubernetesErrors.Collect(ch)
+	c.DBDuration.Collect(ch)
+	c.BindingOperations.Collect(ch)
+
+	c.collectInstances(ch)
+	c.collectBindings(ch)
+}
+
+func (c *BrokerMetricsCollector) collectInstances(ch chan<- prom.Metric) {
+	if c.instanceCountsFunc == nil {
+		return
+	}
+
+	counts, err := c.instanceCountsFunc()
+	if err != nil {
+		glog.Errorf("count instances for metrics failed, err is %+v", err)
+		return
+	}
+	for _, count := range counts {
+		ch <- prom.MustNewConstMetric(c.instances, prom.GaugeValue, float64(count.Count),
+			count.ServiceName, count.PlanID, count.State)
+	}
+}
+
+func (c *BrokerMetricsCollector) collectBindings(ch chan<- prom.Metric) {
+	if c.bindingCountsFunc == nil {
+		return
+	}
+
+	counts, err := c.bindingCountsFunc()
+	if err != nil {
+		glog.Errorf("count bindings for metrics failed, err is %+v", err)
+		return
+	}
+	for _, count := range counts {
+		ch <- prom.MustNewConstMetric(c.bindings, prom.GaugeValue, float64(count.Count),
+			count.ServiceName, count.PlanID, count.State)
+	}
+}
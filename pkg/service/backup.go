@@ -0,0 +1,52 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+)
+
+// DefaultBackupImage is the image of the S3 client the backup and restore Jobs run.
+const DefaultBackupImage = "minio/mc"
+
+// BackupTarget is the S3 compatible storage, e.g. a MinIO, the backups are copied to.
+type BackupTarget struct {
+	// Endpoint is the url of the storage, e.g. http://minio.minio:9000
+	Endpoint  string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Image is the image of the S3 client, DefaultBackupImage when empty
+	Image string
+}
+
+// Backuper is implemented by the services whose instances can be backed up and restored. The
+// broker runs the Jobs it renders and tracks them by kubernetes.BackupLabel and kubernetes.RestoreLabel.
+type Backuper interface {
+	// BackupJobs renders the Jobs copying the data of the instance to the object key of the target.
+	BackupJobs(instance *dao.Instance, backupId, key string, target *BackupTarget) (string, error)
+	// RestoreJobs renders the Jobs replacing the data of the instance by the object of the key, they
+	// run while the instance is scaled to zero.
+	RestoreJobs(instance *dao.Instance, backupId, key string, target *BackupTarget) (string, error)
+}
+
+// MCHost returns the MC_HOST_<alias> value of the target, the url of the storage with the keys.
+func (t *BackupTarget) MCHost() (string, error) {
+	u, err := url.Parse(t.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("backup endpoint %q is not an url", t.Endpoint)
+	}
+	u.User = url.UserPassword(t.AccessKey, t.SecretKey)
+	return u.String(), nil
+}
+
+func (t *BackupTarget) image() string {
+	if t.Image == "" {
+		return DefaultBackupImage
+	}
+	return t.Image
+}
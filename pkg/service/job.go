@@ -0,0 +1,67 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/golang/glog"
+)
+
+var (
+	// jobTimeout bounds how long runJobs waits for its Jobs.
+	jobTimeout = 2 * time.Minute
+	jobPoll    = 2 * time.Second
+)
+
+// jobFuncs give the templates of the Jobs the resources of their containers, the dedicated namespace
+// of an instance has no room for the containers defaulted to the size of the instance.
+var jobFuncs = template.FuncMap{
+	"jobResources": func() string {
+		return fmt.Sprintf(`{"requests": {"cpu": %q, "memory": %q}, "limits": {"cpu": %q, "memory": %q}}`,
+			kubernetes.JobCPU, kubernetes.JobMemory, kubernetes.JobCPU, kubernetes.JobMemory)
+	},
+}
+
+// newJobID returns a random id which is valid in the names of the kubernetes objects. The templates
+// quote it in the labels, an id of digits only is a number in yaml.
+func newJobID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// runJobs creates the objects of the manifests, waits until the Jobs labelled with kubernetes.JobLabel
+// and the id complete and deletes the objects again, whatever the result.
+func runJobs(cluster kubernetes.Cluster, namespace, id, manifests string) error {
+	_, err := cluster.CreateInstance(manifests)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := cluster.DeleteInstance(manifests)
+		if err != nil {
+			glog.Errorf("delete the objects of job %s failed, err is %+v", id, err)
+		}
+	}()
+
+	selector := kubernetes.JobLabel + "=" + id
+	deadline := time.Now().Add(jobTimeout)
+	for {
+		_, succeeded, failed, err := cluster.CheckJobs(namespace, selector)
+		switch {
+		case err != nil:
+			return err
+		case succeeded:
+			return nil
+		case failed:
+			return fmt.Errorf("job %s failed", id)
+		case time.Now().After(deadline):
+			return fmt.Errorf("job %s is not complete after %v", id, jobTimeout)
+		}
+		time.Sleep(jobPoll)
+	}
+}
@@ -6,18 +6,26 @@ import (
 	"github.com/pmorie/go-open-service-broker-client/v2"
 )
 
+// ParameterError is a parameter of a request the service rejects, the broker returns it to the platform.
+type ParameterError string
+
+func (e ParameterError) Error() string {
+	return string(e)
+}
+
 type Service interface {
 	// 返回该服务的名字, 与模版中的一致
 	Name() string
-	// 根据参数替换go template 中的变量
-	// 替换 plan.parametes
-	ApplyParameters(template string, params map[string]interface{}) (string, error)
-	// 替换 plan.bulletes.quota
-	ApplyPlan(template string, plan *v2.Plan) (string, error)
-	// 在部署了 service 之后执行, kubeService 为已部署的 service namespace-serviceName 映射
-	ApplySpecial(template string, kubeServices map[string]string, Client kubernetes.Interface) (string, error)
+	// 根据参数返回 go template 中的变量, 参数已按 plan 的 create schema 检查, 均为标量
+	// 模版只渲染一次, 变量的值不会再被当作模版解析, 字符串须在模版中用 quote 引用
+	ParameterValues(params map[string]interface{}) (map[string]interface{}, error)
+	// 返回 plan.bulletes.quota 对应的变量
+	PlanValues(plan *v2.Plan) (map[string]interface{}, error)
+	// 在部署了 service 之后执行, 返回其余的变量, manifests 为用其他变量渲染的模版
+	// kubeService 为已部署的 service namespace-serviceName 映射
+	SpecialValues(manifests string, kubeServices map[string]string, cluster kubernetes.Cluster) (map[string]interface{}, error)
 	// 得到服务 web console 的 url
-	GetDashboardURL(params map[string]interface{}, kubeServices map[string]string, kcl kubernetes.Interface) (string, error)
+	GetDashboardURL(params map[string]interface{}, kubeServices map[string]string, cluster kubernetes.Cluster) (string, error)
 	// 自定义在删除kubernetes的资源前的操作
 	BeforeKubeDelete(instance *dao.Instance) error
 	// 自定义在删除kubernetes的资源后的操作
@@ -25,6 +33,24 @@ type Service interface {
 	// 自定义服务创建成功的检查
 	LastStateCheck(instance *dao.Instance) (bool, bool, bool, error)
 
-	BindInstance(request *v2.BindRequest) (map[string]interface{}, error)
-	UnbindInstance(request *v2.UnbindRequest) error
-}
\ No newline at end of file
+	// 为绑定签发凭据, 参数错误时返回 ParameterError
+	BindInstance(instance *dao.Instance, request *v2.BindRequest, cluster kubernetes.Cluster) (map[string]interface{}, error)
+	// 撤销绑定的凭据, credentials 为绑定当前的凭据
+	UnbindInstance(instance *dao.Instance, binding *dao.Binding, credentials map[string]interface{}, cluster kubernetes.Cluster) error
+	// 为绑定签发新的凭据, 旧凭据在 RevokeBindingCredentials 之前仍然有效
+	RotateBinding(instance *dao.Instance, binding *dao.Binding, credentials map[string]interface{}, cluster kubernetes.Cluster) (map[string]interface{}, error)
+	// 撤销绑定轮换前的凭据 previous, current 为轮换后的凭据
+	RevokeBindingCredentials(instance *dao.Instance, binding *dao.Binding, current, previous map[string]interface{}, cluster kubernetes.Cluster) error
+}
+
+// BindParameterValidator 由有绑定参数的服务实现, 在接受绑定之前同步检查参数, 异步绑定的参数错误也能返回 400
+type BindParameterValidator interface {
+	// 检查绑定的参数, 参数错误时返回 ParameterError
+	ValidateBindParameters(params map[string]interface{}) error
+}
+
+// AsyncBinder 由签发凭据耗时较长的服务实现, 其绑定与解绑在后台执行, 平台须接受异步操作
+type AsyncBinder interface {
+	// 绑定与解绑是否需要异步执行
+	AsyncBindings() bool
+}
@@ -1,30 +1,80 @@
 package service
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
 	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/util"
 	"github.com/pmorie/go-open-service-broker-client/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
 )
 
-type ZookeeperService struct {}
-
+type ZookeeperService struct{}
 
 func (z *ZookeeperService) Name() string {
 	return "zookeeper"
 }
 
-func (z *ZookeeperService) ApplyParameters(template string, params map[string]interface{}) (string, error) {
-	return "", nil
+// ParameterValues are the parameters as they are, the template quotes them.
+func (z *ZookeeperService) ParameterValues(params map[string]interface{}) (map[string]interface{}, error) {
+	data := make(map[string]interface{}, len(params))
+	for name, value := range params {
+		data[name] = value
+	}
+	return data, nil
 }
 
-func (z *ZookeeperService) ApplyPlan(template string, plan *v2.Plan) (string, error) {
-	return "", nil
+func (z *ZookeeperService) PlanValues(plan *v2.Plan) (map[string]interface{}, error) {
+	cpu, memory, disk, err := util.GetQuotaFromPlan(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"PLAN_REQUEST_CPU":    cpu,
+		"PLAN_LIMIT_CPU":      cpu,
+		"PLAN_REQUEST_MEMORY": memory,
+		"PLAN_LIMIT_MEMORY":   memory,
+		"PLAN_STORAGE_SIZE":   disk,
+	}, nil
 }
 
-func (z *ZookeeperService) ApplySpecial(template string, kubeServices map[string]string) (string, error) {
-	return "", nil
+// SpecialValues sets the peers ZookeeperNN to the dns names of the peer services of the manifests,
+// the names are known before the services are created so a dry run renders the same.
+func (z *ZookeeperService) SpecialValues(manifests string, kubeServices map[string]string, cluster kubernetes.Cluster) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifests), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(obj)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if obj.GetKind() != "Service" {
+			continue
+		}
+
+		// <instance name>-zookeeper01 is the peer service, <instance name>-zookeeper01-open the client one
+		name := obj.GetName()
+		i := strings.LastIndex(name, "-zookeeper")
+		if i < 0 || strings.HasSuffix(name, "-open") {
+			continue
+		}
+		peer := "Zookeeper" + name[i+len("-zookeeper"):]
+		data[peer] = fmt.Sprintf("%s.%s.svc", name, obj.GetNamespace())
+	}
+	return data, nil
 }
 
-func (z *ZookeeperService) GetDashboardURL(params map[string]interface{}, kubeServices map[string]string) (string, error) {
+func (z *ZookeeperService) GetDashboardURL(params map[string]interface{}, kubeServices map[string]string, cluster kubernetes.Cluster) (string, error) {
 	return "", nil
 }
 
@@ -40,10 +90,130 @@ func (z *ZookeeperService) LastStateCheck(instance *dao.Instance) (bool, bool, b
 	return false, false, false, nil
 }
 
-func (z *ZookeeperService) BindInstance(request *v2.BindRequest) (map[string]interface{}, error) {
-	return nil, nil
+// AsyncBindings is true, the ACL Jobs of a bind or an unbind may outlast the timeout of a platform.
+func (z *ZookeeperService) AsyncBindings() bool {
+	return true
 }
 
-func (z *ZookeeperService) UnbindInstance(request *v2.UnbindRequest) error {
-	return nil
-}
\ No newline at end of file
+// ValidateBindParameters checks the chroot and keep_data parameters before the bind is accepted.
+func (z *ZookeeperService) ValidateBindParameters(params map[string]interface{}) error {
+	_, err := zookeeperBindingChroot("", params)
+	if err != nil {
+		return err
+	}
+	_, err = zookeeperKeepData(params)
+	return err
+}
+
+// BindInstance creates the subtree of the binding, at the chroot parameter or /bindings/<user>,
+// with a new digest user holding every permission of it. The connect string of the credentials is
+// chrooted to the subtree.
+func (z *ZookeeperService) BindInstance(instance *dao.Instance, request *v2.BindRequest, cluster kubernetes.Cluster) (map[string]interface{}, error) {
+	err := z.ValidateBindParameters(request.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	username := zookeeperBindingUser(request.BindingID)
+	chroot, _ := zookeeperBindingChroot(username, request.Parameters)
+	servers, err := zookeeperServers(instance, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// the parents are open to every client like the root, a chroot which exists is another binding's
+	user := &zookeeperUser{Username: username, Password: newZookeeperPassword(), Chroot: chroot}
+	var parents []string
+	for i := 1; i < len(chroot); i++ {
+		if chroot[i] == '/' {
+			parents = append(parents, "create "+chroot[:i])
+		}
+	}
+	err = runZookeeperScript(instance, cluster, &zookeeperScript{
+		Users:    []*zookeeperUser{user},
+		Prepare:  parents,
+		Commands: []string{fmt.Sprintf(`create %s "" %s`, chroot, zookeeperACL(user))},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		zookeeperUsername:      user.Username,
+		zookeeperPassword:      user.Password,
+		zookeeperChroot:        chroot,
+		zookeeperConnectString: servers + chroot,
+	}, nil
+}
+
+// UnbindInstance deletes the subtree of the binding. With the keep_data parameter the subtree is
+// kept but only reachable through localhost, from the pods of the instance.
+func (z *ZookeeperService) UnbindInstance(instance *dao.Instance, binding *dao.Binding, credentials map[string]interface{}, cluster kubernetes.Cluster) error {
+	user, err := zookeeperUserOf(credentials)
+	if err != nil {
+		// the bindings made before the users have nothing to remove
+		return nil
+	}
+	var params map[string]interface{}
+	if binding.Parameters != "" {
+		err = json.Unmarshal([]byte(binding.Parameters), &params)
+		if err != nil {
+			return err
+		}
+	}
+	keep, err := zookeeperKeepData(params)
+	if err != nil {
+		return err
+	}
+
+	command := "deleteall " + user.Chroot
+	if keep {
+		command = fmt.Sprintf("setAcl -R %s ip:127.0.0.1:cdrwa", user.Chroot)
+	}
+	return runZookeeperScript(instance, cluster, &zookeeperScript{
+		Users:    []*zookeeperUser{user},
+		Commands: []string{command},
+	})
+}
+
+// RotateBinding gives the user of the binding a new password, both passwords are in the ACL of the
+// subtree of the binding until the previous one is revoked.
+func (z *ZookeeperService) RotateBinding(instance *dao.Instance, binding *dao.Binding, credentials map[string]interface{}, cluster kubernetes.Cluster) (map[string]interface{}, error) {
+	user, err := zookeeperUserOf(credentials)
+	if err != nil {
+		return nil, fmt.Errorf("binding %s: %v", binding.BindingID, err)
+	}
+	rotated := &zookeeperUser{Username: user.Username, Password: newZookeeperPassword(), Chroot: user.Chroot}
+
+	err = runZookeeperScript(instance, cluster, &zookeeperScript{
+		Users:    []*zookeeperUser{user},
+		Commands: []string{fmt.Sprintf("setAcl -R %s %s", user.Chroot, zookeeperACL(user, rotated))},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rotatedCredentials := make(map[string]interface{}, len(credentials))
+	for key, value := range credentials {
+		rotatedCredentials[key] = value
+	}
+	rotatedCredentials[zookeeperPassword] = rotated.Password
+	return rotatedCredentials, nil
+}
+
+// RevokeBindingCredentials leaves the current password alone in the ACL of the subtree of the binding.
+// Both passwords authenticate, the znodes created with the previous one only grant it.
+func (z *ZookeeperService) RevokeBindingCredentials(instance *dao.Instance, binding *dao.Binding, current, previous map[string]interface{}, cluster kubernetes.Cluster) error {
+	user, err := zookeeperUserOf(current)
+	if err != nil {
+		return fmt.Errorf("binding %s: %v", binding.BindingID, err)
+	}
+	revoked, err := zookeeperUserOf(previous)
+	if err != nil {
+		return fmt.Errorf("binding %s: %v", binding.BindingID, err)
+	}
+
+	return runZookeeperScript(instance, cluster, &zookeeperScript{
+		Users:    []*zookeeperUser{user, revoked},
+		Commands: []string{fmt.Sprintf("setAcl -R %s %s", user.Chroot, zookeeperACL(user))},
+	})
+}
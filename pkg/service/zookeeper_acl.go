@@ -0,0 +1,276 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// The bindings of ZooKeeper authenticate with the digest scheme, the id of a user is
+// user:base64(sha1(user:password)), and own the subtree of znodes whose ACL lists their ids. The
+// ACLs are changed by zkCli.sh run in a Job beside the instance, authenticated as the users of the
+// binding which hold the admin permission of their subtree.
+
+// the credentials of a ZooKeeper binding, chroot is also the parameter choosing the subtree
+const (
+	zookeeperUsername      = "username"
+	zookeeperPassword      = "password"
+	zookeeperChroot        = "chroot"
+	zookeeperConnectString = "connect_string"
+	zookeeperKeepDataParam = "keep_data"
+)
+
+// zookeeperChrootPath is an absolute znode path whose names are safe in the zkCli.sh commands.
+var zookeeperChrootPath = regexp.MustCompile(`^(/[A-Za-z0-9_-][A-Za-z0-9_.-]*)+$`)
+
+// zookeeperBindingUser returns the digest user of a binding, the binding id with the characters
+// the digest scheme and zkCli.sh do not take replaced and the hash of the id appended, so the ids
+// which map to the same user, e.g. a/b and a-b, do not share the user and its chroot.
+func zookeeperBindingUser(bindingId string) string {
+	user := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '-'
+	}, bindingId)
+	sum := sha256.Sum256([]byte(bindingId))
+	return user + "-" + hex.EncodeToString(sum[:8])
+}
+
+// zookeeperBindingChroot returns the chroot parameter of a binding, /bindings/<user> without it.
+func zookeeperBindingChroot(username string, params map[string]interface{}) (string, error) {
+	value, ok := params[zookeeperChroot]
+	if !ok {
+		return "/bindings/" + username, nil
+	}
+	path, _ := value.(string)
+	if !zookeeperChrootPath.MatchString(path) || path == "/zookeeper" || strings.HasPrefix(path, "/zookeeper/") {
+		return "", ParameterError(fmt.Sprintf("chroot %v is not an absolute znode path outside of /zookeeper", value))
+	}
+	return path, nil
+}
+
+// zookeeperKeepData returns the keep_data parameter of a binding.
+func zookeeperKeepData(params map[string]interface{}) (bool, error) {
+	value, ok := params[zookeeperKeepDataParam]
+	if !ok {
+		return false, nil
+	}
+	keep, ok := value.(bool)
+	if !ok {
+		return false, ParameterError(fmt.Sprintf("keep_data %v is not a boolean", value))
+	}
+	return keep, nil
+}
+
+var zookeeperACLTemplate = template.Must(template.New("acl").Funcs(jobFuncs).Parse(`apiVersion: v1
+kind: Secret
+metadata:
+  name: zookeeper-acl-{{ .ID }}
+  namespace: {{ .Namespace }}
+  labels:
+    ruyiyun.servicebroker/job: "{{ .ID }}"
+type: Opaque
+stringData:
+  prepare: {{ printf "%q" .Prepare }}
+  commands: {{ printf "%q" .Commands }}
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: zookeeper-acl-{{ .ID }}
+  namespace: {{ .Namespace }}
+  labels:
+    ruyiyun.servicebroker/job: "{{ .ID }}"
+spec:
+  backoffLimit: 1
+  template:
+    metadata:
+      labels:
+        ruyiyun.servicebroker/job: "{{ .ID }}"
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: zkcli
+        image: {{ .Image }}
+        command: ["sh", "-c", {{ printf "%q" .Shell }}]
+        resources: {{ jobResources }}
+        volumeMounts:
+        - name: acl
+          mountPath: /acl
+          readOnly: true
+      volumes:
+      - name: acl
+        secret:
+          secretName: zookeeper-acl-{{ .ID }}
+`))
+
+type zookeeperACLJob struct {
+	ID        string
+	Namespace string
+	Image     string
+	Prepare   string
+	Commands  string
+	Shell     string
+}
+
+// zookeeperScript is what a Job runs with zkCli.sh, authenticated as the users. The failures of
+// Prepare are ignored, e.g. creating a znode which exists, a failure of Commands fails the Job.
+// zkCli.sh exits 0 when a command fails, the failures are found in its output.
+type zookeeperScript struct {
+	Users    []*zookeeperUser
+	Prepare  []string
+	Commands []string
+}
+
+// zookeeperFailures are the outputs of zkCli.sh for failed commands. A missing znode is not one, the
+// commands on it are retries of commands which removed it.
+const zookeeperFailures = "KeeperErrorCode|Insufficient permission|Authentication is not valid|Node already exists"
+
+func (s *zookeeperScript) render(commands []string) string {
+	var script bytes.Buffer
+	for _, u := range s.Users {
+		fmt.Fprintf(&script, "addauth digest %s:%s\n", u.Username, u.Password)
+	}
+	for _, command := range commands {
+		fmt.Fprintln(&script, command)
+	}
+	script.WriteString("quit\n")
+	return script.String()
+}
+
+// shell returns the command of the Job for the servers, the connect string of the instance.
+func (s *zookeeperScript) shell(servers string) string {
+	shell := ""
+	if len(s.Prepare) != 0 {
+		shell = fmt.Sprintf("zkCli.sh -server %s < /acl/prepare > /dev/null 2>&1; ", servers)
+	}
+	return shell + fmt.Sprintf("zkCli.sh -server %s < /acl/commands > /tmp/out 2>&1; cat /tmp/out; ! grep -qE '%s' /tmp/out",
+		servers, zookeeperFailures)
+}
+
+// zookeeperUser is the digest user of a binding and the root of its subtree.
+type zookeeperUser struct {
+	Username string
+	Password string
+	Chroot   string
+}
+
+// zookeeperUserOf returns the user of the credentials of a binding.
+func zookeeperUserOf(credentials map[string]interface{}) (*zookeeperUser, error) {
+	username, _ := credentials[zookeeperUsername].(string)
+	password, _ := credentials[zookeeperPassword].(string)
+	chroot, _ := credentials[zookeeperChroot].(string)
+	if username == "" || password == "" || chroot == "" {
+		return nil, fmt.Errorf("the credentials have no ZooKeeper user")
+	}
+	return &zookeeperUser{Username: username, Password: password, Chroot: chroot}, nil
+}
+
+// digest returns the id of the user in the digest scheme.
+func (u *zookeeperUser) digest() string {
+	sum := sha1.Sum([]byte(u.Username + ":" + u.Password))
+	return u.Username + ":" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// zookeeperACL gives every permission to the users.
+func zookeeperACL(users ...*zookeeperUser) string {
+	acl := make([]string, len(users))
+	for i, u := range users {
+		acl[i] = "digest:" + u.digest() + ":cdrwa"
+	}
+	return strings.Join(acl, ",")
+}
+
+func newZookeeperPassword() string {
+	password := make([]byte, 16)
+	rand.Read(password)
+	return hex.EncodeToString(password)
+}
+
+// runZookeeperScript runs the script against the instance in a Job.
+func runZookeeperScript(instance *dao.Instance, cluster kubernetes.Cluster, script *zookeeperScript) error {
+	image, err := zookeeperImage(instance.Yaml)
+	if err != nil {
+		return err
+	}
+	servers, err := zookeeperServers(instance, false)
+	if err != nil {
+		return err
+	}
+
+	id := newJobID()
+	manifests, err := executeTemplate(zookeeperACLTemplate, &zookeeperACLJob{
+		ID:        id,
+		Namespace: instance.Namespace,
+		Image:     image,
+		Prepare:   script.render(script.Prepare),
+		Commands:  script.render(script.Commands),
+		Shell:     script.shell(servers),
+	})
+	if err != nil {
+		return err
+	}
+	return runJobs(cluster, instance.Namespace, id, manifests)
+}
+
+// zookeeperServers returns the connect string of the client services of the instance, with the
+// namespace for the clients outside of it.
+func zookeeperServers(instance *dao.Instance, qualified bool) (string, error) {
+	nodes, err := zookeeperNodes(instance.Yaml)
+	if err != nil {
+		return "", err
+	}
+	if len(nodes) == 0 {
+		return "", fmt.Errorf("instance %s has no ZooKeeper nodes", instance.InstanceID)
+	}
+
+	servers := make([]string, len(nodes))
+	for i, node := range nodes {
+		if qualified {
+			servers[i] = fmt.Sprintf("%s-open.%s.svc:2181", node, instance.Namespace)
+		} else {
+			servers[i] = node + "-open:2181"
+		}
+	}
+	return strings.Join(servers, ","), nil
+}
+
+// zookeeperImage returns the image of the first ZooKeeper Deployment of the manifests, it has zkCli.sh.
+func zookeeperImage(manifests string) (string, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifests), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(obj)
+		if err == io.EOF {
+			return "", fmt.Errorf("the manifests have no ZooKeeper deployment")
+		}
+		if err != nil {
+			return "", err
+		}
+		if obj.GetKind() != "Deployment" {
+			continue
+		}
+
+		containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+		for _, c := range containers {
+			container, _ := c.(map[string]interface{})
+			if image, _ := container["image"].(string); image != "" {
+				return image, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/arugaki/osb-starter-pack/pkg/dao"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+var _ Backuper = &ZookeeperService{}
+
+// archiveImage runs tar in the backup and restore Jobs.
+const archiveImage = "busybox:1.36"
+
+// The backup archives the version-2 directories of the data and the transaction logs of one node,
+// the myid files are left out so the archive restores to every node. ZooKeeper replays the
+// transaction logs over the fuzzy snapshot when it starts, so a copy of a running node is
+// consistent up to the last transaction written.
+var zookeeperBackupTemplate = template.Must(template.New("backup").Funcs(jobFuncs).Parse(`apiVersion: v1
+kind: Secret
+metadata:
+  name: backup-{{ .BackupID }}
+  namespace: {{ .Namespace }}
+  labels:
+    ruyiyun.servicebroker/backup: "{{ .BackupID }}"
+type: Opaque
+stringData:
+  MC_HOST_target: {{ printf "%q" .Host }}
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: backup-{{ .BackupID }}
+  namespace: {{ .Namespace }}
+  labels:
+    ruyiyun.servicebroker/backup: "{{ .BackupID }}"
+spec:
+  backoffLimit: 2
+  template:
+    metadata:
+      labels:
+        ruyiyun.servicebroker/backup: "{{ .BackupID }}"
+    spec:
+      restartPolicy: Never
+      # the claims are ReadWriteOnce, the Job runs beside the pod of the node
+      affinity:
+        podAffinity:
+          requiredDuringSchedulingIgnoredDuringExecution:
+          - labelSelector:
+              matchLabels:
+                app: {{ .Node }}
+            topologyKey: "kubernetes.io/hostname"
+      initContainers:
+      - name: archive
+        image: {{ .ArchiveImage }}
+        command: ["tar", "czf", "/backup/zookeeper.tgz", "-C", "/", "data/version-2", "datalog/version-2"]
+        resources: {{ jobResources }}
+        volumeMounts:
+        - name: data
+          mountPath: /data
+          readOnly: true
+        - name: log
+          mountPath: /datalog
+          readOnly: true
+        - name: backup
+          mountPath: /backup
+      containers:
+      - name: upload
+        image: {{ .Image }}
+        args: ["cp", "/backup/zookeeper.tgz", "target/{{ .Bucket }}/{{ .Key }}"]
+        resources: {{ jobResources }}
+        envFrom:
+        - secretRef:
+            name: backup-{{ .BackupID }}
+        volumeMounts:
+        - name: backup
+          mountPath: /backup
+      volumes:
+      - name: data
+        persistentVolumeClaim:
+          claimName: {{ .Node }}-data
+          readOnly: true
+      - name: log
+        persistentVolumeClaim:
+          claimName: {{ .Node }}-log
+          readOnly: true
+      - name: backup
+        emptyDir: {}
+`))
+
+// The restore replaces the version-2 directories of every node by the archive, one Job per node.
+var zookeeperRestoreTemplate = template.Must(template.New("restore").Funcs(jobFuncs).Parse(`apiVersion: v1
+kind: Secret
+metadata:
+  name: restore-{{ .BackupID }}
+  namespace: {{ .Namespace }}
+  labels:
+    ruyiyun.servicebroker/restore: "{{ .BackupID }}"
+type: Opaque
+stringData:
+  MC_HOST_target: {{ printf "%q" .Host }}
+{{- range $i, $node := .Nodes }}
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: restore-{{ $.BackupID }}-{{ $i }}
+  namespace: {{ $.Namespace }}
+  labels:
+    ruyiyun.servicebroker/restore: "{{ $.BackupID }}"
+spec:
+  backoffLimit: 2
+  template:
+    metadata:
+      labels:
+        ruyiyun.servicebroker/restore: "{{ $.BackupID }}"
+    spec:
+      restartPolicy: Never
+      initContainers:
+      - name: download
+        image: {{ $.Image }}
+        args: ["cp", "target/{{ $.Bucket }}/{{ $.Key }}", "/backup/zookeeper.tgz"]
+        resources: {{ jobResources }}
+        envFrom:
+        - secretRef:
+            name: restore-{{ $.BackupID }}
+        volumeMounts:
+        - name: backup
+          mountPath: /backup
+      containers:
+      - name: extract
+        image: {{ $.ArchiveImage }}
+        command: ["sh", "-c", "rm -rf /data/version-2 /datalog/version-2 && tar xzf /backup/zookeeper.tgz -C /"]
+        resources: {{ jobResources }}
+        volumeMounts:
+        - name: data
+          mountPath: /data
+        - name: log
+          mountPath: /datalog
+        - name: backup
+          mountPath: /backup
+      volumes:
+      - name: data
+        persistentVolumeClaim:
+          claimName: {{ $node }}-data
+      - name: log
+        persistentVolumeClaim:
+          claimName: {{ $node }}-log
+      - name: backup
+        emptyDir: {}
+{{- end }}
+`))
+
+type zookeeperJobs struct {
+	BackupID     string
+	Namespace    string
+	Host         string
+	Bucket       string
+	Key          string
+	Image        string
+	ArchiveImage string
+	// Node is the node backed up, Nodes the nodes restored
+	Node  string
+	Nodes []string
+}
+
+func (z *ZookeeperService) BackupJobs(instance *dao.Instance, backupId, key string, target *BackupTarget) (string, error) {
+	jobs, err := newZookeeperJobs(instance, backupId, key, target)
+	if err != nil {
+		return "", err
+	}
+	// every node holds the committed data, the first one is backed up
+	jobs.Node = jobs.Nodes[0]
+	return executeTemplate(zookeeperBackupTemplate, jobs)
+}
+
+func (z *ZookeeperService) RestoreJobs(instance *dao.Instance, backupId, key string, target *BackupTarget) (string, error) {
+	jobs, err := newZookeeperJobs(instance, backupId, key, target)
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(zookeeperRestoreTemplate, jobs)
+}
+
+func newZookeeperJobs(instance *dao.Instance, backupId, key string, target *BackupTarget) (*zookeeperJobs, error) {
+	host, err := target.MCHost()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := zookeeperNodes(instance.Yaml)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("instance %s has no data volumes", instance.InstanceID)
+	}
+
+	return &zookeeperJobs{
+		BackupID:     backupId,
+		Namespace:    instance.Namespace,
+		Host:         host,
+		Bucket:       target.Bucket,
+		Key:          key,
+		Image:        target.image(),
+		ArchiveImage: archiveImage,
+		Nodes:        nodes,
+	}, nil
+}
+
+// zookeeperNodes returns the nodes of the manifests of an instance, named by the prefix of their
+// <node>-data and <node>-log claims.
+func zookeeperNodes(manifests string) ([]string, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifests), 4096)
+	var nodes []string
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(obj)
+		if err == io.EOF {
+			return nodes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if obj.GetKind() == "PersistentVolumeClaim" && strings.HasSuffix(obj.GetName(), "-data") {
+			nodes = append(nodes, strings.TrimSuffix(obj.GetName(), "-data"))
+		}
+	}
+}
+
+func executeTemplate(t *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
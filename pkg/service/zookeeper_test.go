@@ -0,0 +1,85 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+func TestZookeeperValues(t *testing.T) {
+	z := &ZookeeperService{}
+
+	params := map[string]interface{}{"ZOO_TICK_TIME": "2000\"", "ZOO_SYNC_LIMIT": 5.0}
+	values, err := z.ParameterValues(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, params) {
+		t.Fatalf("expect the parameters as they are, the template quotes them, got %v", values)
+	}
+
+	values, err = z.PlanValues(&v2.Plan{Metadata: map[string]interface{}{"bullets": []interface{}{"0.5", "1024", "1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"PLAN_REQUEST_CPU":    "0.5",
+		"PLAN_LIMIT_CPU":      "0.5",
+		"PLAN_REQUEST_MEMORY": "1024",
+		"PLAN_LIMIT_MEMORY":   "1024",
+		"PLAN_STORAGE_SIZE":   "1",
+	}
+	if !reflect.DeepEqual(values, expect) {
+		t.Fatalf("expect the quota of the bullets, got %v", values)
+	}
+	if _, err := z.PlanValues(&v2.Plan{}); err == nil {
+		t.Fatal("expect a plan without bullets rejected")
+	}
+
+	manifests := `apiVersion: v1
+kind: Service
+metadata:
+  name: zk-zookeeper01
+  namespace: ns
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: zk-zookeeper01-open
+  namespace: ns
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: zk-zookeeper02
+  namespace: ns
+---
+apiVersion: apps/v1beta1
+kind: Deployment
+metadata:
+  name: zk-zookeeper03
+  namespace: ns
+`
+	values, err = z.SpecialValues(manifests, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = map[string]interface{}{
+		"Zookeeper01": "zk-zookeeper01.ns.svc",
+		"Zookeeper02": "zk-zookeeper02.ns.svc",
+	}
+	if !reflect.DeepEqual(values, expect) {
+		t.Fatalf("expect the peers of the peer services, got %v", values)
+	}
+}
+
+func TestZookeeperBindingUser(t *testing.T) {
+	if zookeeperBindingUser("a/b") == zookeeperBindingUser("a-b") {
+		t.Fatal("expect the binding ids mapped to the same characters to have distinct users")
+	}
+	if user := zookeeperBindingUser("a/b"); !strings.HasPrefix(user, "a-b-") {
+		t.Fatalf("expect the user made of the binding id, got %s", user)
+	}
+}
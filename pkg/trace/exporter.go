@@ -0,0 +1,278 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	ExporterNone = ""
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+)
+
+// NewExporter returns the exporter of the given kind, nil for ExporterNone.
+func NewExporter(kind, endpoint, file, serviceName string) (Exporter, error) {
+	switch kind {
+	case ExporterNone:
+		return nil, nil
+	case ExporterOTLP:
+		return NewOTLPExporter(endpoint, serviceName), nil
+	case ExporterFile:
+		return NewFileExporter(file)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expect %q or %q", kind, ExporterOTLP, ExporterFile)
+	}
+}
+
+// spanRecord is the json form of a span written by the file exporter.
+type spanRecord struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	DurationMS   float64           `json:"duration_ms"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// FileExporter appends the spans to a local file as json lines, for offline debugging.
+type FileExporter struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+func (e *FileExporter) ExportSpan(span *Span) {
+	record := &spanRecord{
+		TraceID:    span.SpanContext.TraceID.String(),
+		SpanID:     span.SpanContext.SpanID.String(),
+		Name:       span.Name,
+		Start:      span.Start,
+		End:        span.End,
+		DurationMS: float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+		Attributes: span.Attributes,
+		Error:      span.Err,
+	}
+	if span.ParentSpanID != (SpanID{}) {
+		record.ParentSpanID = span.ParentSpanID.String()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	err := e.encoder.Encode(record)
+	if err != nil {
+		glog.Errorf("write span to trace file failed, err is %+v", err)
+	}
+}
+
+func (e *FileExporter) Shutdown() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+const (
+	otlpBatchSize     = 256
+	otlpQueueSize     = 4096
+	otlpFlushInterval = 5 * time.Second
+)
+
+// OTLPExporter sends the spans in batches to an OTLP/HTTP collector using the json encoding,
+// spans are dropped when the collector cannot keep up.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+
+	spans chan *Span
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewOTLPExporter returns an exporter posting to endpoint, e.g. http://collector:4318/v1/traces.
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		spans:       make(chan *Span, otlpQueueSize),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) ExportSpan(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+		glog.Warningf("trace export queue is full, span %s dropped", span.Name)
+	}
+}
+
+// Shutdown sends the buffered spans and stops the exporter.
+func (e *OTLPExporter) Shutdown() error {
+	e.once.Do(func() {
+		close(e.spans)
+	})
+	<-e.done
+	return nil
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, otlpBatchSize)
+	for {
+		select {
+		case span, ok := <-e.spans:
+			if !ok {
+				e.send(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) < otlpBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		e.send(batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *OTLPExporter) send(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(otlpRequest(e.serviceName, spans))
+	if err != nil {
+		glog.Errorf("marshal spans for otlp failed, err is %+v", err)
+		return
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		glog.Errorf("export spans to %s failed, err is %+v", e.endpoint, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		glog.Errorf("export spans to %s failed, status is %s", e.endpoint, resp.Status)
+	}
+}
+
+// The json mapping of the OTLP trace service request.
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOk         = 1
+	otlpStatusError      = 2
+)
+
+func otlpAttributes(attributes map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		kv := otlpKeyValue{Key: key}
+		kv.Value.StringValue = attributes[key]
+		kvs = append(kvs, kv)
+	}
+	return kvs
+}
+
+func otlpRequest(serviceName string, spans []*Span) map[string]interface{} {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.SpanContext.TraceID.String(),
+			SpanID:            span.SpanContext.SpanID.String(),
+			Name:              span.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: otlpStatusOk},
+		}
+		if span.ParentSpanID != (SpanID{}) {
+			s.ParentSpanID = span.ParentSpanID.String()
+		}
+		if span.Err != "" {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.Err}
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/arugaki/osb-starter-pack/pkg/trace"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
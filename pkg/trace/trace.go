@@ -0,0 +1,213 @@
+// Package trace records OpenTelemetry style spans of the broker operations. The W3C traceparent
+// header of the incoming requests is honoured so the spans join the trace of the platform.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the W3C trace context header.
+const TraceparentHeader = "traceparent"
+
+type TraceID [16]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a traceparent header value, ok is false when it is malformed.
+func ParseTraceparent(value string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	// version 00 has exactly four fields, later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) {
+		return sc, false
+	}
+
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+
+	return sc, sc.IsValid()
+}
+
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Span is one timed step of an operation.
+type Span struct {
+	Name         string
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Err          string
+
+	mu    sync.Mutex
+	ended bool
+}
+
+// SetAttribute annotates the span, the value is formatted with %v.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = fmt.Sprintf("%v", value)
+}
+
+// Finish ends the span and hands it to the exporter, a non nil err marks the span failed.
+// Only the first call has an effect.
+func (s *Span) Finish(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	if err != nil {
+		s.Err = err.Error()
+	}
+	s.mu.Unlock()
+
+	if e := getExporter(); e != nil && s.SpanContext.Sampled {
+		e.ExportSpan(s)
+	}
+}
+
+// Exporter ships finished spans, ExportSpan must not block the operation.
+type Exporter interface {
+	ExportSpan(span *Span)
+	Shutdown() error
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   Exporter
+)
+
+// SetExporter sets the exporter of the finished spans, nil disables the export.
+func SetExporter(e Exporter) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	exporter = e
+}
+
+func getExporter() Exporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
+
+// Shutdown flushes the spans still buffered by the exporter.
+func Shutdown() error {
+	e := getExporter()
+	if e == nil {
+		return nil
+	}
+	return e.Shutdown()
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// Start starts a span as the child of the span in ctx, or of the remote parent extracted from
+// the request, or as the root of a new trace.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartAt(ctx, name, time.Now())
+}
+
+// StartAt starts a span at the given time, for steps timed before the span could be started.
+func StartAt(ctx context.Context, name string, start time.Time) (context.Context, *Span) {
+	span := &Span{
+		Name:       name,
+		Start:      start,
+		Attributes: make(map[string]string),
+	}
+
+	if parent := FromContext(ctx); parent != nil {
+		span.SpanContext.TraceID = parent.SpanContext.TraceID
+		span.SpanContext.Sampled = parent.SpanContext.Sampled
+		span.ParentSpanID = parent.SpanContext.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		span.SpanContext.TraceID = remote.TraceID
+		span.SpanContext.Sampled = remote.Sampled
+		span.ParentSpanID = remote.SpanID
+	} else {
+		rand.Read(span.SpanContext.TraceID[:])
+		span.SpanContext.Sampled = true
+	}
+	rand.Read(span.SpanContext.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the current span of ctx, nil when there is none.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Extract returns a context carrying the remote parent of the traceparent header, ctx itself
+// when the header is absent or malformed.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := ParseTraceparent(header.Get(TraceparentHeader))
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inject sets the traceparent header to the current span of ctx.
+func Inject(ctx context.Context, header http.Header) {
+	if span := FromContext(ctx); span != nil {
+		header.Set(TraceparentHeader, span.SpanContext.Traceparent())
+	}
+}
@@ -0,0 +1,52 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	sc, ok := ParseTraceparent(value)
+	if !ok {
+		t.Fatalf("expect %s parsed", value)
+	}
+	if !sc.Sampled {
+		t.Fatal("expect the sampled flag set")
+	}
+	if sc.Traceparent() != value {
+		t.Fatalf("expect %s formatted back, got %s", value, sc.Traceparent())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(invalid); ok {
+			t.Fatalf("expect %q rejected", invalid)
+		}
+	}
+}
+
+func TestStartJoinsRemoteParent(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, root := Start(Extract(context.Background(), header), "root")
+	if root.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expect the remote trace id, got %s", root.SpanContext.TraceID)
+	}
+	if root.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("expect the remote parent span id, got %s", root.ParentSpanID)
+	}
+
+	_, child := Start(ctx, "child")
+	if child.SpanContext.TraceID != root.SpanContext.TraceID || child.ParentSpanID != root.SpanContext.SpanID {
+		t.Fatal("expect the child span under the root span")
+	}
+}
@@ -0,0 +1,156 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateFuncs are the functions of the templates of the services, quote puts a value into the
+// yaml as a double quoted string.
+var TemplateFuncs = template.FuncMap{
+	"quote": Quote,
+}
+
+// Quote returns the value as a double quoted yaml string, a json string is one. Whatever the value
+// holds, quotes, new lines or template actions, it stays inside the string.
+func Quote(value interface{}) string {
+	s, ok := value.(string)
+	if !ok {
+		s = fmt.Sprintf("%v", value)
+	}
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// ExecutePartialTemplate executes the actions of t whose fields are all in data and keeps the
+// other actions as they are, for the values which are only known later. The output is not a
+// template to execute again: it holds the values as they are, render the source with all of them.
+func ExecutePartialTemplate(t string, data map[string]interface{}) (string, error) {
+	tmpl, err := template.New("tmpl").Funcs(TemplateFuncs).Parse(t)
+	if err != nil {
+		return "", err
+	}
+	if tmpl.Tree == nil {
+		return t, nil
+	}
+
+	var buf bytes.Buffer
+	for _, node := range tmpl.Tree.Root.Nodes {
+		if text, ok := node.(*parse.TextNode); ok {
+			buf.Write(text.Text)
+			continue
+		}
+
+		fields := make(map[string]bool)
+		if !collectFields(node, fields) || !covered(fields, data) {
+			buf.WriteString(node.String())
+			continue
+		}
+
+		action, err := template.New("action").Funcs(TemplateFuncs).Option("missingkey=error").Parse(node.String())
+		if err != nil {
+			return "", err
+		}
+		err = action.Execute(&buf, data)
+		if err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// TemplateFields returns the sorted fields of data the actions of t refer to.
+func TemplateFields(t string) ([]string, error) {
+	tmpl, err := template.New("tmpl").Funcs(TemplateFuncs).Parse(t)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Tree == nil {
+		return nil, nil
+	}
+
+	fields := make(map[string]bool)
+	collectFields(tmpl.Tree.Root, fields)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// MissingFields returns the sorted fields of data the actions of t refer to which data does not hold.
+func MissingFields(t string, data map[string]interface{}) ([]string, error) {
+	fields, err := TemplateFields(t)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, name := range fields {
+		if _, ok := data[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+func covered(fields map[string]bool, data map[string]interface{}) bool {
+	for name := range fields {
+		if _, ok := data[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// collectFields adds the top level fields a node refers to, false when the node depends on
+// more than named fields, e.g. the whole dot or another template.
+func collectFields(node parse.Node, fields map[string]bool) bool {
+	ok := true
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return true
+		}
+		for _, child := range n.Nodes {
+			ok = collectFields(child, fields) && ok
+		}
+	case *parse.ActionNode:
+		ok = collectFields(n.Pipe, fields)
+	case *parse.IfNode:
+		ok = collectFields(n.Pipe, fields) && collectFields(n.List, fields) && collectFields(n.ElseList, fields)
+	case *parse.RangeNode:
+		// the dot of the body is an element, not the data
+		ok = collectFields(n.Pipe, fields) && collectFields(n.ElseList, fields)
+	case *parse.WithNode:
+		ok = collectFields(n.Pipe, fields) && collectFields(n.ElseList, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return true
+		}
+		for _, cmd := range n.Cmds {
+			ok = collectFields(cmd, fields) && ok
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			ok = collectFields(arg, fields) && ok
+		}
+	case *parse.ChainNode:
+		ok = collectFields(n.Node, fields)
+	case *parse.FieldNode:
+		fields[n.Ident[0]] = true
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			fields[n.Ident[1]] = true
+		} else if n.Ident[0] == "$" {
+			ok = false
+		}
+	case *parse.DotNode, *parse.TemplateNode:
+		ok = false
+	}
+	return ok
+}
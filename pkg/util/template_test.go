@@ -0,0 +1,51 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExecutePartialTemplate(t *testing.T) {
+	src := "name: {{ .Name }}-{{ .Id }}\n{{ if .Class }}class: {{ .Class }}\n{{ end }}cpu: {{ .CPU }}\n"
+
+	partial, err := ExecutePartialTemplate(src, map[string]interface{}{"Name": "zk", "Id": "1", "Class": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partial != "name: zk-1\ncpu: {{.CPU}}\n" {
+		t.Fatalf("unexpected partial rendering %q", partial)
+	}
+
+	missing, err := MissingFields(src, map[string]interface{}{"Name": "zk", "Id": "1", "Class": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(missing, []string{"CPU"}) {
+		t.Fatalf("expect CPU missing, got %v", missing)
+	}
+
+	rendered, err := ExecutePartialTemplate(src, map[string]interface{}{"Name": "zk", "Id": "1", "Class": "", "CPU": "0.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered != "name: zk-1\ncpu: 0.5\n" {
+		t.Fatalf("unexpected rendering %q", rendered)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	src := "value: {{ quote .Value }}\nother: {{ .Other }}\n"
+
+	rendered, err := ExecutePartialTemplate(src, map[string]interface{}{"Value": "1\"\nprivileged: true {{ .Other }}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "value: \"1\\\"\\nprivileged: true {{ .Other }}\"\nother: {{.Other}}\n"
+	if rendered != expect {
+		t.Fatalf("expect the value kept in its string, got %q", rendered)
+	}
+
+	if quoted := Quote(4000.0); quoted != `"4000"` {
+		t.Fatalf("expect a number quoted as a string, got %s", quoted)
+	}
+}
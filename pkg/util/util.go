@@ -32,8 +32,16 @@ func GetStringParam(params map[string]interface{}, name string) (string, error)
 
 func GetQuotaFromPlan(plan *v2.Plan) (string, string, string, error) {
 	if bullets, ok := plan.Metadata["bullets"]; ok {
-		quota, ok := bullets.([]string)
-		if !ok {
+		var quota []string
+		switch b := bullets.(type) {
+		case []string:
+			quota = b
+		case []interface{}:
+			// bullets decoded from the json catalog
+			for _, q := range b {
+				quota = append(quota, fmt.Sprintf("%v", q))
+			}
+		default:
 			return "", "", "", fmt.Errorf("unexpects bullets in plan")
 		}
 		if len(quota) != 3 {
@@ -43,4 +51,4 @@ func GetQuotaFromPlan(plan *v2.Plan) (string, string, string, error) {
 	}
 
 	return "", "", "", fmt.Errorf("no bullets in plan")
-}
\ No newline at end of file
+}
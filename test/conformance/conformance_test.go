@@ -0,0 +1,547 @@
+// Package conformance drives the OSB api of the broker, served by osb-broker-lib as in production,
+// on the fake store and cluster. Every test runs for each api version the OSB client speaks and
+// the lifecycle tests for every service and plan of the catalog.
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arugaki/osb-starter-pack/pkg/broker"
+	daofake "github.com/arugaki/osb-starter-pack/pkg/dao/fake"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes"
+	"github.com/arugaki/osb-starter-pack/pkg/kubernetes/fake"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/metrics"
+	"github.com/pmorie/osb-broker-lib/pkg/rest"
+	"github.com/pmorie/osb-broker-lib/pkg/server"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var versions = []osb.APIVersion{osb.Version2_11(), osb.Version2_12(), osb.Version2_13()}
+
+// asyncBindingVersion introduces the asynchronous bindings, the client does not speak it yet.
+const asyncBindingVersion = "2.14"
+
+type harness struct {
+	t       *testing.T
+	version osb.APIVersion
+	server  *httptest.Server
+	client  osb.Client
+	store   *daofake.Store
+	cluster *fake.Cluster
+	catalog []osb.Service
+}
+
+func newHarness(t *testing.T, version osb.APIVersion) *harness {
+	store := daofake.NewStore()
+	cluster := fake.NewCluster()
+	options := broker.Options{
+		AllowParameterNamespace: true,
+		InstanceLockTTL:         time.Minute,
+	}
+	logic, err := broker.NewBusinessLogicWithBackends(options, nil, store, kubernetes.NewClusters(cluster, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api, err := rest.NewAPISurface(logic, metrics.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := server.New(api, prometheus.NewRegistry()).Router
+	broker.RegisterBindingAPI(router, api, logic)
+	s := httptest.NewServer(router)
+
+	config := osb.DefaultClientConfiguration()
+	config.URL = s.URL
+	config.APIVersion = version
+	client, err := osb.NewClient(config)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+
+	return &harness{
+		t:       t,
+		version: version,
+		server:  s,
+		client:  client,
+		store:   store,
+		cluster: cluster,
+		catalog: logic.Catalog(),
+	}
+}
+
+func (h *harness) close() {
+	h.server.Close()
+}
+
+// forEachVersion runs the test against a new broker for each api version.
+func forEachVersion(t *testing.T, test func(t *testing.T, h *harness)) {
+	for _, version := range versions {
+		version := version
+		t.Run(version.HeaderValue(), func(t *testing.T) {
+			h := newHarness(t, version)
+			defer h.close()
+			test(t, h)
+		})
+	}
+}
+
+// forEachPlan runs the test against a new broker for each api version, service and plan.
+func forEachPlan(t *testing.T, test func(t *testing.T, h *harness, service osb.Service, plan osb.Plan)) {
+	forEachVersion(t, func(t *testing.T, h *harness) {
+		for _, service := range h.catalog {
+			for _, plan := range service.Plans {
+				service, plan := service, plan
+				t.Run(service.Name+"/"+plan.Name, func(t *testing.T) {
+					h := newHarness(t, h.version)
+					defer h.close()
+					test(t, h, service, plan)
+				})
+			}
+		}
+	})
+}
+
+// response is a raw answer of the broker.
+type response struct {
+	status int
+	body   map[string]interface{}
+}
+
+// do sends a raw request with the api version of the harness, the answer must be json.
+func (h *harness) do(method, path string, body interface{}) *response {
+	return h.doWithVersion(h.version.HeaderValue(), method, path, body)
+}
+
+func (h *harness) doWithVersion(version, method, path string, body interface{}) *response {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, h.server.URL+path, reader)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if version != "" {
+		request.Header.Set(osb.APIVersionHeader, version)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		h.t.Fatalf("%s %s: expect a json answer, got content type %q", method, path, contentType)
+	}
+	r := &response{status: resp.StatusCode}
+	err = json.NewDecoder(resp.Body).Decode(&r.body)
+	if err != nil && err != io.EOF {
+		h.t.Fatalf("%s %s: decode the answer: %v", method, path, err)
+	}
+	return r
+}
+
+// expect fails the test unless the answer has the status and, when not empty, the OSB error code.
+func (r *response) expect(t *testing.T, status int, errorCode string) {
+	t.Helper()
+	if r.status != status {
+		t.Fatalf("expect status %d, got %d %v", status, r.status, r.body)
+	}
+	if errorCode != "" && r.body["error"] != errorCode {
+		t.Fatalf("expect error %q, got %v", errorCode, r.body)
+	}
+}
+
+func expectStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	statusErr, ok := osb.IsHTTPError(err)
+	if !ok || statusErr.StatusCode != status {
+		t.Fatalf("expect status %d, got %v", status, err)
+	}
+}
+
+func instancePath(instanceId string) string {
+	return "/v2/service_instances/" + instanceId
+}
+
+// provisionBody is the provision of the conformance instance with the extra parameters.
+func provisionBody(service osb.Service, plan osb.Plan, params map[string]interface{}) map[string]interface{} {
+	parameters := map[string]interface{}{
+		"NAMESPACE":     "conformance",
+		"INSTANCE_NAME": "conformance",
+	}
+	for name, value := range params {
+		parameters[name] = value
+	}
+	return map[string]interface{}{
+		"service_id":        service.ID,
+		"plan_id":           plan.ID,
+		"organization_guid": "organization",
+		"space_guid":        "space",
+		"parameters":        parameters,
+		"context": map[string]interface{}{
+			"platform":  "kubernetes",
+			"namespace": "conformance",
+		},
+	}
+}
+
+func (h *harness) provision(t *testing.T, instanceId string, service osb.Service, plan osb.Plan) {
+	t.Helper()
+	body := provisionBody(service, plan, nil)
+	response, err := h.client.ProvisionInstance(&osb.ProvisionRequest{
+		InstanceID:        instanceId,
+		ServiceID:         service.ID,
+		PlanID:            plan.ID,
+		OrganizationGUID:  "organization",
+		SpaceGUID:         "space",
+		AcceptsIncomplete: true,
+		Parameters:        body["parameters"].(map[string]interface{}),
+		Context:           body["context"].(map[string]interface{}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Async || response.OperationKey == nil {
+		t.Fatalf("expect an async provision with an operation, got %+v", response)
+	}
+}
+
+// poll polls last operation until it is not in progress, it gives up after a few attempts as the
+// fake cluster answers at once.
+func (h *harness) poll(t *testing.T, instanceId string) osb.LastOperationState {
+	t.Helper()
+	for i := 0; i < 5; i++ {
+		response, err := h.client.PollLastOperation(&osb.LastOperationRequest{InstanceID: instanceId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.State != osb.StateInProgress {
+			return response.State
+		}
+	}
+	t.Fatalf("instance %s stays in progress", instanceId)
+	return ""
+}
+
+// bind binds as a platform which accepts asynchronous bindings and returns the credentials, fetched
+// once the bind succeeded when it is asynchronous.
+func (h *harness) bind(t *testing.T, path string, service osb.Service, plan osb.Plan) map[string]interface{} {
+	t.Helper()
+	r := h.doWithVersion(asyncBindingVersion, http.MethodPut, path+"?accepts_incomplete=true", map[string]interface{}{
+		"service_id": service.ID,
+		"plan_id":    plan.ID,
+	})
+	if r.status != http.StatusAccepted {
+		r.expect(t, http.StatusCreated, "")
+		credentials, _ := r.body["credentials"].(map[string]interface{})
+		return credentials
+	}
+
+	r = h.pollBinding(t, path)
+	r.expect(t, http.StatusOK, "")
+	if r.body["state"] != string(osb.StateSucceeded) {
+		t.Fatalf("expect the bind to succeed, got %v", r.body)
+	}
+	r = h.doWithVersion(asyncBindingVersion, http.MethodGet, path, nil)
+	r.expect(t, http.StatusOK, "")
+	credentials, _ := r.body["credentials"].(map[string]interface{})
+	return credentials
+}
+
+// unbind unbinds as a platform which accepts asynchronous bindings, until the binding is gone.
+func (h *harness) unbind(t *testing.T, path string, service osb.Service, plan osb.Plan) {
+	t.Helper()
+	r := h.doWithVersion(asyncBindingVersion, http.MethodDelete,
+		fmt.Sprintf("%s?accepts_incomplete=true&service_id=%s&plan_id=%s", path, service.ID, plan.ID), nil)
+	if r.status != http.StatusAccepted {
+		r.expect(t, http.StatusOK, "")
+		return
+	}
+	h.pollBinding(t, path).expect(t, http.StatusGone, "")
+}
+
+// pollBinding polls the last operation of the binding until it is not in progress, the operations
+// run in the background of the broker.
+func (h *harness) pollBinding(t *testing.T, path string) *response {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		r := h.doWithVersion(asyncBindingVersion, http.MethodGet, path+"/last_operation", nil)
+		if r.status != http.StatusOK || r.body["state"] != string(osb.StateInProgress) {
+			return r
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("binding %s stays in progress", path)
+	return nil
+}
+
+func TestAPIVersionHeader(t *testing.T) {
+	h := newHarness(t, osb.Version2_13())
+	defer h.close()
+
+	for _, version := range []string{"", "2.10", "2.18", "3.0", "latest"} {
+		h.doWithVersion(version, http.MethodGet, "/v2/catalog", nil).expect(t, http.StatusPreconditionFailed, "")
+		h.doWithVersion(version, http.MethodPut, instancePath("version")+"?accepts_incomplete=true",
+			provisionBody(h.catalog[0], h.catalog[0].Plans[0], nil)).expect(t, http.StatusPreconditionFailed, "")
+	}
+	for _, version := range []string{"2.11", "2.14", "2.17"} {
+		h.doWithVersion(version, http.MethodGet, "/v2/catalog", nil).expect(t, http.StatusOK, "")
+	}
+	if len(h.cluster.Objects()) != 0 {
+		t.Fatal("expect nothing created with an unsupported version")
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	forEachVersion(t, func(t *testing.T, h *harness) {
+		h.do(http.MethodGet, "/v2/catalog", nil).expect(t, http.StatusOK, "")
+
+		catalog, err := h.client.GetCatalog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(catalog.Services) == 0 {
+			t.Fatal("expect services in the catalog")
+		}
+
+		ids := make(map[string]bool)
+		for _, service := range catalog.Services {
+			if service.ID == "" || service.Name == "" || service.Description == "" || len(service.Plans) == 0 {
+				t.Errorf("service %q misses a required field", service.Name)
+			}
+			if service.BindingsRetrievable {
+				t.Errorf("service %s: bindings_retrievable requires 2.14", service.Name)
+			}
+			for _, plan := range service.Plans {
+				if plan.ID == "" || plan.Name == "" || plan.Description == "" {
+					t.Errorf("plan %q of %s misses a required field", plan.Name, service.Name)
+				}
+				if ids[plan.ID] {
+					t.Errorf("plan id %s is not unique", plan.ID)
+				}
+				ids[plan.ID] = true
+			}
+		}
+	})
+}
+
+func TestLifecycle(t *testing.T) {
+	forEachPlan(t, func(t *testing.T, h *harness, service osb.Service, plan osb.Plan) {
+		instanceId := "lifecycle"
+		h.cluster.SetCreating(instanceId, true)
+		h.provision(t, instanceId, service, plan)
+		path := instancePath(instanceId) + "?accepts_incomplete=true"
+
+		// a retry is accepted again while the instance is in progress, then it exists
+		h.do(http.MethodPut, path, provisionBody(service, plan, nil)).expect(t, http.StatusAccepted, "")
+		response, err := h.client.PollLastOperation(&osb.LastOperationRequest{InstanceID: instanceId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.State != osb.StateInProgress {
+			t.Fatalf("expect the instance in progress while its pods are created, got %s", response.State)
+		}
+		h.cluster.SetCreating(instanceId, false)
+		if state := h.poll(t, instanceId); state != osb.StateSucceeded {
+			t.Fatalf("expect the provision to succeed, got %s", state)
+		}
+		h.do(http.MethodPut, path, provisionBody(service, plan, nil)).expect(t, http.StatusOK, "")
+		h.do(http.MethodPut, path, provisionBody(service, plan, map[string]interface{}{"conflict": true})).
+			expect(t, http.StatusConflict, "")
+
+		update, err := h.client.UpdateInstance(&osb.UpdateInstanceRequest{
+			InstanceID:        instanceId,
+			ServiceID:         service.ID,
+			PlanID:            &plan.ID,
+			AcceptsIncomplete: true,
+			Parameters:        provisionBody(service, plan, nil)["parameters"].(map[string]interface{}),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !update.Async {
+			t.Fatal("expect an async update")
+		}
+		if state := h.poll(t, instanceId); state != osb.StateSucceeded {
+			t.Fatalf("expect the update to succeed, got %s", state)
+		}
+
+		bindingPath := instancePath(instanceId) + "/service_bindings/binding"
+		if credentials := h.bind(t, bindingPath, service, plan); len(credentials) == 0 {
+			t.Fatal("expect the credentials of the binding")
+		}
+		// the retry of a bind which succeeded gets the binding whatever the platform accepts
+		h.do(http.MethodPut, bindingPath, map[string]interface{}{
+			"service_id": service.ID,
+			"plan_id":    plan.ID,
+		}).expect(t, http.StatusOK, "")
+		h.unbind(t, bindingPath, service, plan)
+		h.doWithVersion(asyncBindingVersion, http.MethodGet, bindingPath, nil).expect(t, http.StatusNotFound, "")
+
+		deprovisionPath := fmt.Sprintf("%s&service_id=%s&plan_id=%s", path, service.ID, plan.ID)
+		h.do(http.MethodDelete, deprovisionPath, nil).expect(t, http.StatusOK, "")
+		if objects := h.cluster.Objects(); len(objects) != 0 {
+			t.Fatalf("expect every object deleted, %d left", len(objects))
+		}
+		h.do(http.MethodDelete, deprovisionPath, nil).expect(t, http.StatusGone, "")
+		h.do(http.MethodGet, instancePath(instanceId)+"/last_operation", nil).expect(t, http.StatusGone, "")
+	})
+}
+
+func TestFailedPods(t *testing.T) {
+	forEachPlan(t, func(t *testing.T, h *harness, service osb.Service, plan osb.Plan) {
+		h.cluster.SetFailed("failed", true)
+		h.provision(t, "failed", service, plan)
+		if state := h.poll(t, "failed"); state != osb.StateFailed {
+			t.Fatalf("expect the provision to fail with its pods, got %s", state)
+		}
+	})
+}
+
+func TestProvisionErrors(t *testing.T) {
+	forEachVersion(t, func(t *testing.T, h *harness) {
+		service, plan := h.catalog[0], h.catalog[0].Plans[0]
+		path := instancePath("errors")
+
+		h.do(http.MethodPut, path, provisionBody(service, plan, nil)).
+			expect(t, http.StatusUnprocessableEntity, broker.ErrorAsyncRequired)
+
+		unknownService := provisionBody(service, plan, nil)
+		unknownService["service_id"] = "unknown"
+		h.do(http.MethodPut, path+"?accepts_incomplete=true", unknownService).expect(t, http.StatusBadRequest, "")
+
+		unknownPlan := provisionBody(service, plan, nil)
+		unknownPlan["plan_id"] = "unknown"
+		h.do(http.MethodPut, path+"?accepts_incomplete=true", unknownPlan).expect(t, http.StatusBadRequest, "")
+
+		noName := provisionBody(service, plan, nil)
+		delete(noName["parameters"].(map[string]interface{}), "INSTANCE_NAME")
+		h.do(http.MethodPut, path+"?accepts_incomplete=true", noName).expect(t, http.StatusBadRequest, "")
+
+		unknownCluster := provisionBody(service, plan, map[string]interface{}{"CLUSTER": "unknown"})
+		h.do(http.MethodPut, path+"?accepts_incomplete=true", unknownCluster).expect(t, http.StatusUnprocessableEntity, "")
+
+		if len(h.cluster.Objects()) != 0 {
+			t.Fatal("expect nothing created by a rejected provision")
+		}
+	})
+}
+
+func TestUpdateErrors(t *testing.T) {
+	forEachVersion(t, func(t *testing.T, h *harness) {
+		service, plan := h.catalog[0], h.catalog[0].Plans[0]
+		update := func(instanceId string, body map[string]interface{}) *response {
+			body["service_id"] = service.ID
+			return h.do(http.MethodPatch, instancePath(instanceId)+"?accepts_incomplete=true", body)
+		}
+
+		update("unknown", map[string]interface{}{}).expect(t, http.StatusGone, "")
+
+		h.provision(t, "update", service, plan)
+		h.poll(t, "update")
+
+		h.do(http.MethodPatch, instancePath("update"), map[string]interface{}{
+			"service_id": service.ID,
+			"parameters": map[string]interface{}{"ZOO_TICK_TIME": 4000},
+		}).expect(t, http.StatusUnprocessableEntity, broker.ErrorAsyncRequired)
+		update("update", map[string]interface{}{"plan_id": "unknown"}).expect(t, http.StatusBadRequest, "")
+		update("update", map[string]interface{}{
+			"parameters": map[string]interface{}{"CLUSTER": "other"},
+		}).expect(t, http.StatusUnprocessableEntity, "")
+	})
+}
+
+func TestBindingErrors(t *testing.T) {
+	forEachVersion(t, func(t *testing.T, h *harness) {
+		path := instancePath("binding") + "/service_bindings/binding"
+		h.do(http.MethodPut, path, map[string]interface{}{
+			"service_id": "unknown",
+			"plan_id":    "unknown",
+		}).expect(t, http.StatusBadRequest, "")
+		h.do(http.MethodDelete, path+"?service_id=unknown&plan_id=unknown", nil).expect(t, http.StatusBadRequest, "")
+
+		service, plan := h.catalog[0], h.catalog[0].Plans[0]
+		h.do(http.MethodPut, path, map[string]interface{}{
+			"service_id": service.ID,
+			"plan_id":    plan.ID,
+		}).expect(t, http.StatusNotFound, "")
+		h.do(http.MethodDelete, path+"?service_id="+service.ID+"&plan_id="+plan.ID, nil).expect(t, http.StatusGone, "")
+		h.doWithVersion(asyncBindingVersion, http.MethodGet, path+"/last_operation", nil).expect(t, http.StatusGone, "")
+
+		// zookeeper, the first service, binds asynchronously which the platforms before 2.14 can not
+		h.provision(t, "binding", service, plan)
+		h.poll(t, "binding")
+		h.do(http.MethodPut, path+"?accepts_incomplete=true", map[string]interface{}{
+			"service_id": service.ID,
+			"plan_id":    plan.ID,
+		}).expect(t, http.StatusUnprocessableEntity, broker.ErrorAsyncRequired)
+		h.doWithVersion(asyncBindingVersion, http.MethodPut, path, map[string]interface{}{
+			"service_id": service.ID,
+			"plan_id":    plan.ID,
+		}).expect(t, http.StatusUnprocessableEntity, broker.ErrorAsyncRequired)
+	})
+}
+
+// TestOrphanMitigation fails a provision half way, the platform then deprovisions the instance it
+// does not know the state of and must be able to provision it again.
+func TestOrphanMitigation(t *testing.T) {
+	forEachPlan(t, func(t *testing.T, h *harness, service osb.Service, plan osb.Plan) {
+		path := instancePath("orphan") + "?accepts_incomplete=true"
+
+		h.cluster.SetError("CreateInstance", errors.New("admission webhook denied the request"))
+		h.do(http.MethodPut, path, provisionBody(service, plan, nil)).expect(t, http.StatusInternalServerError, "")
+		if objects := h.cluster.Objects(); len(objects) != 0 {
+			t.Fatalf("expect the objects of the failed provision deleted, %d left", len(objects))
+		}
+
+		h.do(http.MethodDelete, fmt.Sprintf("%s&service_id=%s&plan_id=%s", path, service.ID, plan.ID), nil).
+			expect(t, http.StatusGone, "")
+
+		h.cluster.SetError("CreateInstance", nil)
+		h.do(http.MethodPut, path, provisionBody(service, plan, nil)).expect(t, http.StatusAccepted, "")
+	})
+}
+
+func TestConcurrentOperation(t *testing.T) {
+	forEachVersion(t, func(t *testing.T, h *harness) {
+		service, plan := h.catalog[0], h.catalog[0].Plans[0]
+
+		_, err := h.store.AcquireInstanceLock("locked", "update", "another replica", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.do(http.MethodPut, instancePath("locked")+"?accepts_incomplete=true", provisionBody(service, plan, nil)).
+			expect(t, http.StatusUnprocessableEntity, broker.ErrorConcurrency)
+	})
+}
+
+func TestStoreUnavailable(t *testing.T) {
+	forEachVersion(t, func(t *testing.T, h *harness) {
+		h.store.SetError("SelectInstance", errors.New("connection refused"))
+
+		_, err := h.client.PollLastOperation(&osb.LastOperationRequest{InstanceID: "unavailable"})
+		expectStatus(t, err, http.StatusServiceUnavailable)
+		h.do(http.MethodGet, instancePath("unavailable")+"/last_operation", nil).
+			expect(t, http.StatusServiceUnavailable, "")
+	})
+}